@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/base32"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	_ "github.com/lib/pq" //PostgreSQL driver
+	"github.com/wonder-wonder/cakemix-server/util"
 )
 
 // IDType is enum of types of ID
@@ -37,21 +39,116 @@ const (
 	sizeComment     = 10
 )
 
+const (
+	pingTimeout      = 5  // Sec
+	pingBackoffStart = 1  // Sec
+	pingBackoffMax   = 30 // Sec
+)
+
 // DB holds DB connection
 type DB struct {
 	db *sql.DB
 }
 
+// Config is structure for DB connection configuration
+type Config struct {
+	Host string
+	Port string
+	User string
+	Pass string
+	Name string
+	// URL is full connection URL (postgres://...). Other connection params are ignored if it's set.
+	URL string
+	// TLS
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+	// Connection pool
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// DSN returns data source name for the postgres driver
+func (c Config) DSN() string {
+	if c.URL != "" {
+		return c.URL
+	}
+	sslmode := c.SSLMode
+	if sslmode == "" {
+		sslmode = "disable"
+	}
+	params := [][2]string{
+		{"host", c.Host},
+		{"port", c.Port},
+		{"user", c.User},
+		{"dbname", c.Name},
+		{"password", c.Pass},
+		{"sslmode", sslmode},
+		{"sslrootcert", c.SSLRootCert},
+		{"sslcert", c.SSLCert},
+		{"sslkey", c.SSLKey},
+	}
+	dsn := []string{}
+	for _, v := range params {
+		if v[1] == "" {
+			continue
+		}
+		dsn = append(dsn, v[0]+"="+quoteDSNValue(v[1]))
+	}
+	return strings.Join(dsn, " ")
+}
+
+func quoteDSNValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
+
 // OpenDB connects to DB server and return DB instance
-func OpenDB(dbHost, dbPort, dbUser, dbPass, dbName string) (*DB, error) {
-	// initVars()
-	db, err := sql.Open("postgres", "host= "+dbHost+" port="+dbPort+" user="+dbUser+" dbname="+dbName+" password="+dbPass+" sslmode=disable")
+func OpenDB(conf Config) (*DB, error) {
+	db, err := sql.Open("postgres", conf.DSN())
 	if err != nil {
 		return nil, err
 	}
+	if conf.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(conf.MaxOpenConns)
+	}
+	if conf.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(conf.MaxIdleConns)
+	}
+	if conf.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(conf.ConnMaxLifetime)
+	}
 	return &DB{db: db}, nil
 }
 
+// WaitReady pings DB server until it responds. It retries with exponential backoff up to maxRetry times.
+func (d *DB) WaitReady(maxRetry int) error {
+	backoff := time.Second * pingBackoffStart
+	for i := 0; ; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*pingTimeout)
+		err := d.db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if i >= maxRetry {
+			return err
+		}
+		util.Warnf("DB is not ready (retry %d/%d in %v): %v", i+1, maxRetry, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > time.Second*pingBackoffMax {
+			backoff = time.Second * pingBackoffMax
+		}
+	}
+}
+
 // GenerateID generates random IDs
 func GenerateID(t IDType) (string, error) {
 	size := 0
@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -21,82 +22,261 @@ func (d *DB) GetDocumentInfo(fid string) (Document, error) {
 	return ret, nil
 }
 
-// CreateDocument creates new document
-func (d *DB) CreateDocument(title string, permission FilePerm, parentfid string, owneruuid string, updateruuid string) (string, error) {
-	dateint := time.Now().Unix()
-	did, err := GenerateID(IDTypeDocument)
-	if err != nil {
-		return "", err
-	}
+// CreateDocument creates new document with the text
+func (d *DB) CreateDocument(title string, text string, permission FilePerm, parentfid string, owneruuid string, updateruuid string) (string, error) {
 	tx, err := d.db.Begin()
 	if err != nil {
 		return "", err
 	}
-
-	_, err = tx.Exec(`INSERT INTO document VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,1)`,
-		did, owneruuid, parentfid, title, permission, dateint, dateint, updateruuid, 0)
+	did, err := createDocument(tx, title, text, permission, parentfid, owneruuid, updateruuid)
 	if err != nil {
 		if re := tx.Rollback(); re != nil {
 			err = fmt.Errorf("%s: %w", re.Error(), err)
 		}
 		return "", err
 	}
-	_, err = tx.Exec(`INSERT INTO documentrevision VALUES($1,$2,$3,1)`,
-		did, "", dateint)
+
+	err = tx.Commit()
 	if err != nil {
 		if re := tx.Rollback(); re != nil {
 			err = fmt.Errorf("%s: %w", re.Error(), err)
 		}
 		return "", err
 	}
+	return did, nil
+}
 
-	err = tx.Commit()
+func createDocument(q queryer, title string, text string, permission FilePerm, parentfid string, owneruuid string, updateruuid string) (string, error) {
+	dateint := time.Now().Unix()
+	did, err := GenerateID(IDTypeDocument)
+	if err != nil {
+		return "", err
+	}
+	_, err = q.Exec(`INSERT INTO document VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,1)`,
+		did, owneruuid, parentfid, title, permission, dateint, dateint, updateruuid, 0)
+	if err != nil {
+		return "", err
+	}
+	_, err = q.Exec(`INSERT INTO documentrevision VALUES($1,$2,$3,1)`,
+		did, text, dateint)
+	if err != nil {
+		return "", err
+	}
+	err = saveDocumentMeta(q, did, parentfid, title, text)
 	if err != nil {
-		if re := tx.Rollback(); re != nil {
-			err = fmt.Errorf("%s: %w", re.Error(), err)
-		}
 		return "", err
 	}
 	return did, nil
 }
 
-// DeleteDocument deletes document
-func (d *DB) DeleteDocument(did string) error {
-	tx, err := d.db.Begin()
+// saveDocumentMeta updates the data derived from the text (properties, links and tasks)
+func saveDocumentMeta(q queryer, did string, parentfid string, title string, text string) error {
+	err := saveDocumentProperties(q, did, text)
 	if err != nil {
 		return err
 	}
+	err = updateDocumentLinks(q, did, parentfid, title, text)
+	if err != nil {
+		return err
+	}
+	return saveDocumentTasks(q, did, text)
+}
+
+// DeleteDocument deletes document and returns the links which become broken
+func (d *DB) DeleteDocument(did string) ([]DocumentLink, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	title := ""
+	err = tx.QueryRow(`SELECT title FROM document WHERE uuid = $1`, did).Scan(&title)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = ErrDocumentNotFound
+		}
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	urllinks, err := queryLinks(tx, `SELECT uuid,target,title FROM documentlink WHERE target = $1 AND title = ''`, did)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	_, err = tx.Exec(`DELETE FROM documentlink WHERE uuid = $1`, did)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	_, err = tx.Exec(`DELETE FROM template WHERE uuid = $1`, did)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	_, err = tx.Exec(`DELETE FROM documenttaskassignee WHERE uuid = $1`, did)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	_, err = tx.Exec(`DELETE FROM documenttask WHERE uuid = $1`, did)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	_, err = tx.Exec(`DELETE FROM documentproperty WHERE uuid = $1`, did)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	_, err = tx.Exec(`DELETE FROM documenttag WHERE uuid = $1`, did)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	_, err = tx.Exec(`DELETE FROM documentchat WHERE uuid = $1`, did)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	_, err = tx.Exec(`DELETE FROM documentops WHERE uuid = $1`, did)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	_, err = tx.Exec(`DELETE FROM documentblame WHERE uuid = $1`, did)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
 	_, err = tx.Exec(`DELETE FROM documentrevision WHERE uuid = $1`, did)
 	if err != nil {
 		if re := tx.Rollback(); re != nil {
 			err = fmt.Errorf("%s: %w", re.Error(), err)
 		}
-		return err
+		return nil, err
 	}
 	_, err = tx.Exec(`DELETE FROM document WHERE uuid = $1`, did)
 	if err != nil {
 		if re := tx.Rollback(); re != nil {
 			err = fmt.Errorf("%s: %w", re.Error(), err)
 		}
-		return err
+		return nil, err
+	}
+	// The wiki links are resolved to other document if exists
+	changed, err := relinkWikiLinks(tx, title, did)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
 	}
 	err = tx.Commit()
 	if err != nil {
 		if re := tx.Rollback(); re != nil {
 			err = fmt.Errorf("%s: %w", re.Error(), err)
 		}
-		return err
+		return nil, err
 	}
-	return nil
+	broken := urllinks
+	for _, l := range changed {
+		if l.Target == "" {
+			broken = append(broken, l)
+		}
+	}
+	return broken, nil
 }
 
-// MoveDocument moves document to target folder
-func (d *DB) MoveDocument(did string, targetfid string) error {
-	_, err := d.db.Exec(`UPDATE document SET parentfolderuuid = $1 WHERE uuid = $2`, targetfid, did)
+// MoveDocument moves document to target folder and returns the wiki links which become unresolved.
+// The wiki links of the document and to the document are resolved again because they depend on the folder.
+func (d *DB) MoveDocument(did string, targetfid string) ([]DocumentLink, error) {
+	tx, err := d.db.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	title := ""
+	text := ""
+	r := tx.QueryRow("SELECT title,text FROM documentrevision INNER JOIN document ON (documentrevision.uuid = document.uuid AND documentrevision.revision = document.revision) WHERE document.uuid = $1", did)
+	err = r.Scan(&title, &text)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = ErrDocumentNotFound
+		}
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	_, err = tx.Exec(`UPDATE document SET parentfolderuuid = $1 WHERE uuid = $2`, targetfid, did)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	before, err := queryLinks(tx, `SELECT uuid,target,title FROM documentlink WHERE uuid = $1`, did)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	err = saveDocumentLinks(tx, did, targetfid, text)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	after, err := queryLinks(tx, `SELECT uuid,target,title FROM documentlink WHERE uuid = $1`, did)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	changed, err := relinkWikiLinks(tx, title, did)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+
+	broken := brokenLinks(before, after)
+	for _, l := range changed {
+		if l.Target == "" {
+			broken = append(broken, l)
+		}
+	}
+	return broken, nil
 }
 
 // GetLatestDocument returns document data
@@ -112,24 +292,166 @@ func (d *DB) GetLatestDocument(did string) (string, error) {
 	return text, nil
 }
 
-// SaveDocument store the document data
-func (d *DB) SaveDocument(did string, updateruuid string, text string) error {
+// GetDocumentRevision returns the document data of the revision. If rev is 0 or less, the latest revision is returned.
+func (d *DB) GetDocumentRevision(did string, rev int) (DocumentRevision, error) {
+	ret := DocumentRevision{UUID: did}
+	var r *sql.Row
+	if rev <= 0 {
+		r = d.db.QueryRow("SELECT text,updatedat,revision FROM documentrevision WHERE uuid = $1 AND revision = (SELECT revision FROM document WHERE uuid = $1)", did)
+	} else {
+		r = d.db.QueryRow("SELECT text,updatedat,revision FROM documentrevision WHERE uuid = $1 AND revision = $2", did, rev)
+	}
+	err := r.Scan(&ret.Text, &ret.UpdatedAt, &ret.Revision)
+	if err == sql.ErrNoRows {
+		return ret, ErrDocumentNotFound
+	} else if err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// GetDocumentBlame returns the attribution of the revision. If it's not stored, nil is returned.
+func (d *DB) GetDocumentBlame(did string, rev int) ([]BlameSpan, error) {
+	blameraw := ""
+	r := d.db.QueryRow("SELECT blame FROM documentblame WHERE uuid = $1 AND revision = $2", did, rev)
+	err := r.Scan(&blameraw)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	blame := []BlameSpan{}
+	err = json.Unmarshal([]byte(blameraw), &blame)
+	if err != nil {
+		return nil, err
+	}
+	return blame, nil
+}
+
+// GetDocumentRevisions returns the document data from the revision to the revision (inclusive)
+func (d *DB) GetDocumentRevisions(did string, from int, to int) ([]DocumentRevision, error) {
+	ret := []DocumentRevision{}
+	rows, err := d.db.Query("SELECT text,updatedat,revision FROM documentrevision WHERE uuid = $1 AND revision >= $2 AND revision <= $3 ORDER BY revision", did, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r := DocumentRevision{UUID: did}
+		err = rows.Scan(&r.Text, &r.UpdatedAt, &r.Revision)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetDocumentOps returns the stored operations from the revision to the revision (inclusive). The key is revision.
+func (d *DB) GetDocumentOps(did string, from int, to int) (map[int][]DocumentOp, error) {
+	ret := map[int][]DocumentOp{}
+	rows, err := d.db.Query("SELECT revision,ops FROM documentops WHERE uuid = $1 AND revision >= $2 AND revision <= $3", did, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rev := 0
+		opsraw := ""
+		err = rows.Scan(&rev, &opsraw)
+		if err != nil {
+			return nil, err
+		}
+		ops := []DocumentOp{}
+		err = json.Unmarshal([]byte(opsraw), &ops)
+		if err != nil {
+			return nil, err
+		}
+		ret[rev] = ops
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// AddDocumentChat stores the chat message of the document
+func (d *DB) AddDocumentChat(did string, useruuid string, message string) (DocumentChat, error) {
+	ret := DocumentChat{UUID: did, UserUUID: useruuid, Message: message, Date: time.Now().Unix()}
+	r := d.db.QueryRow(`INSERT INTO documentchat(uuid,useruuid,message,date) VALUES($1,$2,$3,$4) RETURNING id`,
+		did, useruuid, message, ret.Date)
+	err := r.Scan(&ret.ID)
+	if err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// GetDocumentChat returns the chat messages older than the ID in order of newest first. If before is 0 or less, the latest messages are returned.
+func (d *DB) GetDocumentChat(did string, before int64, limit int) ([]DocumentChat, error) {
+	ret := []DocumentChat{}
+	var rows *sql.Rows
+	var err error
+	if before <= 0 {
+		rows, err = d.db.Query("SELECT id,useruuid,message,date FROM documentchat WHERE uuid = $1 ORDER BY id DESC LIMIT $2", did, limit)
+	} else {
+		rows, err = d.db.Query("SELECT id,useruuid,message,date FROM documentchat WHERE uuid = $1 AND id < $2 ORDER BY id DESC LIMIT $3", did, before, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c := DocumentChat{UUID: did}
+		err = rows.Scan(&c.ID, &c.UserUUID, &c.Message, &c.Date)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// SaveDocument store the document data and returns new revision. blame and ops (from previous revision) are stored if they're not nil.
+func (d *DB) SaveDocument(did string, updateruuid string, text string, blame []BlameSpan, ops []DocumentOp) (int, error) {
+	return d.saveDocument(did, 0, updateruuid, text, blame, ops)
+}
+
+// SaveDocumentIfRevision is same as SaveDocument but fails with ErrRevisionMismatch if the latest revision is not baserev.
+func (d *DB) SaveDocumentIfRevision(did string, baserev int, updateruuid string, text string, blame []BlameSpan, ops []DocumentOp) (int, error) {
+	return d.saveDocument(did, baserev, updateruuid, text, blame, ops)
+}
+
+func (d *DB) saveDocument(did string, baserev int, updateruuid string, text string, blame []BlameSpan, ops []DocumentOp) (int, error) {
 	dateint := time.Now().Unix()
-	title := strings.Split(text, "\n")[0]
-	title = strings.Trim(title, "# ")
+	title := ExtractTitle(text)
 
 	tx, err := d.db.Begin()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	lastrev := 0
-	err = tx.QueryRow(`SELECT revision FROM document WHERE uuid = $1 FOR UPDATE`, did).Scan(&lastrev)
+	parentfid := ""
+	oldtitle := ""
+	err = tx.QueryRow(`SELECT revision,parentfolderuuid,title FROM document WHERE uuid = $1 FOR UPDATE`, did).Scan(&lastrev, &parentfid, &oldtitle)
 	if err != nil {
 		if re := tx.Rollback(); re != nil {
 			err = fmt.Errorf("%s: %w", re.Error(), err)
 		}
-		return err
+		return 0, err
+	}
+	if baserev > 0 && lastrev != baserev {
+		if re := tx.Rollback(); re != nil {
+			return 0, fmt.Errorf("%s: %w", re.Error(), ErrRevisionMismatch)
+		}
+		return 0, ErrRevisionMismatch
 	}
 	newrev := lastrev + 1
 	_, err = tx.Exec(`UPDATE document SET updatedat = $1, title = $2, updateruuid = $3, revision = $4 WHERE uuid = $5`, dateint, title, updateruuid, newrev, did)
@@ -137,7 +459,7 @@ func (d *DB) SaveDocument(did string, updateruuid string, text string) error {
 		if re := tx.Rollback(); re != nil {
 			err = fmt.Errorf("%s: %w", re.Error(), err)
 		}
-		return err
+		return 0, err
 	}
 	_, err = tx.Exec(`INSERT INTO documentrevision VALUES($1,$2,$3,$4)`,
 		did, text, dateint, newrev)
@@ -145,7 +467,56 @@ func (d *DB) SaveDocument(did string, updateruuid string, text string) error {
 		if re := tx.Rollback(); re != nil {
 			err = fmt.Errorf("%s: %w", re.Error(), err)
 		}
-		return err
+		return 0, err
+	}
+	err = saveDocumentMeta(tx, did, parentfid, title, text)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return 0, err
+	}
+	// The wiki links to the old title may be resolved to other document
+	if !strings.EqualFold(oldtitle, title) {
+		_, err = relinkWikiLinks(tx, oldtitle, did)
+		if err != nil {
+			if re := tx.Rollback(); re != nil {
+				err = fmt.Errorf("%s: %w", re.Error(), err)
+			}
+			return 0, err
+		}
+	}
+	if blame != nil {
+		blameraw, err := json.Marshal(blame)
+		if err != nil {
+			if re := tx.Rollback(); re != nil {
+				err = fmt.Errorf("%s: %w", re.Error(), err)
+			}
+			return 0, err
+		}
+		_, err = tx.Exec(`INSERT INTO documentblame VALUES($1,$2,$3)`, did, newrev, string(blameraw))
+		if err != nil {
+			if re := tx.Rollback(); re != nil {
+				err = fmt.Errorf("%s: %w", re.Error(), err)
+			}
+			return 0, err
+		}
+	}
+	if ops != nil {
+		opsraw, err := json.Marshal(ops)
+		if err != nil {
+			if re := tx.Rollback(); re != nil {
+				err = fmt.Errorf("%s: %w", re.Error(), err)
+			}
+			return 0, err
+		}
+		_, err = tx.Exec(`INSERT INTO documentops VALUES($1,$2,$3)`, did, newrev, string(opsraw))
+		if err != nil {
+			if re := tx.Rollback(); re != nil {
+				err = fmt.Errorf("%s: %w", re.Error(), err)
+			}
+			return 0, err
+		}
 	}
 
 	err = tx.Commit()
@@ -153,9 +524,9 @@ func (d *DB) SaveDocument(did string, updateruuid string, text string) error {
 		if re := tx.Rollback(); re != nil {
 			err = fmt.Errorf("%s: %w", re.Error(), err)
 		}
-		return err
+		return 0, err
 	}
-	return nil
+	return newrev, nil
 }
 
 // UpdateDocumentInfo modifies document info
@@ -181,36 +552,11 @@ func (d *DB) UpdateDocument(did string, updateruuid string) error {
 
 // DuplicateDocument duplicates document and save into target folder
 func (d *DB) DuplicateDocument(did string, permission FilePerm, parentfid string, owneruuid string, updateruuid string) (string, error) {
-	title := ""
-	content := ""
-	r := d.db.QueryRow("SELECT title,text FROM documentrevision INNER JOIN document ON (documentrevision.uuid = document.uuid AND documentrevision.revision = document.revision) WHERE document.uuid = $1", did)
-	err := r.Scan(&title, &content)
-	if err == sql.ErrNoRows {
-		return "", ErrDocumentNotFound
-	} else if err != nil {
-		return "", err
-	}
-
-	dateint := time.Now().Unix()
-	newdid, err := GenerateID(IDTypeDocument)
-	if err != nil {
-		return "", err
-	}
 	tx, err := d.db.Begin()
 	if err != nil {
 		return "", err
 	}
-
-	_, err = tx.Exec(`INSERT INTO document VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,1)`,
-		newdid, owneruuid, parentfid, title, permission, dateint, dateint, updateruuid, 0)
-	if err != nil {
-		if re := tx.Rollback(); re != nil {
-			err = fmt.Errorf("%s: %w", re.Error(), err)
-		}
-		return "", err
-	}
-	_, err = tx.Exec(`INSERT INTO documentrevision VALUES($1,$2,$3,1)`,
-		newdid, content, dateint)
+	newdid, err := duplicateDocument(tx, did, permission, parentfid, owneruuid, updateruuid)
 	if err != nil {
 		if re := tx.Rollback(); re != nil {
 			err = fmt.Errorf("%s: %w", re.Error(), err)
@@ -227,3 +573,17 @@ func (d *DB) DuplicateDocument(did string, permission FilePerm, parentfid string
 	}
 	return newdid, nil
 }
+
+func duplicateDocument(q queryer, did string, permission FilePerm, parentfid string, owneruuid string, updateruuid string) (string, error) {
+	title := ""
+	content := ""
+	r := q.QueryRow("SELECT title,text FROM documentrevision INNER JOIN document ON (documentrevision.uuid = document.uuid AND documentrevision.revision = document.revision) WHERE document.uuid = $1", did)
+	err := r.Scan(&title, &content)
+	if err == sql.ErrNoRows {
+		return "", ErrDocumentNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	return createDocument(q, title, content, permission, parentfid, owneruuid, updateruuid)
+}
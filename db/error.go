@@ -1,6 +1,10 @@
 package db
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
 
 // Errors
 var (
@@ -17,4 +21,28 @@ var (
 	// Document/Folder
 	ErrDocumentNotFound = errors.New("Document is not found")
 	ErrFolderNotFound   = errors.New("Folder is not found")
+	ErrRevisionMismatch = errors.New("Document is updated after the revision")
+	ErrTaskNotFound     = errors.New("Task is not found")
+	ErrFolderTooLarge   = errors.New("Folder has too many items")
+	ErrFolderCycle      = errors.New("Folder can't be moved into its descendant")
+
+	// Template
+	ErrTemplateNotFound = errors.New("Template is not found")
+	ErrExistTemplate    = errors.New("Template is already exist")
+
+	// Tag
+	ErrTagNotFound = errors.New("Tag is not found")
+	ErrExistTag    = errors.New("Tag is already exist")
 )
+
+// isUniqueViolation returns true if the error is caused by the unique constraint
+func isUniqueViolation(err error) bool {
+	var pqerr *pq.Error
+	return errors.As(err, &pqerr) && pqerr.Code == "23505"
+}
+
+// isSerializationFailure returns true if the serializable transaction conflicts with the concurrent one
+func isSerializationFailure(err error) bool {
+	var pqerr *pq.Error
+	return errors.As(err, &pqerr) && pqerr.Code == "40001"
+}
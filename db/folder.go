@@ -1,7 +1,9 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"time"
 )
 
@@ -49,6 +51,33 @@ func (d *DB) GetDocList(fid string) ([]string, error) {
 	return res, nil
 }
 
+// GetDocListByTags returns the list of documents in the specified folder which have all tags
+func (d *DB) GetDocListByTags(fid string, tagids []int) ([]string, error) {
+	var res []string
+	sql := "SELECT uuid FROM document WHERE parentfolderuuid = $1"
+	cond, params := tagFilter("uuid", tagids, []interface{}{fid})
+	if cond != "" {
+		sql += " AND " + cond
+	}
+	rows, err := d.db.Query(sql, params...)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var uuid string
+		err = rows.Scan(&uuid)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, uuid)
+	}
+	if err = rows.Err(); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
 // GetFolderInfo returns folder information
 func (d *DB) GetFolderInfo(fid string) (Folder, error) {
 	var ret Folder
@@ -65,12 +94,16 @@ func (d *DB) GetFolderInfo(fid string) (Folder, error) {
 
 // CreateFolder creates new folder
 func (d *DB) CreateFolder(name string, permission FilePerm, parentfid string, owneruuid string, updateruuid string) (string, error) {
+	return createFolder(d.db, name, permission, parentfid, owneruuid, updateruuid)
+}
+
+func createFolder(q queryer, name string, permission FilePerm, parentfid string, owneruuid string, updateruuid string) (string, error) {
 	dateint := time.Now().Unix()
 	fid, err := GenerateID(IDTypeFolder)
 	if err != nil {
 		return "", err
 	}
-	_, err = d.db.Exec(`INSERT INTO folder VALUES($1,$2,$3,$4,$5,$6,$7,$8)`,
+	_, err = q.Exec(`INSERT INTO folder VALUES($1,$2,$3,$4,$5,$6,$7,$8)`,
 		fid, owneruuid, parentfid, name, permission, dateint, dateint, updateruuid)
 	if err != nil {
 		return "", err
@@ -78,19 +111,204 @@ func (d *DB) CreateFolder(name string, permission FilePerm, parentfid string, ow
 	return fid, nil
 }
 
+// CopyFolder copies the folder and its contents into the target folder recursively in one transaction.
+// Only the items which are owned by readers or not private are copied. The copies are private and owned by owneruuid.
+// It returns the map of the old IDs to the new IDs, or ErrFolderTooLarge if the folder has more than maxitems items.
+func (d *DB) CopyFolder(fid string, targetfid string, readers []string, owneruuid string, updateruuid string, maxitems int) (map[string]string, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	idmap, err := copyFolder(tx, fid, targetfid, readers, owneruuid, updateruuid, maxitems)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return nil, err
+	}
+	return idmap, nil
+}
+
+func copyFolder(q queryer, fid string, targetfid string, readers []string, owneruuid string, updateruuid string, maxitems int) (map[string]string, error) {
+	name := ""
+	err := q.QueryRow(`SELECT name FROM folder WHERE uuid = $1`, fid).Scan(&name)
+	if err == sql.ErrNoRows {
+		return nil, ErrFolderNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Collect the items before copying so that copying into the own subtree doesn't copy the copies
+	items, err := getFolderDescendants(q, fid, 0)
+	if err != nil {
+		return nil, err
+	}
+	isReadable := func(owner string, perm FilePerm) bool {
+		if perm != FilePermPrivate {
+			return true
+		}
+		for _, v := range readers {
+			if owner == v {
+				return true
+			}
+		}
+		return false
+	}
+
+	newfid, err := createFolder(q, name, FilePermPrivate, targetfid, owneruuid, updateruuid)
+	if err != nil {
+		return nil, err
+	}
+	idmap := map[string]string{fid: newfid}
+	for _, v := range items {
+		parent, ok := idmap[v.ParentFolderUUID]
+		if !ok || !isReadable(v.OwnerUUID, v.Permission) {
+			continue
+		}
+		if len(idmap) >= maxitems {
+			return nil, ErrFolderTooLarge
+		}
+		if v.UUID[0] == 'f' {
+			idmap[v.UUID], err = createFolder(q, v.Name, FilePermPrivate, parent, owneruuid, updateruuid)
+		} else {
+			idmap[v.UUID], err = duplicateDocument(q, v.UUID, FilePermPrivate, parent, owneruuid, updateruuid)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return idmap, nil
+}
+
+// GetFolderDescendants returns the folders and documents under the folder ordered by depth.
+// Depth of the children is 1 and the items deeper than depth are omitted (depth <= 0 means no limit).
+func (d *DB) GetFolderDescendants(fid string, depth int) ([]FolderTreeItem, error) {
+	return getFolderDescendants(d.db, fid, depth)
+}
+
+func getFolderDescendants(q queryer, fid string, depth int) ([]FolderTreeItem, error) {
+	res := []FolderTreeItem{}
+	// path stops the recursion even if the tree already has a cycle
+	rows, err := q.Query(`WITH RECURSIVE tree AS (
+		SELECT uuid,owneruuid,parentfolderuuid,name,permission,createdat,updatedat,updateruuid,0 AS depth,ARRAY[uuid] AS path FROM folder WHERE uuid = $1
+		UNION ALL
+		SELECT f.uuid,f.owneruuid,f.parentfolderuuid,f.name,f.permission,f.createdat,f.updatedat,f.updateruuid,tree.depth+1,tree.path||f.uuid FROM folder f
+		INNER JOIN tree ON f.parentfolderuuid = tree.uuid WHERE NOT f.uuid = ANY(tree.path) AND ($2::INTEGER <= 0 OR tree.depth < $2::INTEGER)
+	)
+	SELECT uuid,owneruuid,parentfolderuuid,name,permission,createdat,updatedat,updateruuid,depth FROM tree WHERE depth > 0
+	UNION ALL
+	SELECT d.uuid,d.owneruuid,d.parentfolderuuid,d.title,d.permission,d.createdat,d.updatedat,d.updateruuid,tree.depth+1 FROM document d
+	INNER JOIN tree ON d.parentfolderuuid = tree.uuid WHERE $2::INTEGER <= 0 OR tree.depth < $2::INTEGER
+	ORDER BY depth, name, uuid`, fid, depth)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v FolderTreeItem
+		err = rows.Scan(&v.UUID, &v.OwnerUUID, &v.ParentFolderUUID, &v.Name, &v.Permission, &v.CreatedAt, &v.UpdatedAt, &v.UpdaterUUID, &v.Depth)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, v)
+	}
+	if err = rows.Err(); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
 // DeleteFolder deletes folder
 func (d *DB) DeleteFolder(fid string) error {
-	_, err := d.db.Exec(`DELETE FROM folder WHERE uuid = $1`, fid)
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM template WHERE uuid = $1`, fid)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM folder WHERE uuid = $1`, fid)
 	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
 		return err
 	}
 	return nil
 }
 
-// MoveFolder moves folder
+// moveFolderRetryMax is the number of tries when the concurrent moves conflict
+const moveFolderRetryMax = 3
+
+// MoveFolder moves folder. It returns ErrFolderCycle if the target is the folder itself or its descendant.
 func (d *DB) MoveFolder(fid string, targetfid string) error {
-	_, err := d.db.Exec(`UPDATE folder SET parentfolderuuid = $1 WHERE uuid = $2`, targetfid, fid)
+	var err error
+	for i := 0; i < moveFolderRetryMax; i++ {
+		err = d.moveFolder(fid, targetfid)
+		if !isSerializationFailure(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// moveFolder runs the cycle check and the update in the serializable transaction
+// so that the concurrent moves can't make the cycle together
+func (d *DB) moveFolder(fid string, targetfid string) error {
+	tx, err := d.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	// The folder can't be moved into itself or its descendants
+	r, err := tx.Exec(`WITH RECURSIVE tree AS (
+		SELECT uuid FROM folder WHERE uuid = $2
+		UNION
+		SELECT f.uuid FROM folder f INNER JOIN tree ON f.parentfolderuuid = tree.uuid
+	)
+	UPDATE folder SET parentfolderuuid = $1 WHERE uuid = $2 AND $1 NOT IN (SELECT uuid FROM tree)`, targetfid, fid)
 	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return err
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return err
+	}
+	if n == 0 {
+		err = ErrFolderCycle
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
 		return err
 	}
 	return nil
@@ -0,0 +1,198 @@
+package db
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+)
+
+// Max depth of the folders to resolve the wiki link
+const linkResolveDepthMax = 100
+
+var (
+	docURLPattern   = regexp.MustCompile(`/doc/(d[a-z2-7]{8,})`)
+	wikiLinkPattern = regexp.MustCompile(`\[\[([^\[\]|\n]+)(?:\|[^\[\]\n]*)?\]\]`)
+)
+
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// extractLinks returns the document IDs of /doc/<id> URLs and the titles of [[Title]] links without duplication
+func extractLinks(text string) ([]string, []string) {
+	ids := []string{}
+	titles := []string{}
+	found := map[string]bool{}
+	for _, m := range docURLPattern.FindAllStringSubmatch(text, -1) {
+		if !found["/"+m[1]] {
+			found["/"+m[1]] = true
+			ids = append(ids, m[1])
+		}
+	}
+	for _, m := range wikiLinkPattern.FindAllStringSubmatch(text, -1) {
+		t := strings.TrimSpace(m[1])
+		if t == "" || found["["+strings.ToLower(t)] {
+			continue
+		}
+		found["["+strings.ToLower(t)] = true
+		titles = append(titles, t)
+	}
+	return ids, titles
+}
+
+// resolveWikiLink finds the document of the title from the folder to the root.
+// The nearest folder wins and the oldest document wins in the same folder. Empty string is returned if not found.
+func resolveWikiLink(q queryer, fid string, title string) (string, error) {
+	for i := 0; fid != "" && i < linkResolveDepthMax; i++ {
+		did := ""
+		err := q.QueryRow(`SELECT uuid FROM document WHERE parentfolderuuid = $1 AND lower(title) = lower($2) ORDER BY createdat, uuid LIMIT 1`, fid, title).Scan(&did)
+		if err == nil {
+			return did, nil
+		} else if err != sql.ErrNoRows {
+			return "", err
+		}
+		err = q.QueryRow(`SELECT parentfolderuuid FROM folder WHERE uuid = $1`, fid).Scan(&fid)
+		if err == sql.ErrNoRows {
+			return "", nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// saveDocumentLinks replaces the outgoing links of the document by the links in the text
+func saveDocumentLinks(q queryer, did string, parentfid string, text string) error {
+	_, err := q.Exec(`DELETE FROM documentlink WHERE uuid = $1`, did)
+	if err != nil {
+		return err
+	}
+	ids, titles := extractLinks(text)
+	for _, v := range ids {
+		if v == did {
+			continue
+		}
+		_, err = q.Exec(`INSERT INTO documentlink VALUES($1,$2,'')`, did, v)
+		if err != nil {
+			return err
+		}
+	}
+	for _, v := range titles {
+		target, err := resolveWikiLink(q, parentfid, v)
+		if err != nil {
+			return err
+		}
+		if target == did {
+			continue
+		}
+		_, err = q.Exec(`INSERT INTO documentlink VALUES($1,$2,$3)`, did, target, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateDocumentLinks saves the outgoing links of the document and resolves the wiki links to the title of the document
+func updateDocumentLinks(q queryer, did string, parentfid string, title string, text string) error {
+	err := saveDocumentLinks(q, did, parentfid, text)
+	if err != nil {
+		return err
+	}
+	_, err = relinkWikiLinks(q, title, "")
+	return err
+}
+
+// relinkWikiLinks resolves the wiki links of the title again and returns the links which are changed.
+// If target is not empty, only the links to the target and the unresolved links are checked.
+func relinkWikiLinks(q queryer, title string, target string) ([]DocumentLink, error) {
+	links := []DocumentLink{}
+	rows, err := q.Query(`SELECT documentlink.uuid,documentlink.target,documentlink.title,document.parentfolderuuid FROM documentlink INNER JOIN document ON documentlink.uuid = document.uuid WHERE lower(documentlink.title) = lower($1) AND (documentlink.target = '' OR documentlink.target = $2 OR $2 = '')`, title, target)
+	if err != nil {
+		return nil, err
+	}
+	fids := []string{}
+	for rows.Next() {
+		var l DocumentLink
+		var fid string
+		err = rows.Scan(&l.UUID, &l.Target, &l.Title, &fid)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		links = append(links, l)
+		fids = append(fids, fid)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	changed := []DocumentLink{}
+	for i, l := range links {
+		newtarget, err := resolveWikiLink(q, fids[i], l.Title)
+		if err != nil {
+			return nil, err
+		}
+		if newtarget == l.Target || newtarget == l.UUID {
+			continue
+		}
+		_, err = q.Exec(`UPDATE documentlink SET target = $1 WHERE uuid = $2 AND target = $3 AND title = $4`, newtarget, l.UUID, l.Target, l.Title)
+		if err != nil {
+			return nil, err
+		}
+		l.Target = newtarget
+		changed = append(changed, l)
+	}
+	return changed, nil
+}
+
+// GetDocumentLinks returns the outgoing links of the document
+func (d *DB) GetDocumentLinks(did string) ([]DocumentLink, error) {
+	return queryLinks(d.db, `SELECT uuid,target,title FROM documentlink WHERE uuid = $1 ORDER BY title,target`, did)
+}
+
+// GetDocumentBacklinks returns the links to the document
+func (d *DB) GetDocumentBacklinks(did string) ([]DocumentLink, error) {
+	return queryLinks(d.db, `SELECT uuid,target,title FROM documentlink WHERE target = $1 ORDER BY uuid,title`, did)
+}
+
+func queryLinks(q queryer, sql string, params ...interface{}) ([]DocumentLink, error) {
+	res := []DocumentLink{}
+	rows, err := q.Query(sql, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l DocumentLink
+		err = rows.Scan(&l.UUID, &l.Target, &l.Title)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// brokenLinks returns the wiki links which were resolved before and are unresolved now
+func brokenLinks(before []DocumentLink, after []DocumentLink) []DocumentLink {
+	resolved := map[string]bool{}
+	for _, l := range before {
+		if l.Title != "" && l.Target != "" {
+			resolved[l.UUID+"\n"+strings.ToLower(l.Title)] = true
+		}
+	}
+	res := []DocumentLink{}
+	for _, l := range after {
+		if l.Title != "" && l.Target == "" && resolved[l.UUID+"\n"+strings.ToLower(l.Title)] {
+			res = append(res, l)
+		}
+	}
+	return res
+}
@@ -0,0 +1,192 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Property types
+const (
+	PropertyTypeString = "string"
+	PropertyTypeNumber = "number"
+	PropertyTypeBool   = "bool"
+	PropertyTypeDate   = "date"
+	PropertyTypeList   = "list"
+)
+
+// Limits of the front matter properties
+const (
+	propertyMax         = 50
+	propertyValueMaxLen = 1024
+)
+
+var propertyKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// IsValidPropertyKey checks the key can be used as the property
+func IsValidPropertyKey(key string) bool {
+	return propertyKeyPattern.MatchString(key)
+}
+
+// SplitFrontMatter splits the YAML front matter (between the "---" lines at the top) and the body.
+// If the text has no front matter, empty string and the whole text are returned.
+func SplitFrontMatter(text string) (string, string) {
+	lines := strings.SplitAfter(text, "\n")
+	if len(lines) < 2 || strings.TrimRight(lines[0], "\r\n") != "---" {
+		return "", text
+	}
+	for i := 1; i < len(lines); i++ {
+		l := strings.TrimRight(lines[i], "\r\n")
+		if l == "---" || l == "..." {
+			return strings.Join(lines[1:i], ""), strings.Join(lines[i+1:], "")
+		}
+	}
+	return "", text
+}
+
+// ExtractTitle returns the title of the document.
+// The title key of the front matter takes precedence over the first line of the body.
+func ExtractTitle(text string) string {
+	fm, body := SplitFrontMatter(text)
+	for _, p := range ParseProperties(fm) {
+		if p.Key == "title" && p.Type == PropertyTypeString && strings.TrimSpace(p.Value) != "" {
+			return strings.TrimSpace(p.Value)
+		}
+	}
+	return strings.Trim(strings.Split(body, "\n")[0], "# ")
+}
+
+// ParseProperties parses the front matter into typed properties.
+// Nested objects, null values and invalid keys are ignored. Invalid YAML results no properties.
+func ParseProperties(fm string) []DocumentProperty {
+	res := []DocumentProperty{}
+	if strings.TrimSpace(fm) == "" {
+		return res
+	}
+	var dat yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(fm), &dat); err != nil {
+		return res
+	}
+	for _, v := range dat {
+		key, ok := v.Key.(string)
+		if !ok || !IsValidPropertyKey(key) {
+			continue
+		}
+		p, ok := toProperty(v.Value)
+		if !ok || utf8.RuneCountInString(p.Value) > propertyValueMaxLen {
+			continue
+		}
+		p.Key = key
+		res = append(res, p)
+		if len(res) >= propertyMax {
+			break
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+// toProperty converts YAML scalar or list of scalars into the property value
+func toProperty(v interface{}) (DocumentProperty, bool) {
+	switch val := v.(type) {
+	case []interface{}:
+		list := []string{}
+		for _, e := range val {
+			p, ok := toProperty(e)
+			if !ok || p.Type == PropertyTypeList {
+				return DocumentProperty{}, false
+			}
+			list = append(list, p.Value)
+		}
+		raw, err := json.Marshal(list)
+		if err != nil {
+			return DocumentProperty{}, false
+		}
+		return DocumentProperty{Type: PropertyTypeList, Value: string(raw)}, true
+	case string:
+		if t, err := time.Parse("2006-01-02", val); err == nil {
+			return DocumentProperty{Type: PropertyTypeDate, Value: t.Format("2006-01-02")}, true
+		}
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			return DocumentProperty{Type: PropertyTypeDate, Value: t.Format(time.RFC3339)}, true
+		}
+		return DocumentProperty{Type: PropertyTypeString, Value: val}, true
+	case time.Time:
+		return DocumentProperty{Type: PropertyTypeDate, Value: val.Format(time.RFC3339)}, true
+	case bool:
+		return DocumentProperty{Type: PropertyTypeBool, Value: strconv.FormatBool(val)}, true
+	case int:
+		return DocumentProperty{Type: PropertyTypeNumber, Value: strconv.Itoa(val)}, true
+	case int64:
+		return DocumentProperty{Type: PropertyTypeNumber, Value: strconv.FormatInt(val, 10)}, true
+	case uint64:
+		return DocumentProperty{Type: PropertyTypeNumber, Value: strconv.FormatUint(val, 10)}, true
+	case float64:
+		return DocumentProperty{Type: PropertyTypeNumber, Value: strconv.FormatFloat(val, 'f', -1, 64)}, true
+	}
+	return DocumentProperty{}, false
+}
+
+// saveDocumentProperties replaces the properties of the document by the front matter of the text
+func saveDocumentProperties(q queryer, did string, text string) error {
+	_, err := q.Exec(`DELETE FROM documentproperty WHERE uuid = $1`, did)
+	if err != nil {
+		return err
+	}
+	fm, _ := SplitFrontMatter(text)
+	for _, p := range ParseProperties(fm) {
+		_, err = q.Exec(`INSERT INTO documentproperty VALUES($1,$2,$3,$4)`, did, p.Key, p.Type, p.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDocumentProperties returns the front matter properties of the document
+func (d *DB) GetDocumentProperties(did string) ([]DocumentProperty, error) {
+	res := []DocumentProperty{}
+	rows, err := d.db.Query("SELECT key,type,value FROM documentproperty WHERE uuid = $1 ORDER BY key", did)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p DocumentProperty
+		err = rows.Scan(&p.Key, &p.Type, &p.Value)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// propertyFilter returns the SQL condition for the documents which have all properties.
+// The list property matches if one of the elements is equal.
+func propertyFilter(col string, props map[string]string, params []interface{}) (string, []interface{}) {
+	keys := []string{}
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	conds := []string{}
+	for _, k := range keys {
+		params = append(params, k, props[k])
+		kp := "$" + strconv.Itoa(len(params)-1)
+		vp := "$" + strconv.Itoa(len(params))
+		conds = append(conds, fmt.Sprintf("%s IN (SELECT uuid FROM documentproperty WHERE key = %s AND (value = %s OR CASE WHEN type = '%s' THEN value::jsonb ? %s ELSE false END))",
+			col, kp, vp, PropertyTypeList, vp))
+	}
+	return strings.Join(conds, " AND "), params
+}
@@ -151,3 +151,72 @@ func (d *DB) SearchTeam(query string, limit int, offset int) (int, []string, err
 
 	return count, res, nil
 }
+
+// SearchDocument returns the document uuid list of search.
+// Only the documents owned by readers or not private are returned. The documents which have all tags and properties are returned.
+func (d *DB) SearchDocument(query string, tagids []int, props map[string]string, readers []string, limit int, offset int) (int, []string, error) {
+	var res []string
+	var count = 0
+
+	param := []interface{}{FilePermPrivate}
+	cond := " WHERE (permission != $1 OR owneruuid IN ("
+	for i, v := range readers {
+		param = append(param, v)
+		if i > 0 {
+			cond += ","
+		}
+		cond += "$" + strconv.Itoa(len(param))
+	}
+	if len(readers) == 0 {
+		cond += "''"
+	}
+	cond += "))"
+	if query != "" {
+		param = append(param, "%"+query+"%")
+		cond += " AND title ilike $" + strconv.Itoa(len(param))
+	}
+	var tagcond string
+	tagcond, param = tagFilter("uuid", tagids, param)
+	if tagcond != "" {
+		cond += " AND " + tagcond
+	}
+	var propcond string
+	propcond, param = propertyFilter("uuid", props, param)
+	if propcond != "" {
+		cond += " AND " + propcond
+	}
+
+	r := d.db.QueryRow("SELECT COUNT(*) FROM document"+cond, param...)
+	err := r.Scan(&count)
+	if err != nil {
+		return 0, res, err
+	}
+
+	sql := "SELECT uuid FROM document" + cond + " ORDER BY updatedat DESC, uuid"
+	if limit > 0 {
+		param = append(param, limit)
+		sql += " limit $" + strconv.Itoa(len(param))
+		if offset > 0 {
+			param = append(param, offset)
+			sql += " offset $" + strconv.Itoa(len(param))
+		}
+	}
+	rows, err := d.db.Query(sql, param...)
+	if err != nil {
+		return 0, res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var uuid string
+		err = rows.Scan(&uuid)
+		if err != nil {
+			return 0, res, err
+		}
+		res = append(res, uuid)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, res, err
+	}
+
+	return count, res, nil
+}
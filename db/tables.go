@@ -59,6 +59,19 @@ type Folder struct {
 	UpdaterUUID      string
 }
 
+// FolderTreeItem is folder or document in the folder tree. Name is the title for the document.
+type FolderTreeItem struct {
+	UUID             string
+	OwnerUUID        string
+	ParentFolderUUID string
+	Name             string
+	Permission       FilePerm
+	CreatedAt        int64
+	UpdatedAt        int64
+	UpdaterUUID      string
+	Depth            int
+}
+
 // Document table model
 type Document struct {
 	UUID             string
@@ -80,6 +93,85 @@ type DocumentRevision struct {
 	Revision  int
 }
 
+// BlameSpan is attribution of the text range (length is UTF-16 units)
+type BlameSpan struct {
+	Len  int    `json:"len"`
+	UUID string `json:"uuid"`
+	Time int64  `json:"time"`
+}
+
+// DocumentBlame table model
+type DocumentBlame struct {
+	UUID     string
+	Revision int
+	Blame    []BlameSpan
+}
+
+// DocumentOp is OT operation which is stored in documentops table
+type DocumentOp struct {
+	UUID string        `json:"uuid"`
+	Time int64         `json:"time"` // Unix time in milliseconds
+	Ops  []interface{} `json:"ops"`
+}
+
+// DocumentOps table model. Ops convert the text of previous revision into the text of the revision.
+type DocumentOps struct {
+	UUID     string
+	Revision int
+	Ops      []DocumentOp
+}
+
+// DocumentChat table model
+type DocumentChat struct {
+	ID       int64
+	UUID     string
+	UserUUID string
+	Message  string
+	Date     int64
+}
+
+// Template table model. TeamUUID is empty for the instance template.
+type Template struct {
+	UUID        string
+	TeamUUID    string
+	Name        string
+	Description string
+	CreatedAt   int64
+	CreatorUUID string
+}
+
+// DocumentProperty table model. Value of the list is JSON array of strings.
+type DocumentProperty struct {
+	Key   string
+	Type  string
+	Value string
+}
+
+// DocumentLink table model. Target is empty if the wiki link is not resolved. Title is the title of the wiki link and empty for the URL link.
+type DocumentLink struct {
+	UUID   string
+	Target string
+	Title  string
+}
+
+// DocumentTask table model. Line is 1-based and Due is YYYY-MM-DD or empty. Assignees are user UUIDs.
+type DocumentTask struct {
+	UUID      string
+	Line      int
+	Done      bool
+	Text      string
+	Due       string
+	Assignees []string
+}
+
+// Tag table model. TeamUUID is empty for the instance tag. Count is the number of tagged documents.
+type Tag struct {
+	ID       int
+	Name     string
+	TeamUUID string
+	Count    int
+}
+
 // Session table model
 type Session struct {
 	UUID       string
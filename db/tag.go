@@ -0,0 +1,188 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// tagSelect returns the SQL to select tags with the number of tagged documents which readers can read
+func tagSelect(readers []string) (string, []interface{}) {
+	params := []interface{}{FilePermPrivate}
+	sql := "SELECT tag.tagid,tag.name,tag.teamuuid,COUNT(document.uuid) FROM tag LEFT JOIN documenttag ON tag.tagid = documenttag.tagid" +
+		" LEFT JOIN document ON documenttag.uuid = document.uuid AND (document.permission != $1 OR document.owneruuid IN ("
+	for i, v := range readers {
+		params = append(params, v)
+		if i > 0 {
+			sql += ","
+		}
+		sql += "$" + strconv.Itoa(len(params))
+	}
+	if len(readers) == 0 {
+		sql += "''"
+	}
+	sql += "))"
+	return sql, params
+}
+
+// AddTag creates new tag in the namespace of the team (empty for instance) and returns its ID
+func (d *DB) AddTag(teamuuid string, name string) (int, error) {
+	id := 0
+	r := d.db.QueryRow("INSERT INTO tag(name,teamuuid) VALUES($1,$2) RETURNING tagid", name, teamuuid)
+	err := r.Scan(&id)
+	if isUniqueViolation(err) {
+		return 0, ErrExistTag
+	} else if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetTag returns the tag info with the number of tagged documents which readers can read
+func (d *DB) GetTag(id int, readers []string) (Tag, error) {
+	var ret Tag
+	if id <= 0 {
+		return ret, ErrTagNotFound
+	}
+	query, params := tagSelect(readers)
+	params = append(params, id)
+	r := d.db.QueryRow(query+" WHERE tag.tagid = $"+strconv.Itoa(len(params))+" GROUP BY tag.tagid", params...)
+	err := r.Scan(&ret.ID, &ret.Name, &ret.TeamUUID, &ret.Count)
+	if err == sql.ErrNoRows {
+		return ret, ErrTagNotFound
+	} else if err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// GetTags returns the instance tags and the tags of the teams with the number of tagged documents which readers can read
+func (d *DB) GetTags(teams []string, readers []string) ([]Tag, error) {
+	sql, params := tagSelect(readers)
+	params = append(params, "")
+	sql += " WHERE tag.tagid > 0 AND tag.teamuuid IN ($" + strconv.Itoa(len(params))
+	for _, v := range teams {
+		params = append(params, v)
+		sql += ",$" + strconv.Itoa(len(params))
+	}
+	sql += ") GROUP BY tag.tagid ORDER BY tag.name,tag.tagid"
+	return d.queryTags(sql, params...)
+}
+
+// GetDocumentTags returns the tags of the document with the number of tagged documents which readers can read
+func (d *DB) GetDocumentTags(did string, readers []string) ([]Tag, error) {
+	sql, params := tagSelect(readers)
+	params = append(params, did)
+	return d.queryTags(sql+" WHERE tag.tagid IN (SELECT tagid FROM documenttag WHERE uuid = $"+strconv.Itoa(len(params))+") GROUP BY tag.tagid ORDER BY tag.name,tag.tagid", params...)
+}
+
+func (d *DB) queryTags(sql string, params ...interface{}) ([]Tag, error) {
+	res := []Tag{}
+	rows, err := d.db.Query(sql, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t Tag
+		err = rows.Scan(&t.ID, &t.Name, &t.TeamUUID, &t.Count)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// RenameTag changes the tag name
+func (d *DB) RenameTag(id int, name string) error {
+	if id <= 0 {
+		return ErrTagNotFound
+	}
+	r, err := d.db.Exec("UPDATE tag SET name = $1 WHERE tagid = $2", name, id)
+	if isUniqueViolation(err) {
+		return ErrExistTag
+	} else if err != nil {
+		return err
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTagNotFound
+	}
+	return nil
+}
+
+// DeleteTag deletes the tag and untags the documents
+func (d *DB) DeleteTag(id int) error {
+	if id <= 0 {
+		return ErrTagNotFound
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM documenttag WHERE tagid = $1", id)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM tag WHERE tagid = $1", id)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return err
+	}
+	return nil
+}
+
+// AddDocumentTag tags the document. Tagging twice is not an error.
+func (d *DB) AddDocumentTag(did string, id int) error {
+	_, err := d.db.Exec("INSERT INTO documenttag VALUES($1,$2) ON CONFLICT DO NOTHING", did, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteDocumentTag untags the document
+func (d *DB) DeleteDocumentTag(did string, id int) error {
+	_, err := d.db.Exec("DELETE FROM documenttag WHERE uuid = $1 AND tagid = $2", did, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// tagFilter returns the SQL condition for the documents which have all tags
+func tagFilter(col string, tagids []int, params []interface{}) (string, []interface{}) {
+	if len(tagids) == 0 {
+		return "", params
+	}
+	sql := col + " IN (SELECT uuid FROM documenttag WHERE tagid IN ("
+	for i, v := range tagids {
+		params = append(params, v)
+		if i > 0 {
+			sql += ","
+		}
+		sql += "$" + strconv.Itoa(len(params))
+	}
+	params = append(params, len(tagids))
+	sql += ") GROUP BY uuid HAVING COUNT(*) = $" + strconv.Itoa(len(params)) + ")"
+	return sql, params
+}
@@ -0,0 +1,266 @@
+package db
+
+import (
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Task states for filtering
+const (
+	TaskStateAll = iota
+	TaskStateOpen
+	TaskStateDone
+)
+
+var (
+	taskPattern      = regexp.MustCompile(`^(\s*(?:[-*+]|\d+[.)])\s+\[)([ xX])(\]\s+)(.*)$`)
+	taskMentionRegex = regexp.MustCompile(`(?:^|\s)@([A-Za-z0-9_.-]+)`)
+	taskDueRegex     = regexp.MustCompile(`(?:due:|📅\s*)(\d{4}-\d{2}-\d{2})`)
+	taskFenceRegex   = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+)
+
+// parsedTask is a task item in the text. Assignees are usernames.
+type parsedTask struct {
+	DocumentTask
+	names []string
+}
+
+// extractTasks returns the task items in the text. Tasks in front matter and fenced code blocks are ignored.
+func extractTasks(text string) []parsedTask {
+	res := []parsedTask{}
+	lines := strings.Split(text, "\n")
+	start := 0
+	if fm, _ := SplitFrontMatter(text); fm != "" {
+		start = strings.Count(fm, "\n") + 2
+	}
+	fence := ""
+	for i := start; i < len(lines); i++ {
+		l := strings.TrimRight(lines[i], "\r")
+		if fence != "" {
+			if strings.HasPrefix(strings.TrimLeft(l, " "), fence) {
+				fence = ""
+			}
+			continue
+		}
+		if m := taskFenceRegex.FindStringSubmatch(l); m != nil {
+			fence = m[1]
+			continue
+		}
+		m := taskPattern.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		t := parsedTask{DocumentTask: DocumentTask{
+			Line: i + 1,
+			Done: m[2] != " ",
+			Text: strings.TrimSpace(m[4]),
+		}}
+		if d := taskDueRegex.FindStringSubmatch(m[4]); d != nil {
+			t.Due = d[1]
+		}
+		found := map[string]bool{}
+		for _, v := range taskMentionRegex.FindAllStringSubmatch(m[4], -1) {
+			name := strings.TrimRight(v[1], ".")
+			if !found[name] {
+				found[name] = true
+				t.names = append(t.names, name)
+			}
+		}
+		res = append(res, t)
+	}
+	return res
+}
+
+// SetTaskState changes the checkbox of the task and returns new text.
+// The task is found by the line and the text. If the line is moved, the task which has the same text is used.
+func SetTaskState(text string, line int, tasktext string, done bool) (string, error) {
+	lines := strings.Split(text, "\n")
+	target := -1
+	tasks := extractTasks(text)
+	for _, t := range tasks {
+		if t.Line == line && (tasktext == "" || t.Text == tasktext) {
+			target = t.Line - 1
+			break
+		}
+	}
+	if target < 0 && tasktext != "" {
+		for _, t := range tasks {
+			if t.Text != tasktext {
+				continue
+			}
+			if target >= 0 {
+				// Ambiguous
+				return "", ErrTaskNotFound
+			}
+			target = t.Line - 1
+		}
+	}
+	if target < 0 {
+		return "", ErrTaskNotFound
+	}
+	mark := " "
+	if done {
+		mark = "x"
+	}
+	lines[target] = taskPattern.ReplaceAllString(lines[target], "${1}"+mark+"${3}${4}")
+	return strings.Join(lines, "\n"), nil
+}
+
+// saveDocumentTasks replaces the tasks of the document by the task items in the text
+func saveDocumentTasks(q queryer, did string, text string) error {
+	_, err := q.Exec(`DELETE FROM documenttaskassignee WHERE uuid = $1`, did)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(`DELETE FROM documenttask WHERE uuid = $1`, did)
+	if err != nil {
+		return err
+	}
+	users := map[string]string{}
+	for _, t := range extractTasks(text) {
+		_, err = q.Exec(`INSERT INTO documenttask VALUES($1,$2,$3,$4,$5)`, did, t.Line, t.Done, t.Text, t.Due)
+		if err != nil {
+			return err
+		}
+		for _, name := range t.names {
+			uuid, ok := users[name]
+			if !ok {
+				err = q.QueryRow(`SELECT uuid FROM username WHERE username = $1`, name).Scan(&uuid)
+				if err != nil && err != sql.ErrNoRows {
+					return err
+				}
+				users[name] = uuid
+			}
+			if uuid == "" {
+				continue
+			}
+			_, err = q.Exec(`INSERT INTO documenttaskassignee VALUES($1,$2,$3) ON CONFLICT DO NOTHING`, did, t.Line, uuid)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetTasks returns the tasks in the documents which readers can read.
+// If assignee is not empty, only the tasks assigned to it are returned. If did is not empty, only the tasks of the document are returned.
+// Tasks are sorted by due date (no due date is last), updated date of the document and line.
+func (d *DB) GetTasks(assignee string, state int, did string, readers []string, limit int, offset int) (int, []DocumentTask, error) {
+	res := []DocumentTask{}
+	count := 0
+
+	param := []interface{}{FilePermPrivate}
+	cond := " FROM documenttask INNER JOIN document ON documenttask.uuid = document.uuid WHERE (document.permission != $1 OR document.owneruuid IN ("
+	for i, v := range readers {
+		param = append(param, v)
+		if i > 0 {
+			cond += ","
+		}
+		cond += "$" + strconv.Itoa(len(param))
+	}
+	if len(readers) == 0 {
+		cond += "''"
+	}
+	cond += "))"
+	switch state {
+	case TaskStateOpen:
+		cond += " AND documenttask.done = false"
+	case TaskStateDone:
+		cond += " AND documenttask.done = true"
+	}
+	if assignee != "" {
+		param = append(param, assignee)
+		cond += " AND EXISTS (SELECT 1 FROM documenttaskassignee WHERE documenttaskassignee.uuid = documenttask.uuid AND documenttaskassignee.line = documenttask.line AND useruuid = $" + strconv.Itoa(len(param)) + ")"
+	}
+	if did != "" {
+		param = append(param, did)
+		cond += " AND documenttask.uuid = $" + strconv.Itoa(len(param))
+	}
+
+	r := d.db.QueryRow("SELECT COUNT(*)"+cond, param...)
+	err := r.Scan(&count)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sql := "SELECT documenttask.uuid,documenttask.line,documenttask.done,documenttask.text,documenttask.due" + cond +
+		" ORDER BY documenttask.due = '', documenttask.due, document.updatedat DESC, documenttask.uuid, documenttask.line"
+	if limit > 0 {
+		param = append(param, limit)
+		sql += " limit $" + strconv.Itoa(len(param))
+		if offset > 0 {
+			param = append(param, offset)
+			sql += " offset $" + strconv.Itoa(len(param))
+		}
+	}
+	rows, err := d.db.Query(sql, param...)
+	if err != nil {
+		return 0, nil, err
+	}
+	for rows.Next() {
+		var t DocumentTask
+		err = rows.Scan(&t.UUID, &t.Line, &t.Done, &t.Text, &t.Due)
+		if err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		res = append(res, t)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	err = d.setTaskAssignees(res)
+	if err != nil {
+		return 0, nil, err
+	}
+	return count, res, nil
+}
+
+type taskKey struct {
+	did  string
+	line int
+}
+
+// setTaskAssignees fetches the assignees of the tasks in one query
+func (d *DB) setTaskAssignees(tasks []DocumentTask) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	idx := map[taskKey]int{}
+	param := []interface{}{}
+	cond := ""
+	for i, t := range tasks {
+		tasks[i].Assignees = []string{}
+		idx[taskKey{t.UUID, t.Line}] = i
+		param = append(param, t.UUID, t.Line)
+		if i > 0 {
+			cond += ","
+		}
+		cond += "($" + strconv.Itoa(len(param)-1) + ",$" + strconv.Itoa(len(param)) + ")"
+	}
+	rows, err := d.db.Query("SELECT uuid,line,useruuid FROM documenttaskassignee WHERE (uuid,line) IN ("+cond+") ORDER BY useruuid", param...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k taskKey
+		uuid := ""
+		err = rows.Scan(&k.did, &k.line, &uuid)
+		if err != nil {
+			return err
+		}
+		if i, ok := idx[k]; ok {
+			tasks[i].Assignees = append(tasks[i].Assignees, uuid)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return nil
+}
@@ -94,7 +94,6 @@ func (d *DB) CreateTeam(teamname string, useruuid string) (string, error) {
 		}
 		return "", err
 	}
-	// //TODO: tags
 	return teamuuid, nil
 }
 
@@ -141,6 +140,30 @@ func (d *DB) DeleteTeam(teamuuid string) error {
 		return err
 	}
 
+	_, err = tx.Exec("DELETE FROM template WHERE teamuuid = $1", teamuuid)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM documenttag WHERE tagid IN (SELECT tagid FROM tag WHERE teamuuid = $1)", teamuuid)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM tag WHERE teamuuid = $1", teamuuid)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return err
+	}
+
 	_, err = tx.Exec("DELETE FROM teammember WHERE teamuuid = $1", teamuuid)
 	if err != nil {
 		if re := tx.Rollback(); re != nil {
@@ -171,7 +194,6 @@ func (d *DB) DeleteTeam(teamuuid string) error {
 		}
 		return err
 	}
-	//TODO: tags
 	return nil
 }
 
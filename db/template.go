@@ -0,0 +1,168 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// AddTemplate marks the document or folder as the template
+func (d *DB) AddTemplate(t Template) error {
+	_, err := d.db.Exec(`INSERT INTO template VALUES($1,$2,$3,$4,$5,$6)`,
+		t.UUID, t.TeamUUID, t.Name, t.Description, time.Now().Unix(), t.CreatorUUID)
+	if isUniqueViolation(err) {
+		return ErrExistTemplate
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteTemplate unmarks the template
+func (d *DB) DeleteTemplate(id string) error {
+	_, err := d.db.Exec(`DELETE FROM template WHERE uuid = $1`, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetTemplate returns the template info
+func (d *DB) GetTemplate(id string) (Template, error) {
+	ret := Template{UUID: id}
+	r := d.db.QueryRow("SELECT teamuuid,name,description,createdat,creatoruuid FROM template WHERE uuid = $1", id)
+	err := r.Scan(&ret.TeamUUID, &ret.Name, &ret.Description, &ret.CreatedAt, &ret.CreatorUUID)
+	if err == sql.ErrNoRows {
+		return ret, ErrTemplateNotFound
+	} else if err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// GetTemplates returns the instance templates and the templates of the teams
+func (d *DB) GetTemplates(teams []string) ([]Template, error) {
+	params := []interface{}{""}
+	sql := "SELECT uuid,teamuuid,name,description,createdat,creatoruuid FROM template WHERE teamuuid IN ($1"
+	for _, v := range teams {
+		params = append(params, v)
+		sql += ",$" + strconv.Itoa(len(params))
+	}
+	sql += ") ORDER BY name,uuid"
+
+	res := []Template{}
+	rows, err := d.db.Query(sql, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t Template
+		err = rows.Scan(&t.UUID, &t.TeamUUID, &t.Name, &t.Description, &t.CreatedAt, &t.CreatorUUID)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// InstantiateFolder creates the folder and its contents from the template folder in one transaction.
+// Folder names and document texts are converted by fill, and the document titles are extracted from the texts.
+// Only the items which are owned by readers or not private are created. The created items are private and owned by owneruuid.
+// It returns ErrFolderTooLarge if the folder is deeper than maxdepth or has more than maxitems items.
+func (d *DB) InstantiateFolder(fid string, name string, targetfid string, readers []string, owneruuid string, updateruuid string, fill func(string) string, maxdepth int, maxitems int) (string, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	newfid, err := instantiateFolder(tx, fid, name, targetfid, readers, owneruuid, updateruuid, fill, maxdepth, maxitems)
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return "", err
+	}
+	err = tx.Commit()
+	if err != nil {
+		if re := tx.Rollback(); re != nil {
+			err = fmt.Errorf("%s: %w", re.Error(), err)
+		}
+		return "", err
+	}
+	return newfid, nil
+}
+
+func instantiateFolder(q queryer, fid string, name string, targetfid string, readers []string, owneruuid string, updateruuid string, fill func(string) string, maxdepth int, maxitems int) (string, error) {
+	fname := ""
+	err := q.QueryRow(`SELECT name FROM folder WHERE uuid = $1`, fid).Scan(&fname)
+	if err == sql.ErrNoRows {
+		return "", ErrFolderNotFound
+	} else if err != nil {
+		return "", err
+	}
+	if name == "" {
+		name = fname
+	}
+
+	// Documents are one level deeper than their folder
+	items, err := getFolderDescendants(q, fid, maxdepth+1)
+	if err != nil {
+		return "", err
+	}
+	isReadable := func(owner string, perm FilePerm) bool {
+		if perm != FilePermPrivate {
+			return true
+		}
+		for _, v := range readers {
+			if owner == v {
+				return true
+			}
+		}
+		return false
+	}
+
+	newfid, err := createFolder(q, fill(name), FilePermPrivate, targetfid, owneruuid, updateruuid)
+	if err != nil {
+		return "", err
+	}
+	idmap := map[string]string{fid: newfid}
+	for _, v := range items {
+		parent, ok := idmap[v.ParentFolderUUID]
+		if !ok || !isReadable(v.OwnerUUID, v.Permission) {
+			continue
+		}
+		if len(idmap) >= maxitems {
+			return "", ErrFolderTooLarge
+		}
+		if v.UUID[0] == 'f' {
+			if v.Depth > maxdepth {
+				return "", ErrFolderTooLarge
+			}
+			idmap[v.UUID], err = createFolder(q, fill(v.Name), FilePermPrivate, parent, owneruuid, updateruuid)
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+		text := ""
+		err = q.QueryRow("SELECT text FROM documentrevision INNER JOIN document ON (documentrevision.uuid = document.uuid AND documentrevision.revision = document.revision) WHERE document.uuid = $1", v.UUID).Scan(&text)
+		if err != nil {
+			return "", err
+		}
+		text = fill(text)
+		title := ExtractTitle(text)
+		if title == "" {
+			title = "Untitled"
+		}
+		idmap[v.UUID], err = createDocument(q, title, text, FilePermPrivate, parent, owneruuid, updateruuid)
+		if err != nil {
+			return "", err
+		}
+	}
+	return newfid, nil
+}
@@ -58,7 +58,17 @@ CREATE TABLE IF NOT EXISTS teammember(
   FOREIGN KEY (teamuuid) REFERENCES username(UUID),
   FOREIGN KEY (useruuid) REFERENCES auth(uuid)
 );
-CREATE TABLE IF NOT EXISTS tag(tagid SERIAL PRIMARY KEY, name TEXT UNIQUE NOT NULL);
+-- teamuuid is empty for the instance tag. tagid 0 (notag) is reserved.
+CREATE TABLE IF NOT EXISTS tag(
+  tagid SERIAL PRIMARY KEY,
+  name TEXT NOT NULL,
+  teamuuid TEXT NOT NULL,
+  UNIQUE (teamuuid, name)
+);
+-- Migration from the tag table without team (tag name was unique in the instance)
+ALTER TABLE tag ADD COLUMN IF NOT EXISTS teamuuid TEXT NOT NULL DEFAULT '';
+ALTER TABLE tag DROP CONSTRAINT IF EXISTS tag_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS tag_teamuuid_name_key ON tag(teamuuid, name);
 CREATE TABLE IF NOT EXISTS folder(
   uuid TEXT PRIMARY KEY,
   owneruuid TEXT NOT NULL,
@@ -71,6 +81,7 @@ CREATE TABLE IF NOT EXISTS folder(
   FOREIGN KEY (owneruuid) REFERENCES username(uuid),
   FOREIGN KEY (updateruuid) REFERENCES username(uuid)
 );
+CREATE INDEX IF NOT EXISTS folder_parentfolderuuid ON folder(parentfolderuuid);
 CREATE TABLE IF NOT EXISTS document(
   uuid TEXT PRIMARY KEY,
   owneruuid TEXT NOT NULL,
@@ -94,6 +105,87 @@ CREATE TABLE IF NOT EXISTS documentrevision(
   PRIMARY KEY (uuid, revision),
   FOREIGN KEY (uuid) REFERENCES document(uuid)
 );
+CREATE TABLE IF NOT EXISTS documentblame(
+  uuid TEXT NOT NULL,
+  revision INTEGER NOT NULL,
+  blame TEXT NOT NULL,
+  PRIMARY KEY (uuid, revision),
+  FOREIGN KEY (uuid, revision) REFERENCES documentrevision(uuid, revision)
+);
+CREATE TABLE IF NOT EXISTS documentops(
+  uuid TEXT NOT NULL,
+  revision INTEGER NOT NULL,
+  ops TEXT NOT NULL,
+  PRIMARY KEY (uuid, revision),
+  FOREIGN KEY (uuid, revision) REFERENCES documentrevision(uuid, revision)
+);
+CREATE TABLE IF NOT EXISTS documentchat(
+  id BIGSERIAL PRIMARY KEY,
+  uuid TEXT NOT NULL,
+  useruuid TEXT NOT NULL,
+  message TEXT NOT NULL,
+  date BIGINT NOT NULL,
+  FOREIGN KEY (uuid) REFERENCES document(uuid),
+  FOREIGN KEY (useruuid) REFERENCES username(uuid)
+);
+CREATE INDEX IF NOT EXISTS documentchat_uuid_id ON documentchat(uuid, id);
+CREATE TABLE IF NOT EXISTS documenttag(
+  uuid TEXT NOT NULL,
+  tagid INTEGER NOT NULL,
+  PRIMARY KEY (uuid, tagid),
+  FOREIGN KEY (uuid) REFERENCES document(uuid),
+  FOREIGN KEY (tagid) REFERENCES tag(tagid)
+);
+CREATE INDEX IF NOT EXISTS documenttag_tagid ON documenttag(tagid);
+-- Properties from YAML front matter of the latest revision
+CREATE TABLE IF NOT EXISTS documentproperty(
+  uuid TEXT NOT NULL,
+  key TEXT NOT NULL,
+  type TEXT NOT NULL,
+  value TEXT NOT NULL,
+  PRIMARY KEY (uuid, key),
+  FOREIGN KEY (uuid) REFERENCES document(uuid)
+);
+CREATE INDEX IF NOT EXISTS documentproperty_key_value ON documentproperty(key, value);
+-- Links between documents. target is empty for the unresolved wiki link and title is empty for the URL link.
+CREATE TABLE IF NOT EXISTS documentlink(
+  uuid TEXT NOT NULL,
+  target TEXT NOT NULL,
+  title TEXT NOT NULL,
+  PRIMARY KEY (uuid, target, title),
+  FOREIGN KEY (uuid) REFERENCES document(uuid)
+);
+CREATE INDEX IF NOT EXISTS documentlink_target ON documentlink(target);
+CREATE INDEX IF NOT EXISTS documentlink_title ON documentlink(lower(title));
+-- Task items (checkboxes) of the latest revision. line is 1-based and due is YYYY-MM-DD or empty.
+CREATE TABLE IF NOT EXISTS documenttask(
+  uuid TEXT NOT NULL,
+  line INTEGER NOT NULL,
+  done BOOLEAN NOT NULL,
+  text TEXT NOT NULL,
+  due TEXT NOT NULL,
+  PRIMARY KEY (uuid, line),
+  FOREIGN KEY (uuid) REFERENCES document(uuid)
+);
+CREATE TABLE IF NOT EXISTS documenttaskassignee(
+  uuid TEXT NOT NULL,
+  line INTEGER NOT NULL,
+  useruuid TEXT NOT NULL,
+  PRIMARY KEY (uuid, line, useruuid),
+  FOREIGN KEY (uuid, line) REFERENCES documenttask(uuid, line),
+  FOREIGN KEY (useruuid) REFERENCES username(uuid)
+);
+CREATE INDEX IF NOT EXISTS documenttaskassignee_useruuid ON documenttaskassignee(useruuid);
+-- Template of document or folder. teamuuid is empty for the instance template.
+CREATE TABLE IF NOT EXISTS template(
+  uuid TEXT PRIMARY KEY,
+  teamuuid TEXT NOT NULL,
+  name TEXT NOT NULL,
+  description TEXT NOT NULL,
+  createdat BIGINT NOT NULL,
+  creatoruuid TEXT NOT NULL,
+  FOREIGN KEY (creatoruuid) REFERENCES username(uuid)
+);
 CREATE TABLE IF NOT EXISTS log(
   uuid TEXT NOT NULL,
   date BIGINT NOT NULL,
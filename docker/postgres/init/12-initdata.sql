@@ -13,7 +13,7 @@ INSERT INTO teammember VALUES('tqssoagvfvlg3mky2', 'ujafzavrqkqthqe54', 2, 1);
 
 -- Default Environments
 -- Default tag
-INSERT INTO tag VALUES (0,'notag');
+INSERT INTO tag VALUES (0,'notag','');
 -- Root folder
 INSERT INTO folder VALUES('fwk6al7nyj4qdufaz','tqssoagvfvlg3mky2','','',1,1,1,'ujafzavrqkqthqe54');
 -- User folder
@@ -1,7 +1,6 @@
 package handler
 
 import (
-	"log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -9,6 +8,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/wonder-wonder/cakemix-server/db"
 	"github.com/wonder-wonder/cakemix-server/model"
+	"github.com/wonder-wonder/cakemix-server/ot"
 	"github.com/wonder-wonder/cakemix-server/util"
 )
 
@@ -100,6 +100,7 @@ func (h *Handler) logoutHandler(c *gin.Context) {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+	h.otmgr.Disconnect(uuid, sessid, ot.CloseCodeSessionRevoked, "logged out")
 	c.AbortWithStatus(http.StatusOK)
 }
 
@@ -157,9 +158,9 @@ func (h *Handler) registHandler(c *gin.Context) {
 	}
 
 	go func() {
-		err = util.SendMailWithTemplate(req.Email, req.UserName, "Verify Email address", mailTmplRegist, map[string]string{"NAME": req.UserName, "TOKEN": token})
+		err = h.getMailer().SendMailWithTemplate(req.Email, req.UserName, "Verify Email address", h.getConf().Mail.TmplRegist, map[string]string{"NAME": req.UserName, "TOKEN": token})
 		if err != nil {
-			log.Printf("SendMailError: %v", err)
+			util.Errorf("SendMailError: %v", err)
 		}
 	}()
 
@@ -285,9 +286,9 @@ func (h *Handler) passResetHandler(c *gin.Context) {
 	}
 
 	go func() {
-		err = util.SendMailWithTemplate(req.Email, prof.Name, "Reset password", mailTmplResetPW, map[string]string{"NAME": prof.Name, "TOKEN": token})
+		err = h.getMailer().SendMailWithTemplate(req.Email, prof.Name, "Reset password", h.getConf().Mail.TmplResetPW, map[string]string{"NAME": prof.Name, "TOKEN": token})
 		if err != nil {
-			log.Printf("SendMailError: %v", err)
+			util.Errorf("SendMailError: %v", err)
 		}
 	}()
 
@@ -386,6 +387,7 @@ func (h *Handler) removeSessionHandler(c *gin.Context) {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+	h.otmgr.Disconnect(uuid, token, ot.CloseCodeSessionRevoked, "session revoked")
 	c.AbortWithStatus(http.StatusOK)
 }
 
@@ -546,6 +548,7 @@ func (h *Handler) lockUserHandler(c *gin.Context) {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+	h.otmgr.Disconnect(targetuuid, "", ot.CloseCodeAccountLocked, "account locked")
 
 	c.AbortWithStatus(http.StatusOK)
 }
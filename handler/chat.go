@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wonder-wonder/cakemix-server/db"
+	"github.com/wonder-wonder/cakemix-server/model"
+)
+
+// ChatLimitMax is max number of chat messages per request
+const ChatLimitMax = 100
+
+// getDocumentChatHandler returns the chat messages older than "before" in order of newest first
+func (h *Handler) getDocumentChatHandler(c *gin.Context) {
+	var before int64
+	limit := ChatLimitMax
+	var err error
+	if c.Query("before") != "" {
+		before, err = strconv.ParseInt(c.Query("before"), 10, 64)
+		if err != nil || before <= 0 {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+	if c.Query("limit") != "" {
+		limit, err = strconv.Atoi(c.Query("limit"))
+		if err != nil || limit <= 0 {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		if limit > ChatLimitMax {
+			limit = ChatLimitMax
+		}
+	}
+
+	dinfo, ok := h.getDocumentInfoByParam(c)
+	if !ok {
+		return
+	}
+	if !isRelatedUUID(c, dinfo.OwnerUUID) && dinfo.Permission == db.FilePermPrivate {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	msgs, err := h.db.GetDocumentChat(dinfo.UUID, before, limit+1)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	res := model.DocumentChatRes{
+		Messages: []model.DocumentChatMessage{},
+		HasNext:  len(msgs) == limit+1,
+	}
+	authors := []string{}
+	for i, v := range msgs {
+		if i == limit {
+			break
+		}
+		res.Messages = append(res.Messages, model.DocumentChatMessage{
+			ID:      v.ID,
+			UUID:    v.UserUUID,
+			Message: v.Message,
+			Date:    v.Date,
+		})
+		authors = append(authors, v.UserUUID)
+	}
+	res.Authors, err = h.getProfiles(authors)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusOK, res)
+}
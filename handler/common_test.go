@@ -47,6 +47,9 @@ func TestMain(m *testing.M) {
 	dbexec("INSERT INTO folder VALUES('fw2ytzvb2y5qqpjfk','urtsqctxpdg3ypzan','fdahpbkboamdbgnua','user1',0,1610798538,1610798538,'urtsqctxpdg3ypzan');")
 	dbexec("INSERT INTO document VALUES('dzhkyo37b63qk3yj5','ujafzavrqkqthqe54','fwk6al7nyj4qdufaz','TestDoc2',2,1610798538,1610798538,'ujafzavrqkqthqe54',0,1);")
 	dbexec("INSERT INTO documentrevision VALUES('dzhkyo37b63qk3yj5','This is a test.',1610798538,1);")
+	dbexec("INSERT INTO documentchat(uuid,useruuid,message,date) VALUES('dzhkyo37b63qk3yj5','ujafzavrqkqthqe54','first',1610798538);")
+	dbexec("INSERT INTO documentchat(uuid,useruuid,message,date) VALUES('dzhkyo37b63qk3yj5','ujafzavrqkqthqe54','second',1610798539);")
+	dbexec("INSERT INTO documentchat(uuid,useruuid,message,date) VALUES('dzhkyo37b63qk3yj5','ujafzavrqkqthqe54','third',1610798540);")
 
 	err = tx.Commit()
 	if err != nil {
@@ -63,18 +66,22 @@ func TestMain(m *testing.M) {
 func testInit(tb testing.TB) *gin.Engine {
 	tb.Helper()
 
+	conf := util.DefaultConfig()
 	conffile := "../example/cakemix.conf.test"
 	_, err := os.Stat(conffile)
 	if err == nil {
-		err = util.LoadConfigFile(conffile)
+		err = util.LoadConfigFile(conffile, &conf)
 		if err != nil {
 			panic(err)
 		}
 	}
-	util.LoadConfigEnv()
-	fileconf := util.GetFileConf()
-	dbconf := util.GetDBConf()
-	mailconf := util.GetMailConf()
+	err = util.LoadConfigEnv(&conf)
+	if err != nil {
+		panic(err)
+	}
+	fileconf := conf.File
+	dbconf := conf.DB
+	mailconf := conf.Mail
 
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
@@ -92,13 +99,21 @@ func testInit(tb testing.TB) *gin.Engine {
 	if err != nil {
 		tb.Errorf("testInit: %v", err)
 	}
-	db, err := db.OpenDB(dbconf.Host, dbconf.Port, dbconf.User, dbconf.Pass, dbconf.Name)
+	db, err := db.OpenDB(db.Config{
+		Host:    dbconf.Host,
+		Port:    dbconf.Port,
+		User:    dbconf.User,
+		Pass:    dbconf.Pass,
+		Name:    dbconf.Name,
+		URL:     dbconf.URL,
+		SSLMode: dbconf.SSLMode,
+	})
 	if err != nil {
 		tb.Errorf("testInit: %v", err)
 	}
 
 	v1 := r.Group("v1")
-	h := NewHandler(db, HandlerConf{DataDir: fileconf.DataDir, MailTemplateResetPW: mailconf.TmplResetPW, MailTemplateRegist: mailconf.TmplRegist})
+	h := NewHandler(db, HandlerConf{DataDir: fileconf.DataDir, Mail: mailconf})
 	h.AuthHandler(v1)
 	h.DocumentHandler(v1)
 	h.FolderHandler(v1)
@@ -106,6 +121,10 @@ func testInit(tb testing.TB) *gin.Engine {
 	h.TeamHandler(v1)
 	h.SearchHandler(v1)
 	h.ImageHandler(v1)
+	h.PresenceHandler(v1)
+	h.TemplateHandler(v1)
+	h.TagHandler(v1)
+	h.TaskHandler(v1)
 
 	return r
 }
@@ -134,6 +153,29 @@ func testGetToken(tb testing.TB, r *gin.Engine) string {
 	return jwt
 }
 
+func testCreateDocument(tb testing.TB, r *gin.Engine, token string, reqbody string) string {
+	tb.Helper()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/doc/fwk6al7nyj4qdufaz", bytes.NewBufferString(reqbody))
+	req.Header.Set("Authorization", `Bearer `+token)
+	r.ServeHTTP(w, req)
+	if !assert.Equal(tb, 200, w.Code) {
+		tb.FailNow()
+	}
+
+	var res map[string]string
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if !assert.NoError(tb, err, "fail to umarshal json:\n%v", err) {
+		tb.FailNow()
+	}
+	did := res["doc_id"]
+	if !assert.NotEmpty(tb, did) {
+		tb.FailNow()
+	}
+	return did
+}
+
 func testOpenDB() (*sql.DB, error) {
 	var (
 		dbHost = "cakemixpg"
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wonder-wonder/cakemix-server/db"
+	"github.com/wonder-wonder/cakemix-server/model"
+	"github.com/wonder-wonder/cakemix-server/ot"
+)
+
+// ContentSizeMax is max size of the request body to update content
+const ContentSizeMax = 32 << 20 // 32 MiB
+
+func contentETag(version string) string {
+	return `"` + version + `"`
+}
+
+// parseIfMatch returns the content version in If-Match header. Empty means no condition.
+func parseIfMatch(c *gin.Context) (string, bool) {
+	v := strings.TrimSpace(c.GetHeader("If-Match"))
+	if v == "" || v == "*" {
+		return "", true
+	}
+	v = strings.Trim(strings.TrimPrefix(v, "W/"), `"`)
+	if v == "" {
+		return "", false
+	}
+	return v, true
+}
+
+func (h *Handler) getDocumentContentHandler(c *gin.Context) {
+	dinfo, ok := h.getDocumentInfoByParam(c)
+	if !ok {
+		return
+	}
+	if !isRelatedUUID(c, dinfo.OwnerUUID) && dinfo.Permission == db.FilePermPrivate {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	text, version, err := h.otmgr.GetContent(dinfo.UUID)
+	if err != nil {
+		if errors.Is(err, ot.ErrorSessionBusy) {
+			c.AbortWithError(http.StatusServiceUnavailable, err)
+			return
+		}
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	etag := contentETag(version)
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(text))
+}
+
+func (h *Handler) putDocumentContentHandler(c *gin.Context) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, ContentSizeMax))
+	if err != nil {
+		c.AbortWithError(http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	if !utf8.Valid(body) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	h.updateDocumentContent(c, ot.ReplaceText(string(body)))
+}
+
+func (h *Handler) patchDocumentContentHandler(c *gin.Context) {
+	req := model.DocumentContentPatchReq{}
+	err := c.BindJSON(&req)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	h.updateDocumentContent(c, ot.ApplyOperation(req.Operation))
+}
+
+// updateDocumentContent checks the permission and If-Match header, then applies the change
+func (h *Handler) updateDocumentContent(c *gin.Context, change ot.ContentChange) {
+	uuid, ok := getUUID(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	baseversion, ok := parseIfMatch(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	dinfo, ok := h.getDocumentInfoByParam(c)
+	if !ok {
+		return
+	}
+	if !isRelatedUUID(c, dinfo.OwnerUUID) && dinfo.Permission != db.FilePermReadWrite {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	rev, err := h.otmgr.UpdateContent(dinfo.UUID, uuid, baseversion, change)
+	if err != nil {
+		if errors.Is(err, db.ErrRevisionMismatch) {
+			c.AbortWithError(http.StatusConflict, err)
+			return
+		} else if errors.Is(err, ot.ErrorInvalidOperation) {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		} else if errors.Is(err, ot.ErrorDocumentTooLarge) {
+			c.AbortWithError(http.StatusRequestEntityTooLarge, err)
+			return
+		} else if errors.Is(err, ot.ErrorSessionBusy) {
+			c.AbortWithError(http.StatusServiceUnavailable, err)
+			return
+		}
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Header("ETag", contentETag(strconv.Itoa(rev)))
+	c.AbortWithStatusJSON(http.StatusOK, model.DocumentContentRes{Revision: rev})
+}
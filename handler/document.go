@@ -2,27 +2,46 @@ package handler
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"io/ioutil"
 	"net/http"
+	"strconv"
 	"time"
+	"unicode/utf16"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/wonder-wonder/cakemix-server/db"
 	"github.com/wonder-wonder/cakemix-server/model"
 	"github.com/wonder-wonder/cakemix-server/ot"
+	"github.com/wonder-wonder/cakemix-server/util"
 )
 
 // DocumentHandler is handlers of documents
 func (h *Handler) DocumentHandler(r *gin.RouterGroup) {
 	r.GET("doc/:docid/ws", h.getOTHandler)
+	r.GET("doc/:docid/timeline/ws", h.replayTimelineHandler)
 	docck := r.Group("doc", h.CheckAuthMiddleware())
+	docck.GET("", h.searchDocumentHandler)
 	docck.GET(":docid", h.getDocumentHandler)
 	docck.POST(":id", h.createDocumentHandler)
 	docck.DELETE(":docid", h.deleteDocumentHandler)
 	docck.PUT(":docid/move/:folderid", h.moveDocumentHandler)
 	docck.POST(":id/copy/:folderid", h.duplicateDocumentHandler)
 	docck.PUT(":docid", h.modifyDocumentHandler)
+	docck.GET(":docid/blame", h.getDocumentBlameHandler)
+	docck.GET(":docid/timeline", h.getTimelineHandler)
+	docck.GET(":docid/chat", h.getDocumentChatHandler)
+	docck.GET(":docid/presence", h.getDocumentPresenceHandler)
+	docck.GET(":docid/content", h.getDocumentContentHandler)
+	docck.PUT(":docid/content", h.putDocumentContentHandler)
+	docck.PATCH(":docid/content", h.patchDocumentContentHandler)
+	docck.GET(":docid/outline", h.getDocumentOutlineHandler)
+	docck.GET(":docid/links", h.getDocumentLinksHandler)
+	docck.GET(":docid/backlinks", h.getDocumentBacklinksHandler)
+	docck.GET(":docid/tags", h.getDocumentTagsHandler)
+	docck.PUT(":docid/tags/:tagid", h.addDocumentTagHandler)
+	docck.DELETE(":docid/tags/:tagid", h.deleteDocumentTagHandler)
 }
 
 func (h *Handler) getDocumentHandler(c *gin.Context) {
@@ -79,6 +98,16 @@ func (h *Handler) getDocumentHandler(c *gin.Context) {
 		Editable:       isRelatedUUID(c, dinfo.OwnerUUID) || dinfo.Permission == db.FilePermReadWrite,
 		ParentFolderID: dinfo.ParentFolderUUID,
 	}
+	res.Tags, err = h.getDocumentTags(c, did)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	res.Properties, err = h.getDocumentProperties(did)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
 	// doc, err := h.db.GetLatestDocument(did)
 	// if err != nil {
 	// 	c.AbortWithError(http.StatusInternalServerError, err)
@@ -87,6 +116,118 @@ func (h *Handler) getDocumentHandler(c *gin.Context) {
 	c.AbortWithStatusJSON(http.StatusOK, res)
 }
 
+func (h *Handler) getDocumentBlameHandler(c *gin.Context) {
+	did := c.Param("docid")
+
+	if did == "" || did[0] != 'd' {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	rev := 0
+	if c.Query("rev") != "" {
+		var err error
+		rev, err = strconv.Atoi(c.Query("rev"))
+		if err != nil || rev <= 0 {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	dinfo, err := h.db.GetDocumentInfo(did)
+	if err != nil {
+		if err == db.ErrDocumentNotFound {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !isRelatedUUID(c, dinfo.OwnerUUID) && dinfo.Permission == db.FilePermPrivate {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	docrev, err := h.db.GetDocumentRevision(did, rev)
+	if err != nil {
+		if err == db.ErrDocumentNotFound {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	spans, err := h.db.GetDocumentBlame(did, docrev.Revision)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	// Old revision doesn't have attribution
+	blame := ot.Blame(spans)
+	textlen := len(utf16.Encode([]rune(docrev.Text)))
+	if spans == nil || blame.Len() != textlen {
+		blame = ot.NewBlame(textlen, dinfo.UpdaterUUID, docrev.UpdatedAt)
+	}
+
+	res := model.DocumentBlameRes{
+		Revision: docrev.Revision,
+		Lines:    []model.DocumentBlameLine{},
+	}
+	authors := []string{}
+	for i, v := range blame.Lines(docrev.Text) {
+		res.Lines = append(res.Lines, model.DocumentBlameLine{Line: i + 1, UUID: v.UUID, Time: v.Time})
+		authors = append(authors, v.UUID)
+	}
+	res.Authors, err = h.getProfiles(authors)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusOK, res)
+}
+
+// getProfiles returns the profiles of the users. Removed users are skipped.
+func (h *Handler) getProfiles(uuids []string) (map[string]model.Profile, error) {
+	res := map[string]model.Profile{}
+	for _, v := range uuids {
+		if _, ok := res[v]; ok || v == "" {
+			continue
+		}
+		p, err := h.db.GetProfileByUUID(v)
+		if err == db.ErrUserTeamNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		res[v] = model.Profile{
+			UUID:    p.UUID,
+			Name:    p.Name,
+			IconURI: p.IconURI,
+			Attr:    p.Attr,
+			IsTeam:  (p.UUID[0] == 't'),
+		}
+	}
+	return res, nil
+}
+
+// getDocumentInfoByParam checks the document ID in the path and returns the document info
+func (h *Handler) getDocumentInfoByParam(c *gin.Context) (db.Document, bool) {
+	did := c.Param("docid")
+	if did == "" || did[0] != 'd' {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return db.Document{}, false
+	}
+	dinfo, err := h.db.GetDocumentInfo(did)
+	if err != nil {
+		if err == db.ErrDocumentNotFound {
+			c.AbortWithError(http.StatusNotFound, err)
+			return dinfo, false
+		}
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return dinfo, false
+	}
+	return dinfo, true
+}
+
 func (h *Handler) createDocumentHandler(c *gin.Context) {
 	parentfid := c.Param("id")
 
@@ -101,6 +242,33 @@ func (h *Handler) createDocumentHandler(c *gin.Context) {
 		return
 	}
 
+	// Request body is optional
+	req := model.CreateDocumentReq{}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, ContentSizeMax))
+	if err != nil {
+		c.AbortWithError(http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+	}
+	permission := db.FilePermPrivate
+	if req.Permission != nil {
+		permission = db.FilePerm(*req.Permission)
+		if permission < db.FilePermPrivate || permission > db.FilePermReadWrite {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Body != "" && req.TemplateID != "" {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
 	finfo, err := h.db.GetFolderInfo(parentfid)
 	if err != nil {
 		if err == db.ErrFolderNotFound {
@@ -115,12 +283,52 @@ func (h *Handler) createDocumentHandler(c *gin.Context) {
 		return
 	}
 
+	text := req.Body
+	if req.TemplateID != "" {
+		if req.TemplateID[0] != 'd' {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		tinfo, err := h.db.GetDocumentInfo(req.TemplateID)
+		if err != nil {
+			if err == db.ErrDocumentNotFound {
+				c.AbortWithError(http.StatusNotFound, err)
+				return
+			}
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if !isRelatedUUID(c, tinfo.OwnerUUID) && tinfo.Permission == db.FilePermPrivate {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		// Including the changes in the editing session
+		text, _, err = h.otmgr.GetContent(req.TemplateID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if maxlen := h.getConf().OT.MaxDocumentSize; maxlen > 0 && len(utf16.Encode([]rune(text))) > maxlen {
+		c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Title is derived from the text as same as saving by the editor
+	title := req.Title
+	if title == "" {
+		title = db.ExtractTitle(text)
+	}
+	if title == "" {
+		title = "Untitled"
+	}
+
 	owneruuid := uuid
 	if isRelatedUUID(c, finfo.OwnerUUID) {
 		owneruuid = finfo.OwnerUUID
 	}
 
-	did, err := h.db.CreateDocument("Untitled", db.FilePermPrivate, parentfid, owneruuid, uuid)
+	did, err := h.db.CreateDocument(title, text, permission, parentfid, owneruuid, uuid)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
@@ -178,7 +386,7 @@ func (h *Handler) deleteDocumentHandler(c *gin.Context) {
 	// TODO: check session is opened
 	h.otmgr.StopOTSession(did)
 
-	err = h.db.DeleteDocument(did)
+	broken, err := h.db.DeleteDocument(did)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
@@ -195,7 +403,12 @@ func (h *Handler) deleteDocumentHandler(c *gin.Context) {
 		return
 	}
 
-	c.AbortWithStatus(http.StatusOK)
+	res, err := h.toBrokenLinksModel(c, broken)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusOK, res)
 }
 func (h *Handler) moveDocumentHandler(c *gin.Context) {
 	did := c.Param("docid")
@@ -251,7 +464,7 @@ func (h *Handler) moveDocumentHandler(c *gin.Context) {
 		return
 	}
 
-	err = h.db.MoveDocument(did, targetfid)
+	broken, err := h.db.MoveDocument(did, targetfid)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
@@ -278,7 +491,12 @@ func (h *Handler) moveDocumentHandler(c *gin.Context) {
 		return
 	}
 
-	c.AbortWithStatus(http.StatusOK)
+	res, err := h.toBrokenLinksModel(c, broken)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusOK, res)
 }
 
 func (h *Handler) modifyDocumentHandler(c *gin.Context) {
@@ -333,85 +551,79 @@ func (h *Handler) modifyDocumentHandler(c *gin.Context) {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+	// Apply the permission to editing users
+	h.otmgr.CheckPermission(did, "")
 	c.AbortWithStatus(http.StatusOK)
 }
 
-func (h *Handler) getOTHandler(c *gin.Context) {
-	authWithToken := func(token string) (string, []string, error) {
+// docWSAuth is the result of websocket authentication for the document
+type docWSAuth struct {
+	uuid      string
+	sessionID string
+	editable  bool
+	resume    *ot.ResumeData
+}
+
+// upgradeDocWS authenticates the user by the token (query param or auth message) and checks the document permission, then upgrades to websocket.
+// If it returns false, the response is already sent or the connection is closed.
+func (h *Handler) upgradeDocWS(c *gin.Context, docID string) (*websocket.Conn, docWSAuth, bool) {
+	auth := docWSAuth{}
+	// checkToken returns HTTP status code if failed
+	checkToken := func(token string) (int, error) {
 		// Token check
 		uuid, sessionid, err := h.db.VerifyToken(token)
-		if err != nil {
-			return "", nil, err
+		if err == db.ErrInvalidToken {
+			return http.StatusUnauthorized, err
+		} else if err != nil {
+			return http.StatusInternalServerError, err
 		}
 
 		// Get teams
 		teams, err := h.db.GetTeamsByUser(uuid)
 		if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, err)
-			return "", nil, err
+			return http.StatusInternalServerError, err
 		}
 
 		// Update session last used
 		err = h.db.UpdateSessionLastUsed(uuid, sessionid)
 		if err != nil {
-			return "", nil, err
+			return http.StatusInternalServerError, err
 		}
-		return uuid, teams, nil
-	}
-	isRelatedUUID := func(uuid string, teams []string, target string) bool {
-		if target == uuid {
-			return true
+
+		// Check permission
+		docInfo, err := h.db.GetDocumentInfo(docID)
+		if err == db.ErrDocumentNotFound {
+			return http.StatusNotFound, err
+		} else if err != nil {
+			return http.StatusInternalServerError, err
 		}
+		related := docInfo.OwnerUUID == uuid
 		for _, v := range teams {
-			if target == v {
-				return true
+			if docInfo.OwnerUUID == v {
+				related = true
 			}
 		}
-		return false
-	}
+		if !related && docInfo.Permission == db.FilePermPrivate {
+			return http.StatusForbidden, errors.New("permission denied")
+		}
 
-	docID := c.Param("docid")
-	if docID == "" || docID[0] != 'd' {
-		c.AbortWithStatus(http.StatusBadRequest)
-		return
+		auth.uuid = uuid
+		auth.sessionID = sessionid
+		auth.editable = related || docInfo.Permission == db.FilePermReadWrite
+		return 0, nil
 	}
 
 	authok := false
-	editable := false
-	uuid := ""
-
 	// Legacy support (JWT in query param)
 	if c.Query("token") != "" {
-		token := c.Query("token")
-
-		var teams []string
-		var err error
-
-		uuid, teams, err = authWithToken(token)
-		if err == db.ErrInvalidToken {
-			c.AbortWithStatus(http.StatusUnauthorized)
-			return
-		} else if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, err)
-			return
+		status, err := checkToken(c.Query("token"))
+		if status == http.StatusInternalServerError {
+			c.AbortWithError(status, err)
+			return nil, auth, false
+		} else if status != 0 {
+			c.AbortWithStatus(status)
+			return nil, auth, false
 		}
-
-		// Check permission
-		docInfo, err := h.db.GetDocumentInfo(docID)
-		if err != nil {
-			if err == db.ErrDocumentNotFound {
-				c.AbortWithStatus(http.StatusNotFound)
-				return
-			}
-			c.AbortWithError(http.StatusInternalServerError, err)
-			return
-		}
-		if !isRelatedUUID(uuid, teams, docInfo.OwnerUUID) && docInfo.Permission == db.FilePermPrivate {
-			c.AbortWithStatus(http.StatusForbidden)
-			return
-		}
-
-		editable = isRelatedUUID(uuid, teams, docInfo.OwnerUUID) || docInfo.Permission == db.FilePermReadWrite
 		authok = true
 	}
 
@@ -419,100 +631,134 @@ func (h *Handler) getOTHandler(c *gin.Context) {
 	var wsupgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool {
-			//TODO
-			return true
-		},
+		CheckOrigin:     h.checkWSOrigin,
 	}
 	conn, err := wsupgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		log.Printf("Failed to set websocket upgrade: %v\n", err)
-		return
+		util.Errorf("Failed to set websocket upgrade: %v", err)
+		return nil, auth, false
+	}
+	if authok {
+		return conn, auth, true
 	}
-	defer conn.Close()
 
 	// Authentication in websocket
-	if !authok {
-		// Read raw OT message from websocket
+	authInWS := func() bool {
+		// Read raw message from websocket
 		err := conn.SetReadDeadline(time.Now().Add(time.Second * 5))
 		if err != nil {
-			log.Printf("OT auth error: websockest error: %v\n", err)
-			return
+			util.Errorf("WS auth error: websockest error: %v", err)
+			return false
 		}
 		_, rawmsg, err := conn.ReadMessage()
 		if err != nil {
-			log.Printf("OT auth error: websocket error: %v\n", err)
-			return
+			util.Errorf("WS auth error: websocket error: %v", err)
+			return false
 		}
 		err = conn.SetReadDeadline(time.Time{})
 		if err != nil {
-			log.Printf("OT auth error: websocket error: %v\n", err)
-			return
+			util.Errorf("WS auth error: websocket error: %v", err)
+			return false
 		}
 
 		type authWSMsg struct {
-			Event string `json:"e"`
-			Data  string `json:"d,omitempty"`
+			Event  string         `json:"e"`
+			Data   string         `json:"d,omitempty"`
+			Resume *ot.ResumeData `json:"resume,omitempty"`
 		}
-		// Parse OT message
+		// Parse message
 		msg := authWSMsg{}
 		err = json.Unmarshal(rawmsg, &msg)
 		if err != nil {
-			log.Printf("OT auth error: invalid request: %v\n", err)
-			return
+			util.Errorf("WS auth error: invalid request: %v", err)
+			return false
 		}
 		if msg.Event != "auth" {
-			log.Printf("OT auth error: invalid request: %v\n", msg.Event)
-			return
+			util.Errorf("WS auth error: invalid request: %v", msg.Event)
+			return false
 		}
+		auth.resume = msg.Resume
 
 		// Token check
-		token := msg.Data
-		var teams []string
-		uuid, teams, err = authWithToken(token)
-		if err == db.ErrInvalidToken {
-			log.Printf("OT auth unauthorized")
-			return
-		} else if err != nil {
-			log.Printf("OT auth error: %v\n", err)
-			return
+		status, err := checkToken(msg.Data)
+		if status == http.StatusUnauthorized {
+			util.Warnf("WS auth unauthorized")
+			return false
+		} else if status != 0 {
+			util.Errorf("WS auth error: %v", err)
+			return false
 		}
+		return true
+	}
+	if !authInWS() {
+		conn.Close()
+		return nil, auth, false
+	}
+	return conn, auth, true
+}
 
-		// Check permission
-		docInfo, err := h.db.GetDocumentInfo(docID)
-		if err != nil {
-			if err == db.ErrDocumentNotFound {
-				c.AbortWithStatus(http.StatusNotFound)
-				return
-			}
-			log.Printf("OT auth error: %v\n", err)
-			return
-		}
-		if !isRelatedUUID(uuid, teams, docInfo.OwnerUUID) && docInfo.Permission == db.FilePermPrivate {
-			c.AbortWithStatus(http.StatusForbidden)
+func (h *Handler) getOTHandler(c *gin.Context) {
+	docID := c.Param("docid")
+	if docID == "" || docID[0] != 'd' {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var resume *ot.ResumeData
+	// Resume from the revision (legacy)
+	if c.Query("resume") != "" {
+		rev, err := strconv.Atoi(c.Query("resume"))
+		if err != nil || rev < 0 {
+			c.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
+		resume = &ot.ResumeData{Revision: rev, Epoch: c.Query("epoch")}
+	}
 
-		editable = isRelatedUUID(uuid, teams, docInfo.OwnerUUID) || docInfo.Permission == db.FilePermReadWrite
+	conn, auth, ok := h.upgradeDocWS(c, docID)
+	if !ok {
+		return
+	}
+	defer conn.Close()
+	if auth.resume != nil {
+		resume = auth.resume
 	}
 
 	// Prepare OT session
-	p, err := h.db.GetProfileByUUID(uuid)
+	p, err := h.db.GetProfileByUUID(auth.uuid)
 	if err != nil {
-		log.Printf("OT handler error: %v", err)
+		util.Errorf("OT handler error: %v", err)
 		return
 	}
 
 	cl, err := ot.NewClient(conn, ot.ClientProfile{
-		UUID:    uuid,
-		Name:    p.Name,
-		IconURI: p.IconURI,
-	}, !editable)
+		UUID:      auth.uuid,
+		Name:      p.Name,
+		IconURI:   p.IconURI,
+		SessionID: auth.sessionID,
+	}, !auth.editable)
 	if err != nil {
-		log.Printf("OT handler error: %v", err)
+		util.Errorf("OT handler error: %v", err)
+		return
+	}
+	if resume != nil {
+		cl.Resume(*resume)
+	}
+	err = h.otmgr.ClientConnect(cl, docID)
+	if err != nil {
+		util.Errorf("OT handler error: %v", err)
+		code := ot.CloseCodeSessionError
+		reason := "failed to open the document"
+		if errors.Is(err, db.ErrDocumentNotFound) {
+			code = ot.CloseCodeDocumentNotFound
+			reason = "document not found"
+		}
+		err = cl.Close(code, reason)
+		if err != nil {
+			util.Errorf("OT handler error: %v", err)
+		}
 		return
 	}
-	h.otmgr.ClientConnect(cl, docID)
 	cl.Loop()
 }
 
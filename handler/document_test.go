@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -79,6 +80,151 @@ func TestDocumentHandler(t *testing.T) {
 			})
 		}
 	})
+	t.Run("CreateDocWithBody", func(t *testing.T) {
+		if token == "" {
+			t.SkipNow()
+		}
+		if newdid == "" {
+			t.SkipNow()
+		}
+		tmpldid := testCreateDocument(t, r, token, `{"body":"# From template\nbody\n"}`)
+		type req struct {
+			header   map[string]string
+			folderid string
+			body     string
+		}
+		// Created document is checked if the code is 200
+		type res struct {
+			code       int
+			title      string
+			permission float64
+			body       string
+		}
+		tests := []struct {
+			name string
+			req  req
+			res  res
+		}{
+			{
+				name: "Body",
+				req: req{
+					header:   map[string]string{"Authorization": `Bearer ` + token},
+					folderid: "fwk6al7nyj4qdufaz",
+					body:     `{"title":"Created","body":"# Created\n","permission":1}`,
+				},
+				res: res{
+					code:       200,
+					title:      "Created",
+					permission: 1,
+					body:       "# Created\n",
+				},
+			},
+			{
+				name: "TitleFromBody",
+				req: req{
+					header:   map[string]string{"Authorization": `Bearer ` + token},
+					folderid: "fwk6al7nyj4qdufaz",
+					body:     `{"body":"# Heading title\ntext\n"}`,
+				},
+				res: res{
+					code:       200,
+					title:      "Heading title",
+					permission: 0,
+					body:       "# Heading title\ntext\n",
+				},
+			},
+			{
+				name: "Template",
+				req: req{
+					header:   map[string]string{"Authorization": `Bearer ` + token},
+					folderid: "fwk6al7nyj4qdufaz",
+					body:     `{"template_id":"` + tmpldid + `"}`,
+				},
+				res: res{
+					code:       200,
+					title:      "From template",
+					permission: 0,
+					body:       "# From template\nbody\n",
+				},
+			},
+			{
+				name: "InvalidPermission",
+				req: req{
+					header:   map[string]string{"Authorization": `Bearer ` + token},
+					folderid: "fwk6al7nyj4qdufaz",
+					body:     `{"permission":5}`,
+				},
+				res: res{
+					code: 400,
+				},
+			},
+			{
+				name: "BodyAndTemplate",
+				req: req{
+					header:   map[string]string{"Authorization": `Bearer ` + token},
+					folderid: "fwk6al7nyj4qdufaz",
+					body:     `{"body":"text","template_id":"` + newdid + `"}`,
+				},
+				res: res{
+					code: 400,
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest("POST", "/v1/doc/"+tt.req.folderid, bytes.NewBufferString(tt.req.body))
+				for hk, hv := range tt.req.header {
+					req.Header.Set(hk, hv)
+				}
+				r.ServeHTTP(w, req)
+				if !assert.Equal(t, tt.res.code, w.Code) {
+					t.FailNow()
+				}
+				if w.Code != 200 {
+					return
+				}
+
+				var res map[string]interface{}
+				err := json.Unmarshal(w.Body.Bytes(), &res)
+				if !assert.NoError(t, err, "fail to umarshal json:\n%v", err) {
+					t.FailNow()
+				}
+				did, ok := res["doc_id"].(string)
+				if !assert.True(t, ok, "should has doc_id, got:\n%v", res) {
+					t.FailNow()
+				}
+
+				w = httptest.NewRecorder()
+				req, _ = http.NewRequest("GET", "/v1/doc/"+did, nil)
+				for hk, hv := range tt.req.header {
+					req.Header.Set(hk, hv)
+				}
+				r.ServeHTTP(w, req)
+				if !assert.Equal(t, 200, w.Code) {
+					t.FailNow()
+				}
+				var info map[string]interface{}
+				err = json.Unmarshal(w.Body.Bytes(), &info)
+				if !assert.NoError(t, err, "fail to umarshal json:\n%v", err) {
+					t.FailNow()
+				}
+				assert.Equal(t, tt.res.title, info["title"])
+				assert.Equal(t, tt.res.permission, info["permission"])
+
+				w = httptest.NewRecorder()
+				req, _ = http.NewRequest("GET", "/v1/doc/"+did+"/content", nil)
+				for hk, hv := range tt.req.header {
+					req.Header.Set(hk, hv)
+				}
+				r.ServeHTTP(w, req)
+				if !assert.Equal(t, 200, w.Code) {
+					t.FailNow()
+				}
+				assert.Equal(t, tt.res.body, w.Body.String())
+			})
+		}
+	})
 	t.Run("GetDocInfo", func(t *testing.T) {
 		if token == "" {
 			t.SkipNow()
@@ -152,6 +298,1762 @@ func TestDocumentHandler(t *testing.T) {
 			})
 		}
 	})
+	t.Run("GetDocBlame", func(t *testing.T) {
+		if token == "" {
+			t.SkipNow()
+		}
+		if newdid == "" {
+			t.SkipNow()
+		}
+		type req struct {
+			header map[string]string
+			docid  string
+			query  string
+		}
+		type res struct {
+			code  int
+			check func(t *testing.T, res map[string]interface{})
+		}
+		tests := []struct {
+			name string
+			req  req
+			res  res
+		}{
+			{
+				name: "Latest",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+				},
+				res: res{
+					code: 200,
+				},
+			},
+			{
+				name: "Revision",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					query:  "?rev=1",
+				},
+				res: res{
+					code: 200,
+				},
+			},
+			{
+				name: "WithoutBlame",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					docid:  "dzhkyo37b63qk3yj5",
+				},
+				res: res{
+					code: 200,
+					check: func(t *testing.T, res map[string]interface{}) {
+						// The text without blame is attributed to the last updater
+						lines, _ := res["lines"].([]interface{})
+						if !assert.Len(t, lines, 1) {
+							t.FailNow()
+						}
+						line, _ := lines[0].(map[string]interface{})
+						assert.Equal(t, float64(1), line["line"])
+						assert.Equal(t, "ujafzavrqkqthqe54", line["uuid"])
+						authors, _ := res["authors"].(map[string]interface{})
+						assert.Contains(t, authors, "ujafzavrqkqthqe54")
+						assert.Equal(t, float64(1), res["revision"])
+					},
+				},
+			},
+			{
+				name: "NotFoundRevision",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					query:  "?rev=100",
+				},
+				res: res{
+					code: 404,
+				},
+			},
+			{
+				name: "InvalidRevision",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					query:  "?rev=abc",
+				},
+				res: res{
+					code: 400,
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				docid := tt.req.docid
+				if docid == "" {
+					docid = newdid
+				}
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/v1/doc/"+docid+"/blame"+tt.req.query, nil)
+				for hk, hv := range tt.req.header {
+					req.Header.Set(hk, hv)
+				}
+				r.ServeHTTP(w, req)
+				if !assert.Equal(t, tt.res.code, w.Code) {
+					t.FailNow()
+				}
+				if w.Code != 200 {
+					return
+				}
+
+				var res map[string]interface{}
+				err := json.Unmarshal(w.Body.Bytes(), &res)
+				if !assert.NoError(t, err, "fail to umarshal json:\n%v", err) {
+					t.FailNow()
+				}
+				attrs := []string{"revision", "lines", "authors"}
+				for _, v := range attrs {
+					_, ok := res[v]
+					if !assert.True(t, ok, "should has %s, got:\n%v", v, res) {
+						t.FailNow()
+					}
+				}
+				if tt.res.check != nil {
+					tt.res.check(t, res)
+				}
+			})
+		}
+	})
+	t.Run("GetDocTimeline", func(t *testing.T) {
+		if token == "" {
+			t.SkipNow()
+		}
+		// Revision 1 to 3
+		tldid := testCreateDocument(t, r, token, `{"body":"a"}`)
+		for _, v := range []string{"ab", "abc"} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PUT", "/v1/doc/"+tldid+"/content", bytes.NewBufferString(v))
+			req.Header.Set("Authorization", `Bearer `+token)
+			r.ServeHTTP(w, req)
+			if !assert.Equal(t, 200, w.Code) {
+				t.FailNow()
+			}
+		}
+		// checkChanges checks the changes are sorted and the last one is the last revision
+		checkChanges := func(t *testing.T, res map[string]interface{}) {
+			changes, _ := res["changes"].([]interface{})
+			if !assert.NotEmpty(t, changes) {
+				t.FailNow()
+			}
+			base, _ := res["base_revision"].(float64)
+			prev := base
+			for _, v := range changes {
+				rev, _ := v.(map[string]interface{})["revision"].(float64)
+				assert.Greater(t, rev, base)
+				assert.GreaterOrEqual(t, rev, prev)
+				prev = rev
+			}
+			assert.Equal(t, res["last_revision"], prev)
+		}
+		type req struct {
+			header map[string]string
+			query  string
+		}
+		type res struct {
+			code  int
+			check func(t *testing.T, res map[string]interface{})
+		}
+		tests := []struct {
+			name string
+			req  req
+			res  res
+		}{
+			{
+				name: "Default",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+				},
+				res: res{
+					code: 200,
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.Equal(t, float64(1), res["base_revision"])
+						assert.Equal(t, "a", res["base"])
+						assert.Equal(t, float64(3), res["last_revision"])
+						assert.Equal(t, false, res["has_more"])
+						checkChanges(t, res)
+					},
+				},
+			},
+			{
+				name: "Limit",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					query:  "?from=1&limit=1",
+				},
+				res: res{
+					code: 200,
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.Equal(t, float64(1), res["base_revision"])
+						assert.Equal(t, float64(2), res["last_revision"])
+						assert.Equal(t, true, res["has_more"])
+						checkChanges(t, res)
+					},
+				},
+			},
+			{
+				name: "From",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					query:  "?from=2",
+				},
+				res: res{
+					code: 200,
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.Equal(t, float64(2), res["base_revision"])
+						assert.Equal(t, "ab", res["base"])
+						assert.Equal(t, float64(3), res["last_revision"])
+						assert.Equal(t, false, res["has_more"])
+						checkChanges(t, res)
+					},
+				},
+			},
+			{
+				name: "NotFoundRevision",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					query:  "?from=100",
+				},
+				res: res{
+					code: 404,
+				},
+			},
+			{
+				name: "InvalidLimit",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					query:  "?limit=0",
+				},
+				res: res{
+					code: 400,
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/v1/doc/"+tldid+"/timeline"+tt.req.query, nil)
+				for hk, hv := range tt.req.header {
+					req.Header.Set(hk, hv)
+				}
+				r.ServeHTTP(w, req)
+				if !assert.Equal(t, tt.res.code, w.Code) {
+					t.FailNow()
+				}
+				if w.Code != 200 {
+					return
+				}
+
+				var res map[string]interface{}
+				err := json.Unmarshal(w.Body.Bytes(), &res)
+				if !assert.NoError(t, err, "fail to umarshal json:\n%v", err) {
+					t.FailNow()
+				}
+				attrs := []string{"base_revision", "base", "last_revision", "has_more", "changes", "authors"}
+				for _, v := range attrs {
+					_, ok := res[v]
+					if !assert.True(t, ok, "should has %s, got:\n%v", v, res) {
+						t.FailNow()
+					}
+				}
+				if tt.res.check != nil {
+					tt.res.check(t, res)
+				}
+			})
+		}
+	})
+	t.Run("GetDocChat", func(t *testing.T) {
+		if token == "" {
+			t.SkipNow()
+		}
+		if newdid == "" {
+			t.SkipNow()
+		}
+		// ID of the oldest message in the previous response
+		var lastid int64
+		type req struct {
+			header map[string]string
+			docid  string
+			query  string
+			// beforeLast adds lastid as before
+			beforeLast bool
+		}
+		type res struct {
+			code     int
+			messages []string
+			hasNext  bool
+		}
+		tests := []struct {
+			name string
+			req  req
+			res  res
+		}{
+			{
+				name: "Latest",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+				},
+				res: res{
+					code: 200,
+				},
+			},
+			{
+				name: "Before",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					query:  "?before=1&limit=10",
+				},
+				res: res{
+					code: 200,
+				},
+			},
+			{
+				name: "NewestFirst",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					docid:  "dzhkyo37b63qk3yj5",
+					query:  "?limit=2",
+				},
+				res: res{
+					code:     200,
+					messages: []string{"third", "second"},
+					hasNext:  true,
+				},
+			},
+			{
+				name: "NextPage",
+				req: req{
+					header:     map[string]string{"Authorization": `Bearer ` + token},
+					docid:      "dzhkyo37b63qk3yj5",
+					query:      "?limit=2",
+					beforeLast: true,
+				},
+				res: res{
+					code:     200,
+					messages: []string{"first"},
+					hasNext:  false,
+				},
+			},
+			{
+				name: "InvalidBefore",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					query:  "?before=abc",
+				},
+				res: res{
+					code: 400,
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				docid := tt.req.docid
+				if docid == "" {
+					docid = newdid
+				}
+				query := tt.req.query
+				if tt.req.beforeLast {
+					query += "&before=" + strconv.FormatInt(lastid, 10)
+				}
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/v1/doc/"+docid+"/chat"+query, nil)
+				for hk, hv := range tt.req.header {
+					req.Header.Set(hk, hv)
+				}
+				r.ServeHTTP(w, req)
+				if !assert.Equal(t, tt.res.code, w.Code) {
+					t.FailNow()
+				}
+				if w.Code != 200 {
+					return
+				}
+
+				var res map[string]interface{}
+				err := json.Unmarshal(w.Body.Bytes(), &res)
+				if !assert.NoError(t, err, "fail to umarshal json:\n%v", err) {
+					t.FailNow()
+				}
+				attrs := []string{"messages", "has_next", "authors"}
+				for _, v := range attrs {
+					_, ok := res[v]
+					if !assert.True(t, ok, "should has %s, got:\n%v", v, res) {
+						t.FailNow()
+					}
+				}
+				if tt.res.messages == nil {
+					return
+				}
+				msgs, _ := res["messages"].([]interface{})
+				texts := []string{}
+				for _, v := range msgs {
+					msg, _ := v.(map[string]interface{})
+					text, _ := msg["message"].(string)
+					texts = append(texts, text)
+					id, _ := msg["id"].(float64)
+					lastid = int64(id)
+				}
+				assert.Equal(t, tt.res.messages, texts)
+				assert.Equal(t, tt.res.hasNext, res["has_next"])
+				authors, _ := res["authors"].(map[string]interface{})
+				assert.Contains(t, authors, "ujafzavrqkqthqe54")
+			})
+		}
+	})
+	t.Run("GetPresence", func(t *testing.T) {
+		if token == "" {
+			t.SkipNow()
+		}
+		if newdid == "" {
+			t.SkipNow()
+		}
+		type req struct {
+			header map[string]string
+			path   string
+		}
+		// No one is connected in the test
+		type res struct {
+			code  int
+			attrs []string
+			check func(t *testing.T, res map[string]interface{})
+		}
+		tests := []struct {
+			name string
+			req  req
+			res  res
+		}{
+			{
+				name: "Document",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/doc/" + newdid + "/presence",
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"doc_id", "viewers", "editors"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.Equal(t, newdid, res["doc_id"])
+						assert.Equal(t, []interface{}{}, res["viewers"])
+						assert.Equal(t, []interface{}{}, res["editors"])
+					},
+				},
+			},
+			{
+				name: "All",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/presence",
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"documents"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.Equal(t, []interface{}{}, res["documents"])
+					},
+				},
+			},
+			{
+				name: "Folder",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/presence?folder=fwk6al7nyj4qdufaz",
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"documents"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.Equal(t, []interface{}{}, res["documents"])
+					},
+				},
+			},
+			{
+				name: "InvalidFolder",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/presence?folder=d" + newdid,
+				},
+				res: res{
+					code: 400,
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", tt.req.path, nil)
+				for hk, hv := range tt.req.header {
+					req.Header.Set(hk, hv)
+				}
+				r.ServeHTTP(w, req)
+				if !assert.Equal(t, tt.res.code, w.Code) {
+					t.FailNow()
+				}
+				if w.Code != 200 {
+					return
+				}
+
+				var res map[string]interface{}
+				err := json.Unmarshal(w.Body.Bytes(), &res)
+				if !assert.NoError(t, err, "fail to umarshal json:\n%v", err) {
+					t.FailNow()
+				}
+				for _, v := range tt.res.attrs {
+					_, ok := res[v]
+					if !assert.True(t, ok, "should has %s, got:\n%v", v, res) {
+						t.FailNow()
+					}
+				}
+				if tt.res.check != nil {
+					tt.res.check(t, res)
+				}
+			})
+		}
+	})
+	t.Run("Template", func(t *testing.T) {
+		if token == "" {
+			t.SkipNow()
+		}
+		if newdid == "" {
+			t.SkipNow()
+		}
+		srcdid := testCreateDocument(t, r, token, `{"body":"# {{project}} notes {{unknown}}\n"}`)
+		// getContent returns the content of the created document
+		getContent := func(t *testing.T, did string) string {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/v1/doc/"+did+"/content", nil)
+			req.Header.Set("Authorization", `Bearer `+token)
+			r.ServeHTTP(w, req)
+			if !assert.Equal(t, 200, w.Code) {
+				t.FailNow()
+			}
+			return w.Body.String()
+		}
+		type req struct {
+			method string
+			header map[string]string
+			path   string
+			body   string
+		}
+		type res struct {
+			code  int
+			attrs []string
+			check func(t *testing.T, res map[string]interface{})
+		}
+		tests := []struct {
+			name string
+			req  req
+			res  res
+		}{
+			{
+				name: "Add",
+				req: req{
+					method: "POST",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/templates",
+					body:   `{"id":"` + newdid + `","name":"Test template"}`,
+				},
+				res: res{
+					code: 200,
+				},
+			},
+			{
+				name: "AddWithFields",
+				req: req{
+					method: "POST",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/templates",
+					body:   `{"id":"` + srcdid + `","name":"Fields template"}`,
+				},
+				res: res{
+					code: 200,
+				},
+			},
+			{
+				name: "AddDuplicate",
+				req: req{
+					method: "POST",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/templates",
+					body:   `{"id":"` + newdid + `"}`,
+				},
+				res: res{
+					code: 409,
+				},
+			},
+			{
+				name: "List",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/templates",
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"templates"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						names := map[string]interface{}{}
+						list, _ := res["templates"].([]interface{})
+						for _, v := range list {
+							tmpl, _ := v.(map[string]interface{})
+							id, _ := tmpl["id"].(string)
+							names[id] = tmpl["name"]
+							if id == newdid || id == srcdid {
+								assert.Equal(t, "document", tmpl["type"])
+								creator, _ := tmpl["creator"].(map[string]interface{})
+								assert.Equal(t, "ujafzavrqkqthqe54", creator["uuid"])
+							}
+						}
+						assert.Equal(t, "Test template", names[newdid])
+						assert.Equal(t, "Fields template", names[srcdid])
+					},
+				},
+			},
+			{
+				name: "Instantiate",
+				req: req{
+					method: "POST",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/templates/" + newdid + "/instantiate",
+					body:   `{"folder_id":"fwk6al7nyj4qdufaz","fields":{"project":"cakemix"}}`,
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"doc_id"},
+				},
+			},
+			{
+				name: "InstantiateFields",
+				req: req{
+					method: "POST",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/templates/" + srcdid + "/instantiate",
+					body:   `{"folder_id":"fwk6al7nyj4qdufaz","fields":{"project":"cakemix"}}`,
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"doc_id"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						did, _ := res["doc_id"].(string)
+						// Unknown placeholders are left as it is
+						assert.Equal(t, "# cakemix notes {{unknown}}\n", getContent(t, did))
+						// The template itself is not changed
+						assert.Equal(t, "# {{project}} notes {{unknown}}\n", getContent(t, srcdid))
+					},
+				},
+			},
+			{
+				name: "InstantiateInvalidFolder",
+				req: req{
+					method: "POST",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/templates/" + newdid + "/instantiate",
+					body:   `{"folder_id":"` + newdid + `"}`,
+				},
+				res: res{
+					code: 400,
+				},
+			},
+			{
+				name: "Delete",
+				req: req{
+					method: "DELETE",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/templates/" + newdid,
+				},
+				res: res{
+					code: 200,
+				},
+			},
+			{
+				name: "InstantiateDeleted",
+				req: req{
+					method: "POST",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/templates/" + newdid + "/instantiate",
+					body:   `{"folder_id":"fwk6al7nyj4qdufaz"}`,
+				},
+				res: res{
+					code: 404,
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest(tt.req.method, tt.req.path, bytes.NewBufferString(tt.req.body))
+				for hk, hv := range tt.req.header {
+					req.Header.Set(hk, hv)
+				}
+				r.ServeHTTP(w, req)
+				if !assert.Equal(t, tt.res.code, w.Code) {
+					t.FailNow()
+				}
+				if w.Code != 200 || len(tt.res.attrs) == 0 {
+					return
+				}
+
+				var res map[string]interface{}
+				err := json.Unmarshal(w.Body.Bytes(), &res)
+				if !assert.NoError(t, err, "fail to umarshal json:\n%v", err) {
+					t.FailNow()
+				}
+				for _, v := range tt.res.attrs {
+					_, ok := res[v]
+					if !assert.True(t, ok, "should has %s, got:\n%v", v, res) {
+						t.FailNow()
+					}
+				}
+				if tt.res.check != nil {
+					tt.res.check(t, res)
+				}
+			})
+		}
+	})
+	t.Run("Tag", func(t *testing.T) {
+		if token == "" {
+			t.SkipNow()
+		}
+		if newdid == "" {
+			t.SkipNow()
+		}
+		cw := httptest.NewRecorder()
+		creq, _ := http.NewRequest("POST", "/v1/tags", bytes.NewBufferString(`{"name":"testtag"}`))
+		creq.Header.Set("Authorization", `Bearer `+token)
+		r.ServeHTTP(cw, creq)
+		if !assert.Equal(t, 200, cw.Code) {
+			t.FailNow()
+		}
+		var tagres map[string]interface{}
+		err := json.Unmarshal(cw.Body.Bytes(), &tagres)
+		if !assert.NoError(t, err, "fail to umarshal json:\n%v", err) {
+			t.FailNow()
+		}
+		tagid, ok := tagres["tag_id"].(float64)
+		if !assert.True(t, ok, "should has tag_id, got:\n%v", tagres) {
+			t.FailNow()
+		}
+		tid := strconv.Itoa(int(tagid))
+		// docIDs returns the IDs of the documents in the list
+		docIDs := func(list interface{}) []string {
+			ids := []string{}
+			docs, _ := list.([]interface{})
+			for _, v := range docs {
+				doc, _ := v.(map[string]interface{})
+				id, _ := doc["uuid"].(string)
+				ids = append(ids, id)
+			}
+			return ids
+		}
+
+		type req struct {
+			method string
+			header map[string]string
+			path   string
+			body   string
+		}
+		type res struct {
+			code  int
+			attrs []string
+			check func(t *testing.T, res map[string]interface{})
+		}
+		tests := []struct {
+			name string
+			req  req
+			res  res
+		}{
+			{
+				name: "CreateDuplicate",
+				req: req{
+					method: "POST",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/tags",
+					body:   `{"name":"testtag"}`,
+				},
+				res: res{
+					code: 409,
+				},
+			},
+			{
+				name: "CreateInvalidName",
+				req: req{
+					method: "POST",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/tags",
+					body:   `{"name":"   "}`,
+				},
+				res: res{
+					code: 400,
+				},
+			},
+			{
+				name: "TagDocument",
+				req: req{
+					method: "PUT",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/doc/" + newdid + "/tags/" + tid,
+				},
+				res: res{
+					code: 200,
+				},
+			},
+			{
+				name: "DocumentTags",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/doc/" + newdid + "/tags",
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"tags"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						tags, _ := res["tags"].([]interface{})
+						if !assert.Len(t, tags, 1) {
+							t.FailNow()
+						}
+						tag, _ := tags[0].(map[string]interface{})
+						assert.Equal(t, tagid, tag["id"])
+						assert.Equal(t, "testtag", tag["name"])
+					},
+				},
+			},
+			{
+				name: "List",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/tags",
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"tags"},
+				},
+			},
+			{
+				name: "Get",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/tags/" + tid,
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"id", "name", "team", "count"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.Equal(t, "testtag", res["name"])
+						assert.Equal(t, float64(1), res["count"])
+					},
+				},
+			},
+			{
+				name: "FolderFilter",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/folder/fwk6al7nyj4qdufaz?type=document&tag=" + tid,
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"document"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.Equal(t, []string{newdid}, docIDs(res["document"]))
+					},
+				},
+			},
+			{
+				name: "SearchFilter",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/search/doc?tag=" + tid,
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"total", "documents"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.Equal(t, float64(1), res["total"])
+						assert.Equal(t, []string{newdid}, docIDs(res["documents"]))
+					},
+				},
+			},
+			{
+				name: "SearchFilterDuplicated",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/search/doc?tag=" + tid + "&tag=" + tid,
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"total", "documents"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.Equal(t, float64(1), res["total"])
+						assert.Equal(t, []string{newdid}, docIDs(res["documents"]))
+					},
+				},
+			},
+			{
+				name: "InvalidFilter",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/search/doc?tag=abc",
+				},
+				res: res{
+					code: 400,
+				},
+			},
+			{
+				name: "Rename",
+				req: req{
+					method: "PUT",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/tags/" + tid,
+					body:   `{"name":"testtag2"}`,
+				},
+				res: res{
+					code: 200,
+				},
+			},
+			{
+				name: "GetRenamed",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/tags/" + tid,
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"name"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.Equal(t, "testtag2", res["name"])
+					},
+				},
+			},
+			{
+				name: "UntagDocument",
+				req: req{
+					method: "DELETE",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/doc/" + newdid + "/tags/" + tid,
+				},
+				res: res{
+					code: 200,
+				},
+			},
+			{
+				name: "FolderFilterUntagged",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/folder/fwk6al7nyj4qdufaz?type=document&tag=" + tid,
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"document"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.Equal(t, []string{}, docIDs(res["document"]))
+					},
+				},
+			},
+			{
+				name: "SearchFilterUntagged",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/search/doc?tag=" + tid,
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"total", "documents"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.Equal(t, float64(0), res["total"])
+						assert.Equal(t, []string{}, docIDs(res["documents"]))
+					},
+				},
+			},
+			{
+				name: "Delete",
+				req: req{
+					method: "DELETE",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/tags/" + tid,
+				},
+				res: res{
+					code: 200,
+				},
+			},
+			{
+				name: "GetDeleted",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/tags/" + tid,
+				},
+				res: res{
+					code: 404,
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest(tt.req.method, tt.req.path, bytes.NewBufferString(tt.req.body))
+				for hk, hv := range tt.req.header {
+					req.Header.Set(hk, hv)
+				}
+				r.ServeHTTP(w, req)
+				if !assert.Equal(t, tt.res.code, w.Code) {
+					t.FailNow()
+				}
+				if w.Code != 200 || len(tt.res.attrs) == 0 {
+					return
+				}
+
+				var res map[string]interface{}
+				err := json.Unmarshal(w.Body.Bytes(), &res)
+				if !assert.NoError(t, err, "fail to umarshal json:\n%v", err) {
+					t.FailNow()
+				}
+				for _, v := range tt.res.attrs {
+					_, ok := res[v]
+					if !assert.True(t, ok, "should has %s, got:\n%v", v, res) {
+						t.FailNow()
+					}
+				}
+				if tt.res.check != nil {
+					tt.res.check(t, res)
+				}
+			})
+		}
+	})
+	t.Run("DocContent", func(t *testing.T) {
+		if token == "" {
+			t.SkipNow()
+		}
+		if newdid == "" {
+			t.SkipNow()
+		}
+		type req struct {
+			method string
+			header map[string]string
+			body   string
+		}
+		type res struct {
+			code int
+			etag string
+			body string
+		}
+		tests := []struct {
+			name string
+			req  req
+			res  res
+		}{
+			{
+				name: "Put",
+				req: req{
+					method: "PUT",
+					header: map[string]string{"Authorization": `Bearer ` + token, "If-Match": `"1"`},
+					body:   "# Test\n",
+				},
+				res: res{
+					code: 200,
+					etag: `"2"`,
+				},
+			},
+			{
+				name: "PutStale",
+				req: req{
+					method: "PUT",
+					header: map[string]string{"Authorization": `Bearer ` + token, "If-Match": `"1"`},
+					body:   "# Stale\n",
+				},
+				res: res{
+					code: 409,
+				},
+			},
+			{
+				name: "GetAfterStale",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+				},
+				res: res{
+					code: 200,
+					etag: `"2"`,
+					body: "# Test\n",
+				},
+			},
+			{
+				name: "Patch",
+				req: req{
+					method: "PATCH",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					body:   `{"operation":[7,"body"]}`,
+				},
+				res: res{
+					code: 200,
+					etag: `"3"`,
+				},
+			},
+			{
+				name: "PatchInvalid",
+				req: req{
+					method: "PATCH",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					body:   `{"operation":[100]}`,
+				},
+				res: res{
+					code: 400,
+				},
+			},
+			{
+				name: "Get",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+				},
+				res: res{
+					code: 200,
+					etag: `"3"`,
+					body: "# Test\nbody",
+				},
+			},
+			{
+				name: "GetNotModified",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token, "If-None-Match": `"3"`},
+				},
+				res: res{
+					code: 304,
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest(tt.req.method, "/v1/doc/"+newdid+"/content", bytes.NewBufferString(tt.req.body))
+				for hk, hv := range tt.req.header {
+					req.Header.Set(hk, hv)
+				}
+				r.ServeHTTP(w, req)
+				if !assert.Equal(t, tt.res.code, w.Code) {
+					t.FailNow()
+				}
+				if w.Code != 200 {
+					return
+				}
+				if !assert.Equal(t, tt.res.etag, w.Header().Get("ETag")) {
+					t.FailNow()
+				}
+				if tt.res.body != "" && !assert.Equal(t, tt.res.body, w.Body.String()) {
+					t.FailNow()
+				}
+			})
+		}
+	})
+	t.Run("DocProperties", func(t *testing.T) {
+		if token == "" {
+			t.SkipNow()
+		}
+		if newdid == "" {
+			t.SkipNow()
+		}
+		type req struct {
+			method string
+			header map[string]string
+			path   string
+			body   string
+		}
+		// hasDoc checks newdid is in the documents of the search result
+		hasDoc := func(res map[string]interface{}) bool {
+			docs, _ := res["documents"].([]interface{})
+			for _, v := range docs {
+				doc, _ := v.(map[string]interface{})
+				if doc["uuid"] == newdid {
+					return true
+				}
+			}
+			return false
+		}
+		type res struct {
+			code  int
+			attrs []string
+			check func(t *testing.T, res map[string]interface{})
+		}
+		tests := []struct {
+			name string
+			req  req
+			res  res
+		}{
+			{
+				name: "PutFrontMatter",
+				req: req{
+					method: "PUT",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/doc/" + newdid + "/content",
+					body:   "---\ntitle: Front matter title\nstatus: draft\npriority: 1\n---\n# Heading\n",
+				},
+				res: res{
+					code: 200,
+				},
+			},
+			{
+				name: "GetDocInfo",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/doc/" + newdid,
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"title", "properties"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.Equal(t, "Front matter title", res["title"])
+						props, _ := res["properties"].(map[string]interface{})
+						assert.Equal(t, "draft", props["status"])
+						assert.Equal(t, float64(1), props["priority"])
+					},
+				},
+			},
+			{
+				name: "Query",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/doc?prop.status=draft&prop.priority=1",
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"total", "documents"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.True(t, hasDoc(res), "should has %s, got:\n%v", newdid, res)
+					},
+				},
+			},
+			{
+				name: "QueryNotMatch",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/doc?prop.status=done",
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"total", "documents"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.False(t, hasDoc(res), "should not has %s, got:\n%v", newdid, res)
+					},
+				},
+			},
+			{
+				name: "InvalidKey",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/doc?prop.=draft",
+				},
+				res: res{
+					code: 400,
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest(tt.req.method, tt.req.path, bytes.NewBufferString(tt.req.body))
+				for hk, hv := range tt.req.header {
+					req.Header.Set(hk, hv)
+				}
+				r.ServeHTTP(w, req)
+				if !assert.Equal(t, tt.res.code, w.Code) {
+					t.FailNow()
+				}
+				if w.Code != 200 || len(tt.res.attrs) == 0 {
+					return
+				}
+
+				var res map[string]interface{}
+				err := json.Unmarshal(w.Body.Bytes(), &res)
+				if !assert.NoError(t, err, "fail to umarshal json:\n%v", err) {
+					t.FailNow()
+				}
+				for _, v := range tt.res.attrs {
+					_, ok := res[v]
+					if !assert.True(t, ok, "should has %s, got:\n%v", v, res) {
+						t.FailNow()
+					}
+				}
+				if tt.res.check != nil {
+					tt.res.check(t, res)
+				}
+			})
+		}
+	})
+	t.Run("DocOutline", func(t *testing.T) {
+		if token == "" {
+			t.SkipNow()
+		}
+		if newdid == "" {
+			t.SkipNow()
+		}
+		type req struct {
+			header map[string]string
+			query  string
+		}
+		// The content is "---\n...\n---\n# Heading\n" by DocProperties
+		checkHeading := func(t *testing.T, v interface{}) {
+			heading, _ := v.(map[string]interface{})
+			assert.Equal(t, float64(1), heading["level"])
+			assert.Equal(t, "Heading", heading["text"])
+			assert.Equal(t, "heading", heading["anchor"])
+		}
+		type res struct {
+			code  int
+			attrs []string
+			check func(t *testing.T, res map[string]interface{})
+		}
+		tests := []struct {
+			name string
+			req  req
+			res  res
+		}{
+			{
+				name: "Outline",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"revision", "headings"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						headings, _ := res["headings"].([]interface{})
+						if !assert.Len(t, headings, 1) {
+							t.FailNow()
+						}
+						checkHeading(t, headings[0])
+					},
+				},
+			},
+			{
+				name: "Anchor",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					query:  "?anchor=heading",
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"revision", "anchor_revision", "heading"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						checkHeading(t, res["heading"])
+					},
+				},
+			},
+			{
+				name: "AnchorNotFound",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					query:  "?anchor=not-found-section",
+				},
+				res: res{
+					code: 404,
+				},
+			},
+			{
+				name: "InvalidRevision",
+				req: req{
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					query:  "?revision=abc",
+				},
+				res: res{
+					code: 400,
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/v1/doc/"+newdid+"/outline"+tt.req.query, nil)
+				for hk, hv := range tt.req.header {
+					req.Header.Set(hk, hv)
+				}
+				r.ServeHTTP(w, req)
+				if !assert.Equal(t, tt.res.code, w.Code) {
+					t.FailNow()
+				}
+				if w.Code != 200 {
+					return
+				}
+
+				var res map[string]interface{}
+				err := json.Unmarshal(w.Body.Bytes(), &res)
+				if !assert.NoError(t, err, "fail to umarshal json:\n%v", err) {
+					t.FailNow()
+				}
+				for _, v := range tt.res.attrs {
+					_, ok := res[v]
+					if !assert.True(t, ok, "should has %s, got:\n%v", v, res) {
+						t.FailNow()
+					}
+				}
+				if tt.res.check != nil {
+					tt.res.check(t, res)
+				}
+			})
+		}
+	})
+	t.Run("DocLinks", func(t *testing.T) {
+		if token == "" {
+			t.SkipNow()
+		}
+		if newdid == "" {
+			t.SkipNow()
+		}
+		cw := httptest.NewRecorder()
+		creq, _ := http.NewRequest("POST", "/v1/doc/fwk6al7nyj4qdufaz", bytes.NewBufferString(`{"body":"# Links\n[doc](/doc/`+newdid+`) [[Front matter title]] [[Missing page]]\n"}`))
+		creq.Header.Set("Authorization", `Bearer `+token)
+		r.ServeHTTP(cw, creq)
+		if !assert.Equal(t, 200, cw.Code) {
+			t.FailNow()
+		}
+		var docres map[string]interface{}
+		err := json.Unmarshal(cw.Body.Bytes(), &docres)
+		if !assert.NoError(t, err, "fail to umarshal json:\n%v", err) {
+			t.FailNow()
+		}
+		linkdid, ok := docres["doc_id"].(string)
+		if !assert.True(t, ok, "should has doc_id, got:\n%v", docres) {
+			t.FailNow()
+		}
+
+		type req struct {
+			method string
+			header map[string]string
+			path   string
+		}
+		// links returns the links in the response
+		links := func(res map[string]interface{}) []map[string]interface{} {
+			ret := []map[string]interface{}{}
+			list, _ := res["links"].([]interface{})
+			for _, v := range list {
+				link, _ := v.(map[string]interface{})
+				ret = append(ret, link)
+			}
+			return ret
+		}
+		type res struct {
+			code  int
+			attrs []string
+			check func(t *testing.T, res map[string]interface{})
+		}
+		tests := []struct {
+			name string
+			req  req
+			res  res
+		}{
+			{
+				name: "Links",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/doc/" + linkdid + "/links",
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"links"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						list := links(res)
+						if !assert.Len(t, list, 3) {
+							t.FailNow()
+						}
+						// The markdown link and the wiki link by title are resolved
+						for _, v := range list {
+							if v["text"] == "Missing page" {
+								assert.Equal(t, true, v["broken"])
+								continue
+							}
+							assert.Equal(t, false, v["broken"])
+							assert.Equal(t, newdid, v["doc_id"])
+						}
+					},
+				},
+			},
+			{
+				name: "Backlinks",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/doc/" + newdid + "/backlinks",
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"links"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						found := false
+						for _, v := range links(res) {
+							found = found || v["doc_id"] == linkdid
+						}
+						assert.True(t, found, "should has %s, got:\n%v", linkdid, res)
+					},
+				},
+			},
+			{
+				name: "InvalidID",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/doc/f" + linkdid + "/links",
+				},
+				res: res{
+					code: 400,
+				},
+			},
+			{
+				name: "DeleteSource",
+				req: req{
+					method: "DELETE",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/doc/" + linkdid,
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"broken_links"},
+				},
+			},
+			{
+				name: "BacklinksAfterDelete",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/doc/" + newdid + "/backlinks",
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"links"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						for _, v := range links(res) {
+							assert.NotEqual(t, linkdid, v["doc_id"])
+						}
+					},
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest(tt.req.method, tt.req.path, nil)
+				for hk, hv := range tt.req.header {
+					req.Header.Set(hk, hv)
+				}
+				r.ServeHTTP(w, req)
+				if !assert.Equal(t, tt.res.code, w.Code) {
+					t.FailNow()
+				}
+				if w.Code != 200 {
+					return
+				}
+
+				var res map[string]interface{}
+				err := json.Unmarshal(w.Body.Bytes(), &res)
+				if !assert.NoError(t, err, "fail to umarshal json:\n%v", err) {
+					t.FailNow()
+				}
+				for _, v := range tt.res.attrs {
+					_, ok := res[v]
+					if !assert.True(t, ok, "should has %s, got:\n%v", v, res) {
+						t.FailNow()
+					}
+				}
+				if tt.res.check != nil {
+					tt.res.check(t, res)
+				}
+			})
+		}
+	})
+	t.Run("Tasks", func(t *testing.T) {
+		if token == "" {
+			t.SkipNow()
+		}
+		if newdid == "" {
+			t.SkipNow()
+		}
+		type req struct {
+			method string
+			header map[string]string
+			path   string
+			body   string
+		}
+		// taskLines returns the lines of the tasks in the response
+		taskLines := func(res map[string]interface{}) []float64 {
+			lines := []float64{}
+			tasks, _ := res["tasks"].([]interface{})
+			for _, v := range tasks {
+				task, _ := v.(map[string]interface{})
+				line, _ := task["line"].(float64)
+				lines = append(lines, line)
+			}
+			return lines
+		}
+		type res struct {
+			code  int
+			attrs []string
+			check func(t *testing.T, res map[string]interface{})
+		}
+		tests := []struct {
+			name string
+			req  req
+			res  res
+		}{
+			{
+				name: "PutTasks",
+				req: req{
+					method: "PUT",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/doc/" + newdid + "/content",
+					body:   "# Tasks\n- [ ] Write docs @root due:2030-01-01\n- [x] Done task\n",
+				},
+				res: res{
+					code: 200,
+				},
+			},
+			{
+				name: "GetMyOpenTasks",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/tasks?assignee=me&state=open",
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"total", "tasks"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.Equal(t, float64(1), res["total"])
+						tasks, _ := res["tasks"].([]interface{})
+						if !assert.Len(t, tasks, 1) {
+							t.FailNow()
+						}
+						task, _ := tasks[0].(map[string]interface{})
+						assert.Equal(t, newdid, task["doc_id"])
+						assert.Equal(t, float64(2), task["line"])
+						assert.Equal(t, false, task["done"])
+						assert.Equal(t, "2030-01-01", task["due"])
+					},
+				},
+			},
+			{
+				name: "CheckTask",
+				req: req{
+					method: "PUT",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/tasks/" + newdid + "/2",
+					body:   `{"done":true,"text":"Write docs @root due:2030-01-01"}`,
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"revision"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						// Re-read the content to check the checkbox is flipped
+						w := httptest.NewRecorder()
+						req, _ := http.NewRequest("GET", "/v1/doc/"+newdid+"/content", nil)
+						req.Header.Set("Authorization", `Bearer `+token)
+						r.ServeHTTP(w, req)
+						if !assert.Equal(t, 200, w.Code) {
+							t.FailNow()
+						}
+						assert.Equal(t, "# Tasks\n- [x] Write docs @root due:2030-01-01\n- [x] Done task\n", w.Body.String())
+					},
+				},
+			},
+			{
+				name: "GetMyOpenTasksAfterCheck",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/tasks?assignee=me&state=open",
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"total", "tasks"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.Equal(t, float64(0), res["total"])
+					},
+				},
+			},
+			{
+				name: "GetDoneTasks",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/tasks?state=done&doc=" + newdid,
+				},
+				res: res{
+					code:  200,
+					attrs: []string{"total", "tasks"},
+					check: func(t *testing.T, res map[string]interface{}) {
+						assert.ElementsMatch(t, []float64{2, 3}, taskLines(res))
+					},
+				},
+			},
+			{
+				name: "CheckMissingTask",
+				req: req{
+					method: "PUT",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/tasks/" + newdid + "/1",
+					body:   `{"done":true,"text":"Missing task"}`,
+				},
+				res: res{
+					code: 409,
+				},
+			},
+			{
+				name: "InvalidState",
+				req: req{
+					method: "GET",
+					header: map[string]string{"Authorization": `Bearer ` + token},
+					path:   "/v1/tasks?state=pending",
+				},
+				res: res{
+					code: 400,
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest(tt.req.method, tt.req.path, bytes.NewBufferString(tt.req.body))
+				for hk, hv := range tt.req.header {
+					req.Header.Set(hk, hv)
+				}
+				r.ServeHTTP(w, req)
+				if !assert.Equal(t, tt.res.code, w.Code) {
+					t.FailNow()
+				}
+				if w.Code != 200 || len(tt.res.attrs) == 0 {
+					return
+				}
+
+				var res map[string]interface{}
+				err := json.Unmarshal(w.Body.Bytes(), &res)
+				if !assert.NoError(t, err, "fail to umarshal json:\n%v", err) {
+					t.FailNow()
+				}
+				for _, v := range tt.res.attrs {
+					_, ok := res[v]
+					if !assert.True(t, ok, "should has %s, got:\n%v", v, res) {
+						t.FailNow()
+					}
+				}
+				if tt.res.check != nil {
+					tt.res.check(t, res)
+				}
+			})
+		}
+	})
 	t.Run("UpdateDocInfo", func(t *testing.T) {
 		if token == "" {
 			t.SkipNow()
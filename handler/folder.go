@@ -2,31 +2,40 @@ package handler
 
 import (
 	"net/http"
-	"strings"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wonder-wonder/cakemix-server/db"
 	"github.com/wonder-wonder/cakemix-server/model"
 )
 
+// FolderCopyItemMax is the max number of folders and documents copied at once
+const FolderCopyItemMax = 1000
+
 // FolderHandler is handlers of folders
 func (h *Handler) FolderHandler(r *gin.RouterGroup) {
 	folderck := r.Group("folder", h.CheckAuthMiddleware())
-	folderck.GET("*folderid", h.getFolderHandler)
+	folderck.GET("/", h.getFolderHandler)
+	folderck.GET(":folderid", h.getFolderHandler)
+	folderck.GET(":folderid/tree", h.getFolderTreeHandler)
 	folderck.POST(":folderid", h.createFolderHandler)
 	folderck.DELETE(":folderid", h.deleteFolderHandler)
 	folderck.PUT(":folderid/move/:targetfid", h.moveFolderHandler)
+	folderck.POST(":folderid/copy/:targetfid", h.copyFolderHandler)
 	folderck.PUT(":folderid", h.modifyFolderHandler)
 }
 func (h *Handler) getFolderHandler(c *gin.Context) {
 	fid := c.Param("folderid")
 	listtype := c.Query("type")
+	tagids, ok := getTagIDsQuery(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
 
 	follist := []model.Folder{}
 	doclist := []model.Document{}
 
-	fid = strings.TrimLeft(fid, "/")
-
 	if fid == "" {
 		var err error
 		fid, err = h.db.GetRootFID()
@@ -40,7 +49,6 @@ func (h *Handler) getFolderHandler(c *gin.Context) {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
-
 	finfo, err := h.db.GetFolderInfo(fid)
 	if err != nil {
 		if err == db.ErrFolderNotFound {
@@ -112,7 +120,7 @@ func (h *Handler) getFolderHandler(c *gin.Context) {
 		}
 	}
 	if listtype == "" || listtype == "document" {
-		docidlist, err := h.db.GetDocList(fid)
+		docidlist, err := h.db.GetDocListByTags(fid, tagids)
 		if err != nil {
 			c.AbortWithError(http.StatusInternalServerError, err)
 			return
@@ -140,6 +148,17 @@ func (h *Handler) getFolderHandler(c *gin.Context) {
 				return
 			}
 
+			tags, err := h.getDocumentTags(c, v)
+			if err != nil {
+				c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+			props, err := h.getDocumentProperties(v)
+			if err != nil {
+				c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+
 			doclist = append(doclist, model.Document{
 				UUID: v,
 				Owner: model.Profile{
@@ -162,6 +181,8 @@ func (h *Handler) getFolderHandler(c *gin.Context) {
 				UpdatedAt:      docinfo.UpdatedAt,
 				Editable:       editable,
 				ParentFolderID: fid,
+				Tags:           tags,
+				Properties:     props,
 			})
 		}
 	}
@@ -181,6 +202,89 @@ func (h *Handler) getFolderHandler(c *gin.Context) {
 	c.AbortWithStatusJSON(http.StatusOK, ret)
 }
 
+// getFolderTreeHandler returns the subtree of the folder. The items which the user can't read are omitted with their descendants.
+func (h *Handler) getFolderTreeHandler(c *gin.Context) {
+	fid := c.Param("folderid")
+	depth := 0
+	if c.Query("depth") != "" {
+		var err error
+		depth, err = strconv.Atoi(c.Query("depth"))
+		if err != nil || depth < 0 {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+	if fid == "" || fid[0] != 'f' {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	finfo, err := h.db.GetFolderInfo(fid)
+	if err != nil {
+		if err == db.ErrFolderNotFound {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !isRelatedUUID(c, finfo.OwnerUUID) && finfo.Permission == db.FilePermPrivate {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	items, err := h.db.GetFolderDescendants(fid, depth)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	root := &model.FolderTree{
+		FolderID:   fid,
+		Name:       finfo.Name,
+		Permission: int(finfo.Permission),
+		UpdatedAt:  finfo.UpdatedAt,
+		Editable:   isRelatedUUID(c, finfo.OwnerUUID) || finfo.Permission == db.FilePermReadWrite,
+		Folders:    []*model.FolderTree{},
+		Documents:  []model.FolderTreeDocument{},
+	}
+	// Same as CopyFolder, only the items owned by readers or not private are included
+	nodes := map[string]*model.FolderTree{fid: root}
+	for _, v := range items {
+		parent, ok := nodes[v.ParentFolderUUID]
+		if !ok {
+			continue
+		}
+		if !isRelatedUUID(c, v.OwnerUUID) && v.Permission == db.FilePermPrivate {
+			continue
+		}
+		editable := isRelatedUUID(c, v.OwnerUUID) || v.Permission == db.FilePermReadWrite
+		if v.UUID[0] == 'f' {
+			node := &model.FolderTree{
+				FolderID:   v.UUID,
+				Name:       v.Name,
+				Permission: int(v.Permission),
+				UpdatedAt:  v.UpdatedAt,
+				Editable:   editable,
+				Folders:    []*model.FolderTree{},
+				Documents:  []model.FolderTreeDocument{},
+			}
+			parent.Folders = append(parent.Folders, node)
+			nodes[v.UUID] = node
+		} else {
+			parent.Documents = append(parent.Documents, model.FolderTreeDocument{
+				DocumentID: v.UUID,
+				Title:      v.Name,
+				Permission: int(v.Permission),
+				UpdatedAt:  v.UpdatedAt,
+				Editable:   editable,
+			})
+		}
+	}
+
+	c.AbortWithStatusJSON(http.StatusOK, root)
+}
+
 func (h *Handler) createFolderHandler(c *gin.Context) {
 	parentfid := c.Param("folderid")
 	fname := c.Query("name")
@@ -365,6 +469,10 @@ func (h *Handler) moveFolderHandler(c *gin.Context) {
 
 	err = h.db.MoveFolder(fid, targetfid)
 	if err != nil {
+		if err == db.ErrFolderCycle {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
@@ -393,6 +501,79 @@ func (h *Handler) moveFolderHandler(c *gin.Context) {
 	c.AbortWithStatus(http.StatusOK)
 }
 
+func (h *Handler) copyFolderHandler(c *gin.Context) {
+	fid := c.Param("folderid")
+	targetfid := c.Param("targetfid")
+
+	uuid, ok := getUUID(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	if fid == "" || fid[0] != 'f' {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if targetfid == "" || targetfid[0] != 'f' {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	finfo, err := h.db.GetFolderInfo(fid)
+	if err != nil {
+		if err == db.ErrFolderNotFound {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !isRelatedUUID(c, finfo.OwnerUUID) && finfo.Permission == db.FilePermPrivate {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	tfinfo, err := h.db.GetFolderInfo(targetfid)
+	if err != nil {
+		if err == db.ErrFolderNotFound {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !isRelatedUUID(c, tfinfo.OwnerUUID) && tfinfo.Permission != db.FilePermReadWrite {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	owneruuid := uuid
+	if isRelatedUUID(c, tfinfo.OwnerUUID) {
+		owneruuid = tfinfo.OwnerUUID
+	}
+
+	teams, _ := getTeams(c)
+	readers := append([]string{uuid}, teams...)
+	idmap, err := h.db.CopyFolder(fid, targetfid, readers, owneruuid, uuid, FolderCopyItemMax)
+	if err != nil {
+		if err == db.ErrFolderTooLarge {
+			c.AbortWithError(http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	err = h.db.UpdateFolder(targetfid, uuid)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.AbortWithStatusJSON(http.StatusOK, model.CopyFolderRes{FolderID: idmap[fid], IDMap: idmap})
+}
+
 func (h *Handler) modifyFolderHandler(c *gin.Context) {
 	fid := c.Param("folderid")
 
@@ -452,7 +633,9 @@ func (h *Handler) modifyFolderHandler(c *gin.Context) {
 
 func (h *Handler) getPath(c *gin.Context, fid string) ([]model.Breadcrumb, error) {
 	res := []model.Breadcrumb{}
-	for {
+	visited := map[string]bool{}
+	for !visited[fid] {
+		visited[fid] = true
 		finfo, err := h.db.GetFolderInfo(fid)
 		if err != nil {
 			return res, err
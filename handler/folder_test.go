@@ -179,6 +179,7 @@ func TestFolderHandler(t *testing.T) {
 		}
 		type req struct {
 			header    map[string]string
+			folderid  string
 			targetfid string
 		}
 		type res struct {
@@ -193,17 +194,29 @@ func TestFolderHandler(t *testing.T) {
 				name: "Root",
 				req: req{
 					header:    map[string]string{"Authorization": `Bearer ` + token},
+					folderid:  newfid,
 					targetfid: "fhfprvdljyczssis7",
 				},
 				res: res{
 					code: 200,
 				},
 			},
+			{
+				name: "IntoDescendant",
+				req: req{
+					header:    map[string]string{"Authorization": `Bearer ` + token},
+					folderid:  "fdahpbkboamdbgnua",
+					targetfid: "fhfprvdljyczssis7",
+				},
+				res: res{
+					code: 400,
+				},
+			},
 		}
 		for _, tt := range tests {
 			t.Run(tt.name, func(t *testing.T) {
 				w := httptest.NewRecorder()
-				req, _ := http.NewRequest("PUT", "/v1/folder/"+newfid+"/move/"+tt.req.targetfid, nil)
+				req, _ := http.NewRequest("PUT", "/v1/folder/"+tt.req.folderid+"/move/"+tt.req.targetfid, nil)
 				for hk, hv := range tt.req.header {
 					req.Header.Set(hk, hv)
 				}
@@ -214,6 +227,75 @@ func TestFolderHandler(t *testing.T) {
 			})
 		}
 	})
+	t.Run("GetFolderTree", func(t *testing.T) {
+		if token == "" {
+			t.SkipNow()
+		}
+		type req struct {
+			header   map[string]string
+			folderid string
+			depth    string
+		}
+		type res struct {
+			code int
+		}
+		tests := []struct {
+			name string
+			req  req
+			res  res
+		}{
+			{
+				name: "Root",
+				req: req{
+					header:   map[string]string{"Authorization": `Bearer ` + token},
+					folderid: "fwk6al7nyj4qdufaz",
+					depth:    "2",
+				},
+				res: res{
+					code: 200,
+				},
+			},
+			{
+				name: "InvalidDepth",
+				req: req{
+					header:   map[string]string{"Authorization": `Bearer ` + token},
+					folderid: "fwk6al7nyj4qdufaz",
+					depth:    "-1",
+				},
+				res: res{
+					code: 400,
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/v1/folder/"+tt.req.folderid+"/tree?depth="+tt.req.depth, nil)
+				for hk, hv := range tt.req.header {
+					req.Header.Set(hk, hv)
+				}
+				r.ServeHTTP(w, req)
+				if !assert.Equal(t, tt.res.code, w.Code) {
+					t.FailNow()
+				}
+				if w.Code != 200 {
+					return
+				}
+
+				var res map[string]interface{}
+				err := json.Unmarshal(w.Body.Bytes(), &res)
+				if !assert.NoError(t, err, "fail to umarshal json:\n%v", err) {
+					t.FailNow()
+				}
+				for _, v := range []string{"folder_id", "folders", "documents"} {
+					_, ok := res[v]
+					if !assert.True(t, ok, "should has %s, got:\n%v", v, res) {
+						t.FailNow()
+					}
+				}
+			})
+		}
+	})
 	t.Run("UpdateFolderInfo", func(t *testing.T) {
 		if token == "" {
 			t.SkipNow()
@@ -258,6 +340,77 @@ func TestFolderHandler(t *testing.T) {
 			})
 		}
 	})
+	t.Run("CopyFolder", func(t *testing.T) {
+		if token == "" {
+			t.SkipNow()
+		}
+		if newfid == "" {
+			t.SkipNow()
+		}
+		type req struct {
+			header    map[string]string
+			folderid  string
+			targetfid string
+		}
+		type res struct {
+			code int
+		}
+		tests := []struct {
+			name string
+			req  req
+			res  res
+		}{
+			{
+				name: "TestFolder",
+				req: req{
+					header:    map[string]string{"Authorization": `Bearer ` + token},
+					folderid:  newfid,
+					targetfid: "fhfprvdljyczssis7",
+				},
+				res: res{
+					code: 200,
+				},
+			},
+			{
+				name: "NotFound",
+				req: req{
+					header:    map[string]string{"Authorization": `Bearer ` + token},
+					folderid:  "faaaaaaaaaaaaaaaa",
+					targetfid: "fhfprvdljyczssis7",
+				},
+				res: res{
+					code: 404,
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest("POST", "/v1/folder/"+tt.req.folderid+"/copy/"+tt.req.targetfid, nil)
+				for hk, hv := range tt.req.header {
+					req.Header.Set(hk, hv)
+				}
+				r.ServeHTTP(w, req)
+				if !assert.Equal(t, tt.res.code, w.Code) {
+					t.FailNow()
+				}
+				if w.Code != 200 {
+					return
+				}
+
+				var res map[string]interface{}
+				err := json.Unmarshal(w.Body.Bytes(), &res)
+				if !assert.NoError(t, err, "fail to umarshal json:\n%v", err) {
+					t.FailNow()
+				}
+				idmap, ok := res["id_map"].(map[string]interface{})
+				if !assert.True(t, ok, "should has id_map, got:\n%v", res) {
+					t.FailNow()
+				}
+				assert.Equal(t, res["folder_id"], idmap[tt.req.folderid])
+			})
+		}
+	})
 	t.Run("RemoveFolder", func(t *testing.T) {
 		if token == "" {
 			t.SkipNow()
@@ -2,13 +2,17 @@ package handler
 
 import (
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wonder-wonder/cakemix-server/db"
 	"github.com/wonder-wonder/cakemix-server/ot"
+	"github.com/wonder-wonder/cakemix-server/util"
 )
 
 const (
@@ -16,58 +20,84 @@ const (
 	ImageDir = "/img"
 )
 
-var (
-	dataDir                = ""
-	mailTmplResetPW        = ""
-	mailTmplRegist         = ""
-	corsFrontHost          = "*" // CORS front host
-	permitUserToCreateTeam = false
-)
-
 // Handler is object for handler function
 type Handler struct {
 	db    *db.DB
 	otmgr *ot.Manager
+	state atomic.Value // *handlerState
+
+	corsWarnOnce sync.Once
 }
 
+// handlerState holds the settings which can be swapped while running
+type handlerState struct {
+	conf   HandlerConf
+	mailer *util.Mailer
+}
+
+// HandlerConf is structure for handler configuration
 type HandlerConf struct {
 	DataDir                string
-	MailTemplateResetPW    string
-	MailTemplateRegist     string
-	CORSHost               string
+	CORSOrigins            []string // Allowed origins for CORS and websocket
 	PermitUserToCreateTeam bool
+	Mail                   util.MailConf
+	OT                     ot.Config
 }
 
 // NewHandler generates new Handler instance
 func NewHandler(db *db.DB, conf HandlerConf) *Handler {
-	dataDir = conf.DataDir
 	// Init data dir
-	err := os.MkdirAll(dataDir, 0700)
+	err := os.MkdirAll(conf.DataDir, 0700)
 	if err != nil {
-		panic("Directory init error:" + dataDir)
+		panic("Directory init error:" + conf.DataDir)
 	}
-	err = os.MkdirAll(path.Join(dataDir, ImageDir), 0700)
+	err = os.MkdirAll(path.Join(conf.DataDir, ImageDir), 0700)
 	if err != nil {
-		panic("Directory init error:" + path.Join(dataDir, ImageDir))
+		panic("Directory init error:" + path.Join(conf.DataDir, ImageDir))
 	}
-	if conf.MailTemplateResetPW == "" {
+	if conf.Mail.TmplResetPW == "" {
 		panic("Mail template is not specified")
 	}
-	mailTmplResetPW = conf.MailTemplateResetPW
-	if conf.MailTemplateRegist == "" {
+	if conf.Mail.TmplRegist == "" {
 		panic("Mail template is not specified")
 	}
-	mailTmplRegist = conf.MailTemplateRegist
-	if conf.CORSHost != "" {
-		corsFrontHost = conf.CORSHost
-	}
-	permitUserToCreateTeam = conf.PermitUserToCreateTeam
-	otmgr, err := ot.NewManager(db)
+	otmgr, err := ot.NewManager(db, conf.OT)
 	if err != nil {
 		panic(err)
 	}
 	go otmgr.Loop()
-	return &Handler{db: db, otmgr: otmgr}
+	h := &Handler{db: db, otmgr: otmgr}
+	h.setConf(conf)
+	return h
+}
+
+// UpdateConf replaces the reloadable settings (CORS, team creation policy and mail) atomically.
+// Other settings are not changed.
+func (h *Handler) UpdateConf(conf HandlerConf) {
+	cur := h.getConf()
+	cur.CORSOrigins = conf.CORSOrigins
+	cur.PermitUserToCreateTeam = conf.PermitUserToCreateTeam
+	cur.Mail = conf.Mail
+	h.setConf(cur)
+}
+
+func (h *Handler) setConf(conf HandlerConf) {
+	// Keep the old default for compatibility
+	if len(conf.CORSOrigins) == 0 {
+		h.corsWarnOnce.Do(func() {
+			util.Warnf("APICORS is empty, so any origin is allowed for CORS. This default is deprecated and will be same origin only in the future. Set APICORS explicitly.")
+		})
+		conf.CORSOrigins = []string{"*"}
+	}
+	h.state.Store(&handlerState{conf: conf, mailer: util.NewMailer(conf.Mail)})
+}
+
+func (h *Handler) getConf() HandlerConf {
+	return h.state.Load().(*handlerState).conf
+}
+
+func (h *Handler) getMailer() *util.Mailer {
+	return h.state.Load().(*handlerState).mailer
 }
 
 // StopOTManager send stop request to OT manager
@@ -159,22 +189,116 @@ func isRelatedUUID(c *gin.Context, uuid string) bool {
 	return false
 }
 
-// CORS supports cross origin resource sharing.
-func CORS() gin.HandlerFunc {
+// checkScopeManager checks the user can manage the templates or tags of the scope (admin for instance, team owner/admin for team)
+func (h *Handler) checkScopeManager(uuid string, teamuuid string) (bool, error) {
+	if teamuuid == "" {
+		return h.db.IsAdmin(uuid)
+	}
+	perm, err := h.db.GetTeamMemberPerm(teamuuid, uuid)
+	if err == db.ErrUserNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return perm == db.TeamPermOwner || perm == db.TeamPermAdmin, nil
+}
+
+// CORS supports cross origin resource sharing. Only the allowed origins are reflected.
+func (h *Handler) CORS() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		origin := c.Request.Header.Get("Origin")
+		c.Writer.Header().Add("Vary", "Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		allowed := h.getConf().CORSOrigins
+		if !isAllowedOrigin(origin, allowed) {
+			// For preflight
+			if c.Request.Method == "OPTIONS" {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if len(allowed) == 1 && allowed[0] == "*" {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+			c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, PUT, PATCH, POST, DELETE, HEAD, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag")
+
 		// For preflight
 		if c.Request.Method == "OPTIONS" {
-			c.Writer.Header().Set("Access-Control-Allow-Origin", corsFrontHost)
-			c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, PUT, POST, DELETE, HEAD, OPTIONS")
 			c.Writer.Header().Set("Access-Control-Allow-Headers", c.Request.Header.Get("Access-Control-Request-Headers"))
-
 			c.AbortWithStatus(http.StatusOK)
+			return
 		}
 
-		c.Writer.Header().Set("Access-Control-Allow-Origin", corsFrontHost)
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, PUT, POST, DELETE, HEAD, OPTIONS")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "X-Requested-With, Origin, X-Csrftoken, Content-Type, Accept")
 		c.Next()
 	}
 }
+
+// checkWSOrigin checks the origin of websocket request.
+// The request is allowed if it's not from browser, same origin or allowed origin.
+func (h *Handler) checkWSOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	// "*" is only for CORS. Websocket requires the origin to be listed explicitly.
+	patterns := []string{}
+	for _, p := range h.getConf().CORSOrigins {
+		if p != "*" {
+			patterns = append(patterns, p)
+		}
+	}
+	return isAllowedOrigin(origin, patterns)
+}
+
+// isAllowedOrigin checks the origin matches one of the patterns.
+// The pattern is "*" (any), "https://example.com" (exact), "example.com" (any scheme),
+// or "https://*.example.com" and "*.example.com" (subdomains).
+func isAllowedOrigin(origin string, patterns []string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+	host := strings.ToLower(u.Host)
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimRight(p, "/"))
+		if p == "*" {
+			return true
+		}
+		scheme := ""
+		if i := strings.Index(p, "://"); i >= 0 {
+			scheme = p[:i]
+			p = p[i+3:]
+		}
+		if scheme != "" && scheme != strings.ToLower(u.Scheme) {
+			continue
+		}
+		if strings.HasPrefix(p, "*.") {
+			if strings.HasSuffix(host, p[1:]) && len(host) > len(p)-1 {
+				return true
+			}
+			continue
+		}
+		if host == p {
+			return true
+		}
+	}
+	return false
+}
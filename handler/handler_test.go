@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsAllowedOrigin(t *testing.T) {
+	tests := []struct {
+		name     string
+		origin   string
+		patterns []string
+		res      bool
+	}{
+		{name: "Empty", origin: "https://example.com", patterns: []string{}, res: false},
+		{name: "Any", origin: "https://example.com", patterns: []string{"*"}, res: true},
+		{name: "Exact", origin: "https://example.com", patterns: []string{"https://example.com"}, res: true},
+		{name: "ExactWithPort", origin: "http://localhost:8080", patterns: []string{"http://localhost:8080"}, res: true},
+		{name: "SchemeMismatch", origin: "http://example.com", patterns: []string{"https://example.com"}, res: false},
+		{name: "AnyScheme", origin: "http://example.com", patterns: []string{"example.com"}, res: true},
+		{name: "Subdomain", origin: "https://app.example.com", patterns: []string{"https://*.example.com"}, res: true},
+		{name: "SubdomainAnyScheme", origin: "http://a.b.example.com", patterns: []string{"*.example.com"}, res: true},
+		{name: "SubdomainNotApex", origin: "https://example.com", patterns: []string{"https://*.example.com"}, res: false},
+		{name: "SuffixAttack", origin: "https://evilexample.com", patterns: []string{"*.example.com"}, res: false},
+		{name: "OtherHost", origin: "https://example.com.evil.com", patterns: []string{"example.com"}, res: false},
+		{name: "Invalid", origin: "null", patterns: []string{"example.com"}, res: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.res, isAllowedOrigin(tt.origin, tt.patterns))
+		})
+	}
+}
+
+func TestCheckWSOrigin(t *testing.T) {
+	tests := []struct {
+		name     string
+		origin   string
+		patterns []string
+		res      bool
+	}{
+		{name: "NoOrigin", origin: "", patterns: []string{}, res: true},
+		{name: "SameHost", origin: "https://cakemix.example.com", patterns: []string{}, res: true},
+		{name: "Listed", origin: "https://app.example.com", patterns: []string{"https://app.example.com"}, res: true},
+		{name: "NotListed", origin: "https://evil.com", patterns: []string{"https://app.example.com"}, res: false},
+		{name: "AnyIsNotAllowed", origin: "https://evil.com", patterns: []string{"*"}, res: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{}
+			h.state.Store(&handlerState{conf: HandlerConf{CORSOrigins: tt.patterns}})
+			req := httptest.NewRequest("GET", "http://cakemix.example.com/v1/doc/test/ws", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			assert.Equal(t, tt.res, h.checkWSOrigin(req))
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wonder-wonder/cakemix-server/db"
+	"github.com/wonder-wonder/cakemix-server/model"
+)
+
+// readableDocument returns the document info if the user can read it
+func (h *Handler) readableDocument(c *gin.Context, did string) (db.Document, bool, error) {
+	dinfo, err := h.db.GetDocumentInfo(did)
+	if err == db.ErrDocumentNotFound {
+		return dinfo, false, nil
+	} else if err != nil {
+		return dinfo, false, err
+	}
+	if !isRelatedUUID(c, dinfo.OwnerUUID) && dinfo.Permission == db.FilePermPrivate {
+		return dinfo, false, nil
+	}
+	return dinfo, true, nil
+}
+
+// toBrokenLinksModel converts the broken links into the model. The source documents which the user can't read are omitted.
+func (h *Handler) toBrokenLinksModel(c *gin.Context, links []db.DocumentLink) (model.BrokenLinksRes, error) {
+	res := model.BrokenLinksRes{BrokenLinks: []model.DocumentLink{}}
+	for _, l := range links {
+		src, ok, err := h.readableDocument(c, l.UUID)
+		if err != nil {
+			return res, err
+		}
+		if !ok {
+			continue
+		}
+		res.BrokenLinks = append(res.BrokenLinks, model.DocumentLink{
+			DocID:  l.UUID,
+			Title:  src.Title,
+			Text:   l.Title,
+			Broken: true,
+		})
+	}
+	return res, nil
+}
+
+func (h *Handler) getDocumentLinksHandler(c *gin.Context) {
+	dinfo, ok := h.getDocumentInfoByParam(c)
+	if !ok {
+		return
+	}
+	if !isRelatedUUID(c, dinfo.OwnerUUID) && dinfo.Permission == db.FilePermPrivate {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	links, err := h.db.GetDocumentLinks(dinfo.UUID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	res := model.DocumentLinksRes{Links: []model.DocumentLink{}}
+	for _, l := range links {
+		link := model.DocumentLink{DocID: l.Target, Text: l.Title}
+		if l.Target == "" {
+			link.Broken = true
+			res.Links = append(res.Links, link)
+			continue
+		}
+		target, err := h.db.GetDocumentInfo(l.Target)
+		if err == db.ErrDocumentNotFound {
+			link.Broken = true
+			res.Links = append(res.Links, link)
+			continue
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if !isRelatedUUID(c, target.OwnerUUID) && target.Permission == db.FilePermPrivate {
+			continue
+		}
+		link.Title = target.Title
+		res.Links = append(res.Links, link)
+	}
+	c.AbortWithStatusJSON(http.StatusOK, res)
+}
+
+func (h *Handler) getDocumentBacklinksHandler(c *gin.Context) {
+	dinfo, ok := h.getDocumentInfoByParam(c)
+	if !ok {
+		return
+	}
+	if !isRelatedUUID(c, dinfo.OwnerUUID) && dinfo.Permission == db.FilePermPrivate {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	links, err := h.db.GetDocumentBacklinks(dinfo.UUID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	res := model.DocumentLinksRes{Links: []model.DocumentLink{}}
+	for _, l := range links {
+		src, ok, err := h.readableDocument(c, l.UUID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if !ok {
+			continue
+		}
+		res.Links = append(res.Links, model.DocumentLink{
+			DocID: l.UUID,
+			Title: src.Title,
+			Text:  l.Title,
+		})
+	}
+	c.AbortWithStatusJSON(http.StatusOK, res)
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wonder-wonder/cakemix-server/db"
+	"github.com/wonder-wonder/cakemix-server/model"
+	"github.com/wonder-wonder/cakemix-server/ot"
+)
+
+// OutlineAnchorSearchMax is max number of old revisions searched for the anchor which is not in the revision
+const OutlineAnchorSearchMax = 100
+
+func toHeadingModel(h ot.Heading) model.DocumentHeading {
+	return model.DocumentHeading{
+		Level:  h.Level,
+		Text:   h.Text,
+		Anchor: h.Anchor,
+		Line:   h.Line,
+		Offset: h.Offset,
+		End:    h.End,
+	}
+}
+
+func (h *Handler) getDocumentOutlineHandler(c *gin.Context) {
+	dinfo, ok := h.getDocumentInfoByParam(c)
+	if !ok {
+		return
+	}
+	if !isRelatedUUID(c, dinfo.OwnerUUID) && dinfo.Permission == db.FilePermPrivate {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	rev := dinfo.Revision
+	text := ""
+	if c.Query("revision") != "" {
+		var err error
+		rev, err = strconv.Atoi(c.Query("revision"))
+		if err != nil || rev <= 0 {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		r, err := h.db.GetDocumentRevision(dinfo.UUID, rev)
+		if err == db.ErrDocumentNotFound {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		text = r.Text
+	} else {
+		var err error
+		text, err = h.db.GetLatestDocument(dinfo.UUID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	// Resolve the anchor of the deep link
+	if anchor := c.Query("anchor"); anchor != "" {
+		from := rev - OutlineAnchorSearchMax
+		if from < 1 {
+			from = 1
+		}
+		revs, err := h.db.GetDocumentRevisions(dinfo.UUID, from, rev-1)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		heading, anchorrev, ok := ot.ResolveAnchor(text, rev, revs, anchor)
+		if !ok {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusOK, model.DocumentAnchorRes{
+			Revision:       rev,
+			AnchorRevision: anchorrev,
+			Heading:        toHeadingModel(heading),
+		})
+		return
+	}
+
+	res := model.DocumentOutlineRes{Revision: rev, Headings: []model.DocumentHeading{}}
+	for _, v := range ot.Outline(text) {
+		res.Headings = append(res.Headings, toHeadingModel(v))
+	}
+	c.AbortWithStatusJSON(http.StatusOK, res)
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wonder-wonder/cakemix-server/db"
+	"github.com/wonder-wonder/cakemix-server/model"
+	"github.com/wonder-wonder/cakemix-server/ot"
+)
+
+// PresenceHandler is handlers of presence
+func (h *Handler) PresenceHandler(r *gin.RouterGroup) {
+	presck := r.Group("presence", h.CheckAuthMiddleware())
+	presck.GET("", h.getPresenceHandler)
+}
+
+// getPresenceHandler returns the users connected to the documents which the user can read.
+// If folder is specified, only the documents in the folder are returned.
+func (h *Handler) getPresenceHandler(c *gin.Context) {
+	fid := c.Query("folder")
+
+	var docIDs []string
+	isOwner := false
+	if fid != "" {
+		if fid[0] != 'f' {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		finfo, err := h.db.GetFolderInfo(fid)
+		if err != nil {
+			if err == db.ErrFolderNotFound {
+				c.AbortWithError(http.StatusNotFound, err)
+				return
+			}
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if !isRelatedUUID(c, finfo.OwnerUUID) && finfo.Permission == db.FilePermPrivate {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		isOwner = isRelatedUUID(c, finfo.OwnerUUID)
+		docIDs, err = h.db.GetDocList(fid)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		// Empty list (not nil) not to get all documents
+		if docIDs == nil {
+			docIDs = []string{}
+		}
+	}
+
+	res := model.PresenceRes{Documents: []model.DocumentPresence{}}
+	for _, v := range h.otmgr.Presence(docIDs) {
+		docinfo, err := h.db.GetDocumentInfo(v.DocID)
+		if err == db.ErrDocumentNotFound {
+			continue
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if !isOwner && !isRelatedUUID(c, docinfo.OwnerUUID) && docinfo.Permission == db.FilePermPrivate {
+			continue
+		}
+		p, err := h.toPresenceModel(v)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		res.Documents = append(res.Documents, p)
+	}
+	c.AbortWithStatusJSON(http.StatusOK, res)
+}
+
+func (h *Handler) getDocumentPresenceHandler(c *gin.Context) {
+	dinfo, ok := h.getDocumentInfoByParam(c)
+	if !ok {
+		return
+	}
+	if !isRelatedUUID(c, dinfo.OwnerUUID) && dinfo.Permission == db.FilePermPrivate {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	pres := ot.DocumentPresence{DocID: dinfo.UUID}
+	list := h.otmgr.Presence([]string{dinfo.UUID})
+	if len(list) > 0 {
+		pres = list[0]
+	}
+	res, err := h.toPresenceModel(pres)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusOK, res)
+}
+
+// toPresenceModel splits the users into viewers and editors with their profiles
+func (h *Handler) toPresenceModel(p ot.DocumentPresence) (model.DocumentPresence, error) {
+	res := model.DocumentPresence{
+		DocumentID: p.DocID,
+		Viewers:    []model.Profile{},
+		Editors:    []model.Profile{},
+	}
+	uuids := []string{}
+	for _, v := range p.Users {
+		uuids = append(uuids, v.UUID)
+	}
+	profiles, err := h.getProfiles(uuids)
+	if err != nil {
+		return res, err
+	}
+	for _, v := range p.Users {
+		prof, ok := profiles[v.UUID]
+		if !ok {
+			continue
+		}
+		if v.Editable {
+			res.Editors = append(res.Editors, prof)
+		} else {
+			res.Viewers = append(res.Viewers, prof)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wonder-wonder/cakemix-server/db"
+)
+
+// propertyQueryPrefix is prefix of the query parameters to filter by the property (e.g. prop.status=draft)
+const propertyQueryPrefix = "prop."
+
+// getDocumentProperties returns the front matter properties of the document as typed values
+func (h *Handler) getDocumentProperties(did string) (map[string]interface{}, error) {
+	props, err := h.db.GetDocumentProperties(did)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]interface{}{}
+	for _, p := range props {
+		switch p.Type {
+		case db.PropertyTypeNumber:
+			v, err := strconv.ParseFloat(p.Value, 64)
+			if err != nil {
+				continue
+			}
+			res[p.Key] = v
+		case db.PropertyTypeBool:
+			res[p.Key] = p.Value == "true"
+		case db.PropertyTypeList:
+			v := []string{}
+			if err := json.Unmarshal([]byte(p.Value), &v); err != nil {
+				continue
+			}
+			res[p.Key] = v
+		default:
+			res[p.Key] = p.Value
+		}
+	}
+	return res, nil
+}
+
+// getPropertyQuery parses the property filters. The value of the multiple parameters for the same key is the last one.
+func getPropertyQuery(c *gin.Context) (map[string]string, bool) {
+	res := map[string]string{}
+	for k, v := range c.Request.URL.Query() {
+		if !strings.HasPrefix(k, propertyQueryPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(k, propertyQueryPrefix)
+		if !db.IsValidPropertyKey(key) || len(v) == 0 {
+			return nil, false
+		}
+		res[key] = v[len(v)-1]
+	}
+	return res, true
+}
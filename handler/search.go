@@ -15,6 +15,7 @@ func (h *Handler) SearchHandler(r *gin.RouterGroup) {
 	profck := r.Group("search", h.CheckAuthMiddleware())
 	profck.GET("user", h.searchUserHandler)
 	profck.GET("team", h.searchTeamHandler)
+	profck.GET("doc", h.searchDocumentHandler)
 }
 
 func (h *Handler) searchUserHandler(c *gin.Context) {
@@ -158,3 +159,86 @@ func (h *Handler) searchTeamHandler(c *gin.Context) {
 
 	c.JSON(http.StatusOK, model.SearchTeamRes{Total: count, Teams: res})
 }
+
+func (h *Handler) searchDocumentHandler(c *gin.Context) {
+	res := []model.Document{}
+	var err error
+	q := c.Query("q")
+	tagids, ok := getTagIDsQuery(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	props, ok := getPropertyQuery(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	lim := -1
+	offset := -1
+	if c.Query("limit") != "" {
+		lim, err = strconv.Atoi(c.Query("limit"))
+		if err != nil || lim <= 0 {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+	}
+	if c.Query("offset") != "" {
+		offset, err = strconv.Atoi(c.Query("offset"))
+		if err != nil || offset < 0 {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	uuid, ok := getUUID(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	teams, _ := getTeams(c)
+	readers := append([]string{uuid}, teams...)
+
+	count, list, err := h.db.SearchDocument(q, tagids, props, readers, lim, offset)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	for _, v := range list {
+		dinfo, err := h.db.GetDocumentInfo(v)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		profiles, err := h.getProfiles([]string{dinfo.OwnerUUID, dinfo.UpdaterUUID})
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		tags, err := h.getDocumentTags(c, v)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		props, err := h.getDocumentProperties(v)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		res = append(res, model.Document{
+			UUID:           v,
+			Owner:          profiles[dinfo.OwnerUUID],
+			Updater:        profiles[dinfo.UpdaterUUID],
+			Title:          dinfo.Title,
+			Permission:     int(dinfo.Permission),
+			CreatedAt:      dinfo.CreatedAt,
+			UpdatedAt:      dinfo.UpdatedAt,
+			Editable:       isRelatedUUID(c, dinfo.OwnerUUID) || dinfo.Permission == db.FilePermReadWrite,
+			ParentFolderID: dinfo.ParentFolderUUID,
+			Tags:           tags,
+			Properties:     props,
+		})
+	}
+
+	c.JSON(http.StatusOK, model.SearchDocumentRes{Total: count, Documents: res})
+}
@@ -0,0 +1,317 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wonder-wonder/cakemix-server/db"
+	"github.com/wonder-wonder/cakemix-server/model"
+)
+
+// TagNameMax is max length of the tag name
+const TagNameMax = 64
+
+// TagHandler is handlers of tags
+func (h *Handler) TagHandler(r *gin.RouterGroup) {
+	tagck := r.Group("tags", h.CheckAuthMiddleware())
+	tagck.GET("", h.getTagsHandler)
+	tagck.POST("", h.createTagHandler)
+	tagck.GET(":tagid", h.getTagHandler)
+	tagck.PUT(":tagid", h.modifyTagHandler)
+	tagck.DELETE(":tagid", h.deleteTagHandler)
+}
+
+func toTagModel(t db.Tag) model.Tag {
+	return model.Tag{
+		ID:    t.ID,
+		Name:  t.Name,
+		Team:  t.TeamUUID,
+		Count: t.Count,
+	}
+}
+
+// canUseTag checks the tag is instance tag or the tag of the user's team
+func canUseTag(c *gin.Context, t db.Tag) bool {
+	return t.TeamUUID == "" || isRelatedUUID(c, t.TeamUUID)
+}
+
+// validTagName returns trimmed tag name and false if it's invalid
+func validTagName(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > TagNameMax {
+		return "", false
+	}
+	return name, true
+}
+
+// getTagIDsQuery parses the tag query parameters. Multiple tags mean documents having all tags. Duplicated tags are ignored.
+func getTagIDsQuery(c *gin.Context) ([]int, bool) {
+	res := []int{}
+	exists := map[int]bool{}
+	for _, v := range c.QueryArray("tag") {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			return nil, false
+		}
+		if exists[id] {
+			continue
+		}
+		exists[id] = true
+		res = append(res, id)
+	}
+	return res, true
+}
+
+// getDocumentTags returns the tags of the document which the user can see
+func (h *Handler) getDocumentTags(c *gin.Context, did string) ([]model.Tag, error) {
+	uuid, _ := getUUID(c)
+	teams, _ := getTeams(c)
+	readers := append([]string{uuid}, teams...)
+	tags, err := h.db.GetDocumentTags(did, readers)
+	if err != nil {
+		return nil, err
+	}
+	res := []model.Tag{}
+	for _, v := range tags {
+		if canUseTag(c, v) {
+			res = append(res, toTagModel(v))
+		}
+	}
+	return res, nil
+}
+
+// getTagByParam returns the tag of the path parameter. The response is aborted if failed.
+func (h *Handler) getTagByParam(c *gin.Context) (db.Tag, bool) {
+	id, err := strconv.Atoi(c.Param("tagid"))
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return db.Tag{}, false
+	}
+	uuid, _ := getUUID(c)
+	teams, _ := getTeams(c)
+	readers := append([]string{uuid}, teams...)
+	t, err := h.db.GetTag(id, readers)
+	if err == db.ErrTagNotFound {
+		c.AbortWithError(http.StatusNotFound, err)
+		return db.Tag{}, false
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return db.Tag{}, false
+	}
+	if !canUseTag(c, t) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return db.Tag{}, false
+	}
+	return t, true
+}
+
+func (h *Handler) getTagsHandler(c *gin.Context) {
+	teamfilter, filtered := c.GetQuery("team")
+	uuid, ok := getUUID(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	teams, ok := getTeams(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	readers := append([]string{uuid}, teams...)
+	tags, err := h.db.GetTags(teams, readers)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	res := model.TagListRes{Tags: []model.Tag{}}
+	for _, v := range tags {
+		if filtered && v.TeamUUID != teamfilter {
+			continue
+		}
+		res.Tags = append(res.Tags, toTagModel(v))
+	}
+	c.AbortWithStatusJSON(http.StatusOK, res)
+}
+
+func (h *Handler) getTagHandler(c *gin.Context) {
+	t, ok := h.getTagByParam(c)
+	if !ok {
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusOK, toTagModel(t))
+}
+
+func (h *Handler) createTagHandler(c *gin.Context) {
+	uuid, ok := getUUID(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	req := model.TagReq{}
+	err := c.BindJSON(&req)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	name, ok := validTagName(req.Name)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	// Instance tags are created by admin, team tags are created by the members
+	if req.Team == "" {
+		isAdmin, err := h.db.IsAdmin(uuid)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if !isAdmin {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+	} else if req.Team[0] != 't' {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	} else if !isRelatedUUID(c, req.Team) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	id, err := h.db.AddTag(req.Team, name)
+	if err == db.ErrExistTag {
+		c.AbortWithError(http.StatusConflict, err)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusOK, model.TagRes{TagID: id})
+}
+
+func (h *Handler) modifyTagHandler(c *gin.Context) {
+	uuid, ok := getUUID(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	t, ok := h.getTagByParam(c)
+	if !ok {
+		return
+	}
+	req := model.TagReq{}
+	err := c.BindJSON(&req)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	name, ok := validTagName(req.Name)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	permitted, err := h.checkScopeManager(uuid, t.TeamUUID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !permitted {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	err = h.db.RenameTag(t.ID, name)
+	if err == db.ErrExistTag {
+		c.AbortWithError(http.StatusConflict, err)
+		return
+	} else if err == db.ErrTagNotFound {
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.AbortWithStatus(http.StatusOK)
+}
+
+func (h *Handler) deleteTagHandler(c *gin.Context) {
+	uuid, ok := getUUID(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	t, ok := h.getTagByParam(c)
+	if !ok {
+		return
+	}
+	permitted, err := h.checkScopeManager(uuid, t.TeamUUID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !permitted {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	err = h.db.DeleteTag(t.ID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.AbortWithStatus(http.StatusOK)
+}
+
+func (h *Handler) getDocumentTagsHandler(c *gin.Context) {
+	dinfo, ok := h.getDocumentInfoByParam(c)
+	if !ok {
+		return
+	}
+	if !isRelatedUUID(c, dinfo.OwnerUUID) && dinfo.Permission == db.FilePermPrivate {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	tags, err := h.getDocumentTags(c, dinfo.UUID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusOK, model.TagListRes{Tags: tags})
+}
+
+func (h *Handler) addDocumentTagHandler(c *gin.Context) {
+	h.updateDocumentTag(c, true)
+}
+
+func (h *Handler) deleteDocumentTagHandler(c *gin.Context) {
+	h.updateDocumentTag(c, false)
+}
+
+// updateDocumentTag tags or untags the document. The user needs write permission of the document.
+func (h *Handler) updateDocumentTag(c *gin.Context, add bool) {
+	dinfo, ok := h.getDocumentInfoByParam(c)
+	if !ok {
+		return
+	}
+	if !isRelatedUUID(c, dinfo.OwnerUUID) && dinfo.Permission != db.FilePermReadWrite {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	t, ok := h.getTagByParam(c)
+	if !ok {
+		return
+	}
+	var err error
+	if add {
+		err = h.db.AddDocumentTag(dinfo.UUID, t.ID)
+	} else {
+		err = h.db.DeleteDocumentTag(dinfo.UUID, t.ID)
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.AbortWithStatus(http.StatusOK)
+}
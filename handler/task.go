@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wonder-wonder/cakemix-server/db"
+	"github.com/wonder-wonder/cakemix-server/model"
+	"github.com/wonder-wonder/cakemix-server/ot"
+)
+
+// TaskHandler is handlers of tasks
+func (h *Handler) TaskHandler(r *gin.RouterGroup) {
+	taskck := r.Group("tasks", h.CheckAuthMiddleware())
+	taskck.GET("", h.getTasksHandler)
+	taskck.PUT(":docid/:line", h.updateTaskHandler)
+}
+
+func (h *Handler) getTasksHandler(c *gin.Context) {
+	var err error
+	uuid, ok := getUUID(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	// Assignee is "me", username or UUID. Username is looked up first.
+	assignee := c.Query("assignee")
+	if assignee == "me" {
+		assignee = uuid
+	} else if assignee != "" {
+		p, err := h.db.GetProfileByUsername(assignee)
+		if err == db.ErrUserNotFound || err == db.ErrUserTeamNotFound {
+			if !((assignee[0] == 'u' || assignee[0] == 't') && len(assignee) == len(uuid)) {
+				c.AbortWithError(http.StatusNotFound, err)
+				return
+			}
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		} else {
+			assignee = p.UUID
+		}
+	}
+	state := db.TaskStateAll
+	switch strings.ToLower(c.Query("state")) {
+	case "", "all":
+	case "open":
+		state = db.TaskStateOpen
+	case "done":
+		state = db.TaskStateDone
+	default:
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	did := c.Query("doc")
+	if did != "" && did[0] != 'd' {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	lim := -1
+	offset := -1
+	if c.Query("limit") != "" {
+		lim, err = strconv.Atoi(c.Query("limit"))
+		if err != nil || lim <= 0 {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+	}
+	if c.Query("offset") != "" {
+		offset, err = strconv.Atoi(c.Query("offset"))
+		if err != nil || offset < 0 {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	teams, _ := getTeams(c)
+	readers := append([]string{uuid}, teams...)
+	count, tasks, err := h.db.GetTasks(assignee, state, did, readers, lim, offset)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	res := model.TaskListRes{Total: count, Tasks: []model.Task{}}
+	titles := map[string]string{}
+	for _, v := range tasks {
+		title, ok := titles[v.UUID]
+		if !ok {
+			dinfo, err := h.db.GetDocumentInfo(v.UUID)
+			if err != nil {
+				c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+			title = dinfo.Title
+			titles[v.UUID] = title
+		}
+		profiles, err := h.getProfiles(v.Assignees)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		t := model.Task{
+			DocID:     v.UUID,
+			DocTitle:  title,
+			Line:      v.Line,
+			Text:      v.Text,
+			Done:      v.Done,
+			Due:       v.Due,
+			Assignees: []model.Profile{},
+		}
+		for _, a := range v.Assignees {
+			if p, ok := profiles[a]; ok {
+				t.Assignees = append(t.Assignees, p)
+			}
+		}
+		res.Tasks = append(res.Tasks, t)
+	}
+	c.AbortWithStatusJSON(http.StatusOK, res)
+}
+
+// updateTaskHandler checks or unchecks the task by editing the source line. If the document is opened, the edit is applied to the session.
+func (h *Handler) updateTaskHandler(c *gin.Context) {
+	uuid, ok := getUUID(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	line, err := strconv.Atoi(c.Param("line"))
+	if err != nil || line <= 0 {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	req := model.TaskUpdateReq{}
+	err = c.BindJSON(&req)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	dinfo, ok := h.getDocumentInfoByParam(c)
+	if !ok {
+		return
+	}
+	if !isRelatedUUID(c, dinfo.OwnerUUID) && dinfo.Permission != db.FilePermReadWrite {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	change := func(cur string) (ot.Ops, error) {
+		text, err := db.SetTaskState(cur, line, req.Text, *req.Done)
+		if err != nil {
+			return ot.Ops{}, err
+		}
+		return ot.Diff(cur, text), nil
+	}
+	rev, err := h.otmgr.UpdateContent(dinfo.UUID, uuid, "", change)
+	if err != nil {
+		if errors.Is(err, db.ErrTaskNotFound) {
+			c.AbortWithError(http.StatusConflict, err)
+			return
+		} else if errors.Is(err, ot.ErrorSessionBusy) {
+			c.AbortWithError(http.StatusServiceUnavailable, err)
+			return
+		}
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusOK, model.DocumentContentRes{Revision: rev})
+}
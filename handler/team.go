@@ -28,7 +28,7 @@ func (h *Handler) createTeamHandler(c *gin.Context) {
 		return
 	}
 
-	if !permitUserToCreateTeam {
+	if !h.getConf().PermitUserToCreateTeam {
 		isadmin, err := h.db.IsAdmin(useruuid)
 		if err != nil {
 			c.AbortWithError(http.StatusInternalServerError, err)
@@ -80,6 +80,8 @@ func (h *Handler) deleteTeamHandler(c *gin.Context) {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+	// Members may lose the permission of the team documents
+	h.otmgr.CheckPermission("", "")
 	c.AbortWithStatus(http.StatusOK)
 }
 
@@ -197,6 +199,7 @@ func (h *Handler) addTeamMemberHandler(c *gin.Context) {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+	h.otmgr.CheckPermission("", req.Member)
 	c.AbortWithStatus(http.StatusOK)
 }
 
@@ -311,5 +314,6 @@ func (h *Handler) deleteTeamMemberHandler(c *gin.Context) {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+	h.otmgr.CheckPermission("", memberuuid)
 	c.AbortWithStatus(http.StatusOK)
 }
@@ -0,0 +1,359 @@
+package handler
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wonder-wonder/cakemix-server/db"
+	"github.com/wonder-wonder/cakemix-server/model"
+)
+
+// Limits of folder template instantiation
+const (
+	TemplateDepthMax = 10
+	TemplateItemMax  = 1000
+)
+
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// TemplateHandler is handlers of templates
+func (h *Handler) TemplateHandler(r *gin.RouterGroup) {
+	tmplck := r.Group("templates", h.CheckAuthMiddleware())
+	tmplck.GET("", h.getTemplatesHandler)
+	tmplck.POST("", h.addTemplateHandler)
+	tmplck.DELETE(":id", h.deleteTemplateHandler)
+	tmplck.POST(":id/instantiate", h.instantiateTemplateHandler)
+}
+
+// fillTemplate replaces the placeholders like {{name}}. Unknown placeholders are left as it is.
+func fillTemplate(text string, fields map[string]string) string {
+	return templatePlaceholder.ReplaceAllStringFunc(text, func(s string) string {
+		name := templatePlaceholder.FindStringSubmatch(s)[1]
+		if v, ok := fields[name]; ok {
+			return v
+		}
+		return s
+	})
+}
+
+// getTemplateTarget returns the owner, permission and name of the template target (document or folder)
+func (h *Handler) getTemplateTarget(id string) (string, db.FilePerm, string, error) {
+	if id[0] == 'd' {
+		dinfo, err := h.db.GetDocumentInfo(id)
+		if err != nil {
+			return "", 0, "", err
+		}
+		return dinfo.OwnerUUID, dinfo.Permission, dinfo.Title, nil
+	}
+	finfo, err := h.db.GetFolderInfo(id)
+	if err != nil {
+		return "", 0, "", err
+	}
+	return finfo.OwnerUUID, finfo.Permission, finfo.Name, nil
+}
+
+// canUseTemplate checks the user can see the template scope (instance or team)
+func canUseTemplate(c *gin.Context, t db.Template) bool {
+	if t.TeamUUID == "" {
+		return true
+	}
+	teams, _ := getTeams(c)
+	for _, v := range teams {
+		if v == t.TeamUUID {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) getTemplatesHandler(c *gin.Context) {
+	teamfilter := c.Query("team")
+	teams, ok := getTeams(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	list, err := h.db.GetTemplates(teams)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	templates := []db.Template{}
+	uuids := []string{}
+	for _, v := range list {
+		if teamfilter != "" && v.TeamUUID != teamfilter {
+			continue
+		}
+		owner, perm, _, err := h.getTemplateTarget(v.UUID)
+		if err == db.ErrDocumentNotFound || err == db.ErrFolderNotFound {
+			continue
+		} else if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if !isRelatedUUID(c, owner) && perm == db.FilePermPrivate {
+			continue
+		}
+		templates = append(templates, v)
+		uuids = append(uuids, v.CreatorUUID)
+		if v.TeamUUID != "" {
+			uuids = append(uuids, v.TeamUUID)
+		}
+	}
+	profiles, err := h.getProfiles(uuids)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	res := model.TemplateListRes{Templates: []model.Template{}}
+	for _, v := range templates {
+		t := model.Template{
+			ID:          v.UUID,
+			Type:        "document",
+			Name:        v.Name,
+			Description: v.Description,
+			CreatedAt:   v.CreatedAt,
+			Creator:     profiles[v.CreatorUUID],
+		}
+		if v.UUID[0] == 'f' {
+			t.Type = "folder"
+		}
+		if v.TeamUUID != "" {
+			team := profiles[v.TeamUUID]
+			t.Team = &team
+		}
+		res.Templates = append(res.Templates, t)
+	}
+	c.AbortWithStatusJSON(http.StatusOK, res)
+}
+
+func (h *Handler) addTemplateHandler(c *gin.Context) {
+	uuid, ok := getUUID(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	req := model.TemplateReq{}
+	err := c.BindJSON(&req)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if req.ID[0] != 'd' && req.ID[0] != 'f' {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if req.Team != "" && req.Team[0] != 't' {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	permitted, err := h.checkScopeManager(uuid, req.Team)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !permitted {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	owner, perm, name, err := h.getTemplateTarget(req.ID)
+	if err == db.ErrDocumentNotFound || err == db.ErrFolderNotFound {
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !isRelatedUUID(c, owner) && perm == db.FilePermPrivate {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	if req.Name == "" {
+		req.Name = name
+	}
+
+	err = h.db.AddTemplate(db.Template{
+		UUID:        req.ID,
+		TeamUUID:    req.Team,
+		Name:        req.Name,
+		Description: req.Description,
+		CreatorUUID: uuid,
+	})
+	if err == db.ErrExistTemplate {
+		c.AbortWithError(http.StatusConflict, err)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.AbortWithStatus(http.StatusOK)
+}
+
+func (h *Handler) deleteTemplateHandler(c *gin.Context) {
+	id := c.Param("id")
+	uuid, ok := getUUID(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	t, err := h.db.GetTemplate(id)
+	if err == db.ErrTemplateNotFound {
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	permitted, err := h.checkScopeManager(uuid, t.TeamUUID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	// The creator can also unmark
+	if !permitted && t.CreatorUUID != uuid {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	err = h.db.DeleteTemplate(id)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.AbortWithStatus(http.StatusOK)
+}
+
+func (h *Handler) instantiateTemplateHandler(c *gin.Context) {
+	id := c.Param("id")
+	uuid, ok := getUUID(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	req := model.TemplateInstantiateReq{}
+	err := c.BindJSON(&req)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if req.FolderID[0] != 'f' {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	t, err := h.db.GetTemplate(id)
+	if err == db.ErrTemplateNotFound {
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !canUseTemplate(c, t) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	owner, perm, _, err := h.getTemplateTarget(id)
+	if err == db.ErrDocumentNotFound || err == db.ErrFolderNotFound {
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !isRelatedUUID(c, owner) && perm == db.FilePermPrivate {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	finfo, err := h.db.GetFolderInfo(req.FolderID)
+	if err != nil {
+		if err == db.ErrFolderNotFound {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !isRelatedUUID(c, finfo.OwnerUUID) && finfo.Permission != db.FilePermReadWrite {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	owneruuid := uuid
+	if isRelatedUUID(c, finfo.OwnerUUID) {
+		owneruuid = finfo.OwnerUUID
+	}
+
+	// Custom fields can't override built-in fields
+	fields := map[string]string{}
+	for k, v := range req.Fields {
+		fields[k] = v
+	}
+	author, err := h.db.GetProfileByUUID(uuid)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	fields["author"] = author.Name
+	fields["date"] = time.Now().Format("2006-01-02")
+	fields["team"] = ""
+	teamuuid := t.TeamUUID
+	if teamuuid == "" && owneruuid[0] == 't' {
+		teamuuid = owneruuid
+	}
+	if teamuuid != "" {
+		team, err := h.db.GetProfileByUUID(teamuuid)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		fields["team"] = team.Name
+	}
+
+	res := model.TemplateInstantiateRes{}
+	if id[0] == 'd' {
+		res.DocumentID, err = h.instantiateDocument(id, req.Title, fields, req.FolderID, owneruuid, uuid)
+	} else {
+		teams, _ := getTeams(c)
+		readers := append([]string{uuid}, teams...)
+		fill := func(text string) string {
+			return fillTemplate(text, fields)
+		}
+		res.FolderID, err = h.db.InstantiateFolder(id, req.Title, req.FolderID, readers, owneruuid, uuid, fill, TemplateDepthMax, TemplateItemMax)
+	}
+	if err == db.ErrFolderTooLarge {
+		c.AbortWithError(http.StatusRequestEntityTooLarge, err)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	err = h.db.UpdateFolder(req.FolderID, uuid)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusOK, res)
+}
+
+// instantiateDocument creates the document from the template document. If title is empty, the first line is used.
+func (h *Handler) instantiateDocument(did string, title string, fields map[string]string, parentfid string, owneruuid string, uuid string) (string, error) {
+	text, _, err := h.otmgr.GetContent(did)
+	if err != nil {
+		return "", err
+	}
+	text = fillTemplate(text, fields)
+	if title == "" {
+		title = db.ExtractTitle(text)
+	} else {
+		title = fillTemplate(title, fields)
+	}
+	if title == "" {
+		title = "Untitled"
+	}
+	return h.db.CreateDocument(title, text, db.FilePermPrivate, parentfid, owneruuid, uuid)
+}
@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/wonder-wonder/cakemix-server/db"
+	"github.com/wonder-wonder/cakemix-server/model"
+	"github.com/wonder-wonder/cakemix-server/ot"
+	"github.com/wonder-wonder/cakemix-server/util"
+)
+
+// Timeline limits
+const (
+	timelineLimitDefault = 50
+	timelineLimitMax     = 200
+	// Max wait between changes in replay (before speed is applied)
+	replayMaxGap   = 5 * time.Second
+	replaySpeedMax = 1000
+)
+
+func toTimelineModel(changes []ot.TimelineChange) []model.TimelineChange {
+	res := []model.TimelineChange{}
+	for _, v := range changes {
+		res = append(res, model.TimelineChange{
+			Revision: v.Revision,
+			Time:     v.Time,
+			UUID:     v.UUID,
+			Ops:      v.Ops,
+		})
+	}
+	return res
+}
+
+func (h *Handler) getTimelineHandler(c *gin.Context) {
+	from := 1
+	limit := timelineLimitDefault
+	var err error
+	if c.Query("from") != "" {
+		from, err = strconv.Atoi(c.Query("from"))
+		if err != nil || from <= 0 {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+	if c.Query("limit") != "" {
+		limit, err = strconv.Atoi(c.Query("limit"))
+		if err != nil || limit <= 0 {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		if limit > timelineLimitMax {
+			limit = timelineLimitMax
+		}
+	}
+
+	dinfo, ok := h.getDocumentInfoByParam(c)
+	if !ok {
+		return
+	}
+	if !isRelatedUUID(c, dinfo.OwnerUUID) && dinfo.Permission == db.FilePermPrivate {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	if from > dinfo.Revision {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	revs, err := h.db.GetDocumentRevisions(dinfo.UUID, from, from+limit)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if len(revs) == 0 {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	ops, err := h.db.GetDocumentOps(dinfo.UUID, from+1, from+limit)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	last := revs[len(revs)-1].Revision
+	res := model.TimelineRes{
+		BaseRevision: revs[0].Revision,
+		Base:         revs[0].Text,
+		LastRevision: last,
+		HasMore:      last < dinfo.Revision,
+		Changes:      toTimelineModel(ot.BuildTimeline(revs, ops)),
+	}
+	authors := []string{}
+	for _, v := range res.Changes {
+		authors = append(authors, v.UUID)
+	}
+	res.Authors, err = h.getProfiles(authors)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusOK, res)
+}
+
+// replayTimelineHandler streams the timeline by websocket (read only).
+// It sends doc event (base text), op events with the interval scaled by speed, and end event.
+func (h *Handler) replayTimelineHandler(c *gin.Context) {
+	from := 1
+	to := 0
+	speed := 1.0
+	var err error
+	if c.Query("from") != "" {
+		from, err = strconv.Atoi(c.Query("from"))
+		if err != nil || from <= 0 {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+	if c.Query("to") != "" {
+		to, err = strconv.Atoi(c.Query("to"))
+		if err != nil || to < from {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+	if c.Query("speed") != "" {
+		speed, err = strconv.ParseFloat(c.Query("speed"), 64)
+		if err != nil || speed <= 0 || speed > replaySpeedMax {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	docID := c.Param("docid")
+	if docID == "" || docID[0] != 'd' {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	// Authenticate before looking up the document so that its existence isn't leaked
+	conn, _, ok := h.upgradeDocWS(c, docID)
+	if !ok {
+		return
+	}
+	defer conn.Close()
+	closeWS := func(code int, reason string) {
+		err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second*10))
+		if err != nil {
+			util.Errorf("Replay error: websocket error: %v", err)
+		}
+	}
+
+	dinfo, err := h.db.GetDocumentInfo(docID)
+	if err == db.ErrDocumentNotFound {
+		closeWS(ot.CloseCodeDocumentNotFound, "document not found")
+		return
+	} else if err != nil {
+		util.Errorf("Replay error: %v", err)
+		closeWS(ot.CloseCodeSessionError, "failed to open the document")
+		return
+	}
+	if to == 0 || to > dinfo.Revision {
+		to = dinfo.Revision
+	}
+	if from > to {
+		closeWS(ot.CloseCodeDocumentNotFound, "revision not found")
+		return
+	}
+
+	// Reader routine to detect close
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	type replayMsg struct {
+		Event string      `json:"e"`
+		Data  interface{} `json:"d,omitempty"`
+	}
+	send := func(msg replayMsg) bool {
+		err := conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+		if err != nil {
+			util.Errorf("Replay error: websocket error: %v", err)
+			return false
+		}
+		err = conn.WriteJSON(msg)
+		if err != nil {
+			util.Errorf("Replay error: websocket error: %v", err)
+			return false
+		}
+		return true
+	}
+
+	var lastTime int64
+	for cur := from; cur < to || cur == from; cur += timelineLimitMax {
+		end := cur + timelineLimitMax
+		if end > to {
+			end = to
+		}
+		revs, err := h.db.GetDocumentRevisions(dinfo.UUID, cur, end)
+		if err != nil || len(revs) == 0 {
+			util.Errorf("Replay error: %v", err)
+			return
+		}
+		ops, err := h.db.GetDocumentOps(dinfo.UUID, cur+1, end)
+		if err != nil {
+			util.Errorf("Replay error: %v", err)
+			return
+		}
+		if cur == from {
+			if !send(replayMsg{Event: "doc", Data: map[string]interface{}{"document": revs[0].Text, "revision": revs[0].Revision}}) {
+				return
+			}
+			lastTime = revs[0].UpdatedAt * 1000
+		}
+		for _, v := range toTimelineModel(ot.BuildTimeline(revs, ops)) {
+			gap := time.Duration(v.Time-lastTime) * time.Millisecond
+			if gap > replayMaxGap {
+				gap = replayMaxGap
+			}
+			lastTime = v.Time
+			if gap > 0 {
+				select {
+				case <-closed:
+					return
+				case <-time.After(time.Duration(float64(gap) / speed)):
+				}
+			}
+			if !send(replayMsg{Event: "op", Data: v}) {
+				return
+			}
+		}
+		if end >= to {
+			break
+		}
+	}
+	if !send(replayMsg{Event: "end"}) {
+		return
+	}
+	closeWS(websocket.CloseNormalClosure, "")
+}
@@ -2,11 +2,10 @@ package main
 
 import (
 	"fmt"
-	"io"
-	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
-	"path"
 	"strings"
 	"syscall"
 	"time"
@@ -14,12 +13,19 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/wonder-wonder/cakemix-server/db"
 	"github.com/wonder-wonder/cakemix-server/handler"
+	"github.com/wonder-wonder/cakemix-server/ot"
 	"github.com/wonder-wonder/cakemix-server/util"
 )
 
 var version string = ""
 
-const defaultConfig = "/etc/cakemix/cakemix.conf"
+// Default config files. The first found one is loaded.
+var defaultConfigs = []string{
+	"/etc/cakemix/cakemix.yml",
+	"/etc/cakemix/cakemix.yaml",
+	"/etc/cakemix/cakemix.toml",
+	"/etc/cakemix/cakemix.conf",
+}
 
 func main() {
 	if version == "" {
@@ -27,16 +33,17 @@ func main() {
 	}
 	fmt.Printf("\nCakemix %s\n\n", version)
 
-	// Load default config file
-	if _, err := os.Stat(defaultConfig); err == nil {
-		log.Printf("Loading config %s", defaultConfig)
-		err := util.LoadConfigFile(defaultConfig)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error occured while loading config: %v\n", err)
-			os.Exit(1)
+	conffiles := []string{}
+
+	// Find default config file
+	for _, v := range defaultConfigs {
+		if _, err := os.Stat(v); err == nil {
+			conffiles = append(conffiles, v)
+			break
 		}
 	}
 
+	checkConfig := false
 	if len(os.Args) > 1 {
 		for i := 1; i < len(os.Args); i++ {
 			switch strings.ToLower(os.Args[i]) {
@@ -46,12 +53,14 @@ func main() {
 					fmt.Fprintf(os.Stderr, "Option %s requires an argument\n", os.Args[i-1])
 					os.Exit(1)
 				}
-				log.Printf("Loading config %s", os.Args[i])
-				err := util.LoadConfigFile(os.Args[i])
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error occured while loading config: %v\n", err)
+				conffiles = append(conffiles, os.Args[i])
+			case "config":
+				i++
+				if i >= len(os.Args) || os.Args[i] != "check" {
+					fmt.Fprintf(os.Stderr, "Usage: %s config check [-c configfile]\n", os.Args[0])
 					os.Exit(1)
 				}
+				checkConfig = true
 			default:
 				fmt.Fprintf(os.Stderr, "Unknown option: %s\n", os.Args[i])
 				os.Exit(1)
@@ -59,35 +68,45 @@ func main() {
 		}
 	}
 
-	util.LoadConfigEnv()
-	fileconf := util.GetFileConf()
-	dbconf := util.GetDBConf()
-	apiconf := util.GetAPIConf()
-	mailconf := util.GetMailConf()
-
-	gin.SetMode(gin.ReleaseMode)
-
-	if fileconf.LogFile != "" {
-		// Make log directory
-		err := os.MkdirAll(path.Dir(fileconf.LogFile), 0700)
-		if err != nil {
-			panic(err)
-		}
-		f, err := os.OpenFile(fileconf.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	conf, err := loadConfig(conffiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error occured while loading config: %v\n", err)
+		os.Exit(1)
+	}
+	if checkConfig {
+		confstr, err := conf.Masked().YAML()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error occured while opening log file: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error occured while dumping config: %v\n", err)
 			os.Exit(1)
 		}
-		gin.DefaultWriter = io.MultiWriter(f)
+		fmt.Printf("Configuration is valid\n\n%s", confstr)
+		os.Exit(0)
+	}
+	fileconf := conf.File
+	dbconf := conf.DB
+	apiconf := conf.API
+	mailconf := conf.Mail
+
+	gin.SetMode(gin.ReleaseMode)
+
+	// Access log
+	logw := util.NewLogWriter()
+	err = logw.Open(fileconf.LogFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error occured while opening log file: %v\n", err)
+		os.Exit(1)
 	}
+	loglevel, _ := util.ParseLogLevel(fileconf.LogLevel)
+	util.SetLogLevel(loglevel)
+	gin.DefaultWriter = logw
 
 	r := gin.Default()
 	r.MaxMultipartMemory = 8 << 20 // 8 MiB
 
 	// Check keyfiles exist
-	_, err := os.Stat(fileconf.SignPrvKey)
+	_, err = os.Stat(fileconf.SignPrvKey)
 	if err != nil {
-		log.Printf("Generating public/private keys...\n")
+		util.Infof("Generating public/private keys...")
 		err = util.GenerateKeys(fileconf.SignPrvKey, fileconf.SignPubKey)
 		if err != nil {
 			panic(err)
@@ -99,22 +118,59 @@ func main() {
 		panic(err)
 	}
 
-	db, err := db.OpenDB(dbconf.Host, dbconf.Port, dbconf.User, dbconf.Pass, dbconf.Name)
+	db, err := db.OpenDB(db.Config{
+		Host:            dbconf.Host,
+		Port:            dbconf.Port,
+		User:            dbconf.User,
+		Pass:            dbconf.Pass,
+		Name:            dbconf.Name,
+		URL:             dbconf.URL,
+		SSLMode:         dbconf.SSLMode,
+		SSLRootCert:     dbconf.SSLRootCert,
+		SSLCert:         dbconf.SSLCert,
+		SSLKey:          dbconf.SSLKey,
+		MaxOpenConns:    dbconf.MaxOpenConns,
+		MaxIdleConns:    dbconf.MaxIdleConns,
+		ConnMaxLifetime: dbconf.ConnMaxLifetime,
+	})
 	if err != nil {
 		panic(err)
 	}
+	// Wait DB startup
+	err = db.WaitReady(dbconf.ConnectRetry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error occured while connecting to DB: %v\n", err)
+		os.Exit(1)
+	}
 
 	// API handler
-	r.Use(handler.CORS())
-	v1 := r.Group("v1")
-	hconf := handler.HandlerConf{
+	h := handler.NewHandler(db, handler.HandlerConf{
 		DataDir:                fileconf.DataDir,
-		MailTemplateResetPW:    mailconf.TmplResetPW,
-		MailTemplateRegist:     mailconf.TmplRegist,
-		CORSHost:               apiconf.CORS,
+		CORSOrigins:            apiconf.CORS,
 		PermitUserToCreateTeam: apiconf.PermitUserToCreateTeam,
-	}
-	v1Handler(v1, db, hconf)
+		Mail:                   mailconf,
+		OT: ot.Config{
+			AutoSaveInterval:   conf.OT.AutoSaveInterval,
+			HistGCThreshold:    conf.OT.HistGCThreshold,
+			ClientPingInterval: conf.OT.ClientPingInterval,
+			ServerStopDelay:    conf.OT.ServerStopDelay,
+			MaxOpsPerSecond:    conf.OT.MaxOpsPerSecond,
+			MaxMessageSize:     conf.OT.MaxMessageSize,
+			MaxDocumentSize:    conf.OT.MaxDocumentSize,
+		},
+	})
+	r.Use(h.CORS())
+	v1 := r.Group("v1")
+	v1Handler(v1, h)
+
+	// Reload config by SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadConfig(conffiles, &conf, h, logw)
+		}
+	}()
 
 	// Front serve
 	if fileconf.FrontDir != "" {
@@ -132,36 +188,160 @@ func main() {
 		})
 	}
 
-	// Init mail
-	util.InitMail(mailconf.SendGridAPIKey, mailconf.FromAddr, mailconf.FromName)
 	// DB cleaup
 	go func() {
-		// Wait DB startup
-		time.Sleep(time.Minute)
 		for {
 			err := db.CleanupExpired()
 			if err != nil {
-				log.Printf("DB cleanup error: %v", err)
+				util.Errorf("DB cleanup error: %v", err)
 			}
 			time.Sleep(time.Hour)
 		}
 	}()
 
 	// Start web server
-	log.Println("Start server")
+	util.Infof("Start server")
 
-	err = r.Run(apiconf.Host + ":" + apiconf.Port)
+	err = serve(r, apiconf)
 	if err != nil {
 		panic(err)
 	}
 }
 
-func v1Handler(r *gin.RouterGroup, db *db.DB, hconf handler.HandlerConf) {
+// serve starts web server on TCP port or unix socket. If the certificate is specified, it serves HTTPS (and HTTP/2).
+func serve(r http.Handler, apiconf util.APIConf) error {
+	srv := &http.Server{Handler: r, ReadHeaderTimeout: time.Second * 10}
+
+	var ln net.Listener
+	var err error
+	if apiconf.Socket != "" {
+		// Remove the socket file left by previous process
+		err = os.Remove(apiconf.Socket)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		ln, err = net.Listen("unix", apiconf.Socket)
+		if err != nil {
+			return err
+		}
+		err = os.Chmod(apiconf.Socket, 0660)
+		if err != nil {
+			return err
+		}
+	} else {
+		ln, err = net.Listen("tcp", net.JoinHostPort(apiconf.Host, apiconf.Port))
+		if err != nil {
+			return err
+		}
+	}
+
+	if apiconf.TLSCert == "" {
+		util.Infof("Listening and serving HTTP on %s", ln.Addr())
+		return srv.Serve(ln)
+	}
+
+	cr, err := util.NewCertReloader(apiconf.TLSCert, apiconf.TLSKey)
+	if err != nil {
+		return err
+	}
+	minver, err := util.ParseTLSVersion(apiconf.TLSMinVersion)
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = cr.TLSConfig(minver)
+
+	if apiconf.HTTPRedirectPort != "" {
+		redirectsrv := &http.Server{
+			Addr:              net.JoinHostPort(apiconf.Host, apiconf.HTTPRedirectPort),
+			Handler:           httpsRedirectHandler(apiconf.Port),
+			ReadHeaderTimeout: time.Second * 10,
+		}
+		go func() {
+			util.Infof("Listening and redirecting HTTP on %s", redirectsrv.Addr)
+			err := redirectsrv.ListenAndServe()
+			if err != nil {
+				util.Errorf("HTTP redirect server error: %v", err)
+			}
+		}()
+	}
+
+	util.Infof("Listening and serving HTTPS on %s", ln.Addr())
+	return srv.ServeTLS(ln, "", "")
+}
+
+// httpsRedirectHandler redirects all requests to HTTPS
+func httpsRedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// loadConfig loads config files and environment variables, and validates them
+func loadConfig(conffiles []string) (util.Config, error) {
+	conf := util.DefaultConfig()
+	for _, v := range conffiles {
+		util.Infof("Loading config %s", v)
+		err := util.LoadConfigFile(v, &conf)
+		if err != nil {
+			return conf, err
+		}
+	}
+	err := util.LoadConfigEnv(&conf)
+	if err != nil {
+		return conf, err
+	}
+	err = conf.Validate()
+	if err != nil {
+		return conf, err
+	}
+	return conf, nil
+}
+
+// reloadConfig reloads config and applies reloadable settings
+func reloadConfig(conffiles []string, conf *util.Config, h *handler.Handler, logw *util.LogWriter) {
+	util.Infof("Reloading config...")
+	newconf, err := loadConfig(conffiles)
+	if err != nil {
+		util.Errorf("Config reload error: %v", err)
+		return
+	}
+	changed, needRestart := conf.ApplyReloadable(newconf)
+
+	h.UpdateConf(handler.HandlerConf{
+		CORSOrigins:            conf.API.CORS,
+		PermitUserToCreateTeam: conf.API.PermitUserToCreateTeam,
+		Mail:                   conf.Mail,
+	})
+	loglevel, _ := util.ParseLogLevel(conf.File.LogLevel)
+	util.SetLogLevel(loglevel)
+	// Reopen log file even if it's not changed (for logrotate)
+	err = logw.Open(conf.File.LogFile)
+	if err != nil {
+		util.Errorf("Config reload error: failed to open log file: %v", err)
+	}
+
+	if len(changed) > 0 {
+		util.Infof("Config reloaded: %s changed", strings.Join(changed, ", "))
+	} else {
+		util.Infof("Config reloaded: no changes")
+	}
+	if len(needRestart) > 0 {
+		util.Warnf("Config %s changed but restart is required to apply", strings.Join(needRestart, ", "))
+	}
+}
+
+func v1Handler(r *gin.RouterGroup, h *handler.Handler) {
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt)
 	signal.Notify(sig, syscall.SIGQUIT)
 	signal.Notify(sig, syscall.SIGTERM)
-	h := handler.NewHandler(db, hconf)
 	h.AuthHandler(r)
 	h.DocumentHandler(r)
 	h.FolderHandler(r)
@@ -169,6 +349,10 @@ func v1Handler(r *gin.RouterGroup, db *db.DB, hconf handler.HandlerConf) {
 	h.TeamHandler(r)
 	h.SearchHandler(r)
 	h.ImageHandler(r)
+	h.PresenceHandler(r)
+	h.TemplateHandler(r)
+	h.TagHandler(r)
+	h.TaskHandler(r)
 	go func() {
 		<-sig
 		h.StopOTManager()
@@ -2,19 +2,29 @@ package model
 
 // Document is structure for document info
 type Document struct {
-	UUID           string  `json:"uuid"`
-	Owner          Profile `json:"owner"`
-	Updater        Profile `json:"updater"`
-	Title          string  `json:"title"`
-	Body           string  `json:"body"`
-	Permission     int     `json:"permission"`
-	CreatedAt      int64   `json:"created_at"`
-	UpdatedAt      int64   `json:"updated_at"`
-	Editable       bool    `json:"editable"`
-	ParentFolderID string  `json:"parentfolderid"`
+	UUID           string                 `json:"uuid"`
+	Owner          Profile                `json:"owner"`
+	Updater        Profile                `json:"updater"`
+	Title          string                 `json:"title"`
+	Body           string                 `json:"body"`
+	Permission     int                    `json:"permission"`
+	CreatedAt      int64                  `json:"created_at"`
+	UpdatedAt      int64                  `json:"updated_at"`
+	Editable       bool                   `json:"editable"`
+	ParentFolderID string                 `json:"parentfolderid"`
+	Tags           []Tag                  `json:"tags"`
+	Properties     map[string]interface{} `json:"properties"`
 	Revision       int
 }
 
+// CreateDocumentReq is structure for request of document creation. All fields are optional.
+type CreateDocumentReq struct {
+	Title      string `json:"title"`
+	Body       string `json:"body"`
+	Permission *int   `json:"permission"`
+	TemplateID string `json:"template_id"`
+}
+
 // CreateDocumentRes is structure for response of document creation
 type CreateDocumentRes struct {
 	DocumentID string `json:"doc_id"`
@@ -25,3 +35,113 @@ type DocumentModifyReqModel struct {
 	OwnerUUID  string `json:"owneruuid"`
 	Permission int    `json:"permission"`
 }
+
+// DocumentBlameRes is structure for response of document attribution
+type DocumentBlameRes struct {
+	Revision int                 `json:"revision"`
+	Lines    []DocumentBlameLine `json:"lines"`
+	Authors  map[string]Profile  `json:"authors"`
+}
+
+// DocumentBlameLine is structure for attribution of a line
+type DocumentBlameLine struct {
+	Line int    `json:"line"`
+	UUID string `json:"uuid"`
+	Time int64  `json:"time"`
+}
+
+// TimelineRes is structure for response of document timeline
+type TimelineRes struct {
+	BaseRevision int                `json:"base_revision"`
+	Base         string             `json:"base"`
+	LastRevision int                `json:"last_revision"`
+	HasMore      bool               `json:"has_more"`
+	Changes      []TimelineChange   `json:"changes"`
+	Authors      map[string]Profile `json:"authors"`
+}
+
+// TimelineChange is structure for a change in the timeline
+type TimelineChange struct {
+	Revision int           `json:"revision"`
+	Time     int64         `json:"time"`
+	UUID     string        `json:"uuid"`
+	Ops      []interface{} `json:"ops"`
+}
+
+// DocumentChatRes is structure for response of chat messages
+type DocumentChatRes struct {
+	Messages []DocumentChatMessage `json:"messages"`
+	HasNext  bool                  `json:"has_next"`
+	Authors  map[string]Profile    `json:"authors"`
+}
+
+// DocumentChatMessage is structure for a chat message
+type DocumentChatMessage struct {
+	ID      int64  `json:"id"`
+	UUID    string `json:"uuid"`
+	Message string `json:"message"`
+	Date    int64  `json:"date"`
+}
+
+// PresenceRes is structure for response of presence
+type PresenceRes struct {
+	Documents []DocumentPresence `json:"documents"`
+}
+
+// DocumentPresence is structure for the users connected to the document
+type DocumentPresence struct {
+	DocumentID string    `json:"doc_id"`
+	Viewers    []Profile `json:"viewers"`
+	Editors    []Profile `json:"editors"`
+}
+
+// DocumentContentPatchReq is structure for request of document content patch
+type DocumentContentPatchReq struct {
+	Operation []interface{} `json:"operation" binding:"required"`
+}
+
+// DocumentContentRes is structure for response of document content update
+type DocumentContentRes struct {
+	Revision int `json:"revision"`
+}
+
+// DocumentLinksRes is structure for response of links or backlinks
+type DocumentLinksRes struct {
+	Links []DocumentLink `json:"links"`
+}
+
+// DocumentLink is structure for a link between documents. Text is the title written in the wiki link.
+type DocumentLink struct {
+	DocID  string `json:"doc_id"`
+	Title  string `json:"title"`
+	Text   string `json:"text"`
+	Broken bool   `json:"broken"`
+}
+
+// BrokenLinksRes is structure for response of the links broken by moving or deleting the document
+type BrokenLinksRes struct {
+	BrokenLinks []DocumentLink `json:"broken_links"`
+}
+
+// DocumentOutlineRes is structure for response of document outline
+type DocumentOutlineRes struct {
+	Revision int               `json:"revision"`
+	Headings []DocumentHeading `json:"headings"`
+}
+
+// DocumentHeading is structure for a heading. Offsets are in UTF-16 units.
+type DocumentHeading struct {
+	Level  int    `json:"level"`
+	Text   string `json:"text"`
+	Anchor string `json:"anchor"`
+	Line   int    `json:"line"`
+	Offset int    `json:"offset"`
+	End    int    `json:"end"`
+}
+
+// DocumentAnchorRes is structure for response of anchor resolution. AnchorRevision is the revision which has the anchor.
+type DocumentAnchorRes struct {
+	Revision       int             `json:"revision"`
+	AnchorRevision int             `json:"anchor_revision"`
+	Heading        DocumentHeading `json:"heading"`
+}
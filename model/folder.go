@@ -20,11 +20,37 @@ type Folder struct {
 	ParentFolderID string  `json:"parentfolderid"`
 }
 
+// FolderTree is structure for folder tree
+type FolderTree struct {
+	FolderID   string               `json:"folder_id"`
+	Name       string               `json:"name"`
+	Permission int                  `json:"permission"`
+	UpdatedAt  int64                `json:"updated_at"`
+	Editable   bool                 `json:"editable"`
+	Folders    []*FolderTree        `json:"folders"`
+	Documents  []FolderTreeDocument `json:"documents"`
+}
+
+// FolderTreeDocument is structure for document in folder tree
+type FolderTreeDocument struct {
+	DocumentID string `json:"doc_id"`
+	Title      string `json:"title"`
+	Permission int    `json:"permission"`
+	UpdatedAt  int64  `json:"updated_at"`
+	Editable   bool   `json:"editable"`
+}
+
 // CreateFolderRes is structure for response of creation folder
 type CreateFolderRes struct {
 	FolderID string `json:"folder_id"`
 }
 
+// CopyFolderRes is structure for response of folder copy. IDMap is the map of the original folder/document IDs to the copied IDs.
+type CopyFolderRes struct {
+	FolderID string            `json:"folder_id"`
+	IDMap    map[string]string `json:"id_map"`
+}
+
 // FolderModifyReqModel is structure for request folder info modification
 type FolderModifyReqModel struct {
 	OwnerUUID  string `json:"owneruuid"`
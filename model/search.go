@@ -11,3 +11,9 @@ type SearchTeamRes struct {
 	Total int       `json:"total"`
 	Teams []Profile `json:"teams"`
 }
+
+//SearchDocumentRes model
+type SearchDocumentRes struct {
+	Total     int        `json:"total"`
+	Documents []Document `json:"documents"`
+}
@@ -0,0 +1,25 @@
+package model
+
+// Tag is structure for tag info
+type Tag struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Team  string `json:"team"`
+	Count int    `json:"count"`
+}
+
+// TagListRes is structure for response of tag list
+type TagListRes struct {
+	Tags []Tag `json:"tags"`
+}
+
+// TagReq is structure for request of tag creation and modification. Empty team means instance tag.
+type TagReq struct {
+	Name string `json:"name" binding:"required"`
+	Team string `json:"team"`
+}
+
+// TagRes is structure for response of tag creation
+type TagRes struct {
+	TagID int `json:"tag_id"`
+}
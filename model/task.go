@@ -0,0 +1,24 @@
+package model
+
+// Task is structure for task item in the document
+type Task struct {
+	DocID     string    `json:"doc_id"`
+	DocTitle  string    `json:"doc_title"`
+	Line      int       `json:"line"`
+	Text      string    `json:"text"`
+	Done      bool      `json:"done"`
+	Due       string    `json:"due"`
+	Assignees []Profile `json:"assignees"`
+}
+
+// TaskListRes is structure for response of task list
+type TaskListRes struct {
+	Total int    `json:"total"`
+	Tasks []Task `json:"tasks"`
+}
+
+// TaskUpdateReq is structure for request to check or uncheck the task. Text is used to find the task if the line is moved.
+type TaskUpdateReq struct {
+	Done *bool  `json:"done" binding:"required"`
+	Text string `json:"text"`
+}
@@ -0,0 +1,38 @@
+package model
+
+// Template is structure for template info
+type Template struct {
+	ID          string   `json:"id"`
+	Type        string   `json:"type"`
+	Team        *Profile `json:"team"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	CreatedAt   int64    `json:"created_at"`
+	Creator     Profile  `json:"creator"`
+}
+
+// TemplateListRes is structure for response of template list
+type TemplateListRes struct {
+	Templates []Template `json:"templates"`
+}
+
+// TemplateReq is structure for request to mark as the template. Empty team means instance template.
+type TemplateReq struct {
+	ID          string `json:"id" binding:"required"`
+	Team        string `json:"team"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// TemplateInstantiateReq is structure for request to create the document or folder from the template
+type TemplateInstantiateReq struct {
+	FolderID string            `json:"folder_id" binding:"required"`
+	Title    string            `json:"title"`
+	Fields   map[string]string `json:"fields"`
+}
+
+// TemplateInstantiateRes is structure for response of template instantiation
+type TemplateInstantiateRes struct {
+	DocumentID string `json:"doc_id,omitempty"`
+	FolderID   string `json:"folder_id,omitempty"`
+}
@@ -0,0 +1,124 @@
+package ot
+
+import (
+	"errors"
+	"unicode/utf16"
+
+	"github.com/wonder-wonder/cakemix-server/db"
+)
+
+// Blame is attribution spans of the text. Each span holds who inserted the range and when.
+type Blame []db.BlameSpan
+
+// LineBlame is attribution of the line
+type LineBlame struct {
+	UUID string
+	Time int64
+}
+
+// NewBlame generates the blame which attributes whole text to the user
+func NewBlame(length int, uuid string, t int64) Blame {
+	if length == 0 {
+		return Blame{}
+	}
+	return Blame{{Len: length, UUID: uuid, Time: t}}
+}
+
+// Len returns total length of the spans
+func (b Blame) Len() int {
+	l := 0
+	for _, v := range b {
+		l += v.Len
+	}
+	return l
+}
+
+func (b *Blame) push(span db.BlameSpan) {
+	if span.Len == 0 {
+		return
+	}
+	if l := len(*b); l > 0 && (*b)[l-1].UUID == span.UUID && (*b)[l-1].Time == span.Time {
+		(*b)[l-1].Len += span.Len
+		return
+	}
+	*b = append(*b, span)
+}
+
+// Apply transforms the spans by the operation. Inserted text is attributed to the user.
+func (b Blame) Apply(ops Ops, uuid string, t int64) (Blame, error) {
+	res := Blame{}
+	i := 0
+	// Remaining length of current span
+	remain := 0
+	if len(b) > 0 {
+		remain = b[0].Len
+	}
+	for _, op := range ops.Ops {
+		if op.OpType == OpTypeInsert {
+			res.push(db.BlameSpan{Len: op.Len, UUID: uuid, Time: t})
+			continue
+		}
+		cur := op.Len
+		for cur > 0 {
+			if i >= len(b) {
+				return nil, errors.New("Operation is inconsistent with blame")
+			}
+			n := cur
+			if remain < n {
+				n = remain
+			}
+			if op.OpType == OpTypeRetain {
+				res.push(db.BlameSpan{Len: n, UUID: b[i].UUID, Time: b[i].Time})
+			}
+			cur -= n
+			remain -= n
+			if remain == 0 {
+				i++
+				if i < len(b) {
+					remain = b[i].Len
+				}
+			}
+		}
+	}
+	if i < len(b) {
+		return nil, errors.New("Operation is inconsistent with blame")
+	}
+	return res, nil
+}
+
+// Lines returns the attribution of each line of the text.
+// The author of the line is the user who wrote the most characters (including the line break), and the time is the latest one.
+func (b Blame) Lines(text string) []LineBlame {
+	trune := utf16.Encode([]rune(text))
+	res := []LineBlame{}
+	count := map[string]int{}
+	line := LineBlame{}
+	flush := func() {
+		max := 0
+		for k, v := range count {
+			if v > max || (v == max && k < line.UUID) {
+				max = v
+				line.UUID = k
+			}
+		}
+		res = append(res, line)
+		count = map[string]int{}
+		line = LineBlame{}
+	}
+	pos := 0
+	for _, span := range b {
+		for j := 0; j < span.Len && pos < len(trune); j++ {
+			count[span.UUID]++
+			if span.Time > line.Time {
+				line.Time = span.Time
+			}
+			if trune[pos] == '\n' {
+				flush()
+			}
+			pos++
+		}
+	}
+	// Last line (it may be empty)
+	flush()
+	return res
+}
@@ -0,0 +1,124 @@
+package ot
+
+import "unicode/utf16"
+
+// Max edit distance to compute the detailed difference.
+// If the difference is larger than it, the changed part is replaced at once.
+const maxDiffDistance = 1000
+
+// Diff computes the operation which converts base text into target text
+func Diff(base, target string) Ops {
+	a := []rune(base)
+	b := []rune(target)
+
+	// Trim common prefix and suffix
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	ops := Ops{Ops: []Op{}}
+	ops.add(OpTypeRetain, a[:pre])
+	for _, v := range diffRunes(a[pre:len(a)-suf], b[pre:len(b)-suf]) {
+		ops.add(v.OpType, []rune(v.Text))
+	}
+	ops.add(OpTypeRetain, a[len(a)-suf:])
+	return ops
+}
+
+// add appends the operation for the runes
+func (ops *Ops) add(t OpType, r []rune) {
+	op := Op{OpType: t, Len: len(utf16.Encode(r))}
+	if t == OpTypeInsert {
+		op.Text = string(r)
+	}
+	ops.push(op)
+}
+
+// diffRunes computes the shortest edit script by Myers' algorithm.
+// Text of the result holds the runes of each operation (including retain and delete).
+func diffRunes(a, b []rune) []Op {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+	replace := []Op{{OpType: OpTypeDelete, Text: string(a)}, {OpType: OpTypeInsert, Text: string(b)}}
+	if n == 0 || m == 0 {
+		return replace
+	}
+
+	max := n + m
+	if max > maxDiffDistance {
+		max = maxDiffDistance
+	}
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	trace := [][]int{}
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		vc := make([]int, len(v))
+		copy(vc, v)
+		trace = append(trace, vc)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+	// Too different
+	if !found {
+		return replace
+	}
+
+	// Backtrack the path
+	res := []Op{}
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		tv := trace[d]
+		k := x - y
+		var prevk int
+		if k == -d || (k != d && tv[offset+k-1] < tv[offset+k+1]) {
+			prevk = k + 1
+		} else {
+			prevk = k - 1
+		}
+		prevx := tv[offset+prevk]
+		prevy := prevx - prevk
+		for x > prevx && y > prevy {
+			x--
+			y--
+			res = append(res, Op{OpType: OpTypeRetain, Text: string(a[x])})
+		}
+		if d > 0 {
+			if x == prevx {
+				y--
+				res = append(res, Op{OpType: OpTypeInsert, Text: string(b[y])})
+			} else {
+				x--
+				res = append(res, Op{OpType: OpTypeDelete, Text: string(a[x])})
+			}
+		}
+	}
+	// Reverse
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return res
+}
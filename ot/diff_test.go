@@ -0,0 +1,104 @@
+package ot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		base   string
+		target string
+		ops    []Op
+	}{
+		{
+			name:   "Same",
+			base:   "abc",
+			target: "abc",
+			ops:    []Op{{OpType: OpTypeRetain, Len: 3}},
+		},
+		{
+			name:   "Insert",
+			base:   "abc",
+			target: "abxc",
+			ops:    []Op{{OpType: OpTypeRetain, Len: 2}, {OpType: OpTypeInsert, Len: 1, Text: "x"}, {OpType: OpTypeRetain, Len: 1}},
+		},
+		{
+			name:   "Delete",
+			base:   "abc",
+			target: "ac",
+			ops:    []Op{{OpType: OpTypeRetain, Len: 1}, {OpType: OpTypeDelete, Len: 1}, {OpType: OpTypeRetain, Len: 1}},
+		},
+		{
+			name:   "Empty",
+			base:   "",
+			target: "abc",
+			ops:    []Op{{OpType: OpTypeInsert, Len: 3, Text: "abc"}},
+		},
+		{
+			name:   "InsertSurrogatePair",
+			base:   "a😀b",
+			target: "a😀😺b",
+			ops:    []Op{{OpType: OpTypeRetain, Len: 3}, {OpType: OpTypeInsert, Len: 2, Text: "😺"}, {OpType: OpTypeRetain, Len: 1}},
+		},
+		{
+			name:   "DeleteSurrogatePair",
+			base:   "a😀b😺",
+			target: "ab😺",
+			ops:    []Op{{OpType: OpTypeRetain, Len: 1}, {OpType: OpTypeDelete, Len: 2}, {OpType: OpTypeRetain, Len: 3}},
+		},
+		{
+			name:   "ReplaceSurrogatePair",
+			base:   "😀",
+			target: "😺",
+			ops:    []Op{{OpType: OpTypeDelete, Len: 2}, {OpType: OpTypeInsert, Len: 2, Text: "😺"}},
+		},
+		{
+			name:   "Middle",
+			base:   "the quick brown fox",
+			target: "the slow brown dog",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops := Diff(tt.base, tt.target)
+			res, err := applyOps(tt.base, ops)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			assert.Equal(t, tt.target, res)
+			if tt.ops != nil {
+				assert.Equal(t, tt.ops, ops.Ops)
+			}
+		})
+	}
+}
+
+func TestDiffMaxDistance(t *testing.T) {
+	// Every other character is changed, so the edit distance is 2 * changed characters
+	small := strings.Repeat("ab", maxDiffDistance/4)
+	smallTarget := strings.Repeat("ac", maxDiffDistance/4)
+	ops := Diff(small, smallTarget)
+	res, err := applyOps(small, ops)
+	assert.NoError(t, err)
+	assert.Equal(t, smallTarget, res)
+	// Common characters are retained
+	assert.Greater(t, len(ops.Ops), 2)
+
+	// Too different text is replaced at once (common prefix "xa" and suffix are retained)
+	large := "x" + strings.Repeat("ab", maxDiffDistance) + "😀"
+	largeTarget := "x" + strings.Repeat("ac", maxDiffDistance) + "😀"
+	ops = Diff(large, largeTarget)
+	res, err = applyOps(large, ops)
+	assert.NoError(t, err)
+	assert.Equal(t, largeTarget, res)
+	assert.Equal(t, []Op{
+		{OpType: OpTypeRetain, Len: 2},
+		{OpType: OpTypeDelete, Len: 2*maxDiffDistance - 1},
+		{OpType: OpTypeInsert, Len: 2*maxDiffDistance - 1, Text: largeTarget[2 : len(largeTarget)-len("😀")]},
+		{OpType: OpTypeRetain, Len: 2},
+	}, ops.Ops)
+}
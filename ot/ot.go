@@ -29,16 +29,32 @@ type Ops struct {
 	Ops  []Op
 }
 
+// Max number of text snapshots kept for rebasing stale operations
+const maxSnapshots = 16
+
+// OT Errors
+var (
+	ErrorRevisionNotInHistory = errors.New("Revision is not in history")
+	ErrorSnapshotNotFound     = errors.New("Snapshot for the revision is not found")
+	ErrorDocumentTooLarge     = errors.New("Document is too large")
+	ErrorNotComposable        = errors.New("Operations are not composable")
+	ErrorSessionRestarted     = errors.New("Session is restarted after the revision")
+)
+
 // OT is structure for OT session
 type OT struct {
 	Text     string
 	History  map[int]Ops
 	Revision int
+	// Snapshots holds texts of some revisions to rebuild the text after history is removed
+	Snapshots map[int]string
+	// MaxLength is max text length in UTF-16 units (0 is unlimited)
+	MaxLength int
 }
 
 // NewOT creates OT
 func NewOT(text string) *OT {
-	return &OT{Text: text, Revision: 0, History: map[int]Ops{}}
+	return &OT{Text: text, Revision: 0, History: map[int]Ops{}, Snapshots: map[int]string{0: text}}
 }
 
 // Transform converts OT operations
@@ -55,7 +71,7 @@ func (ot *OT) Transform(rev int, ops Ops) (Ops, error) {
 	for i := rev; i < ot.Revision; i++ {
 		h, ok := ot.History[i]
 		if !ok {
-			return Ops{}, errors.New("Revision is not in history")
+			return Ops{}, ErrorRevisionNotInHistory
 		}
 		//temporary new ops
 		tops := Ops{User: ret.User}
@@ -148,23 +164,230 @@ func (ot *OT) Operate(rev int, ops Ops) (Ops, error) {
 	if err != nil {
 		return Ops{}, err
 	}
+	text, err := applyOps(ot.Text, opstrans)
+	if err != nil {
+		return Ops{}, err
+	}
+	// Reject only the operation which makes the text longer
+	if ot.MaxLength > 0 {
+		newlen := len(utf16.Encode([]rune(text)))
+		if newlen > ot.MaxLength && newlen > len(utf16.Encode([]rune(ot.Text))) {
+			return Ops{}, ErrorDocumentTooLarge
+		}
+	}
+	ot.Text = text
+	ot.History[ot.Revision] = opstrans
+	ot.Revision++
+	return opstrans, nil
+}
+
+// applyOps applies the operation to the text
+func applyOps(text string, ops Ops) (string, error) {
 	loc := 0
-	trune := utf16.Encode([]rune(ot.Text))
-	for _, v := range opstrans.Ops {
+	trune := utf16.Encode([]rune(text))
+	for _, v := range ops.Ops {
 		if v.OpType == OpTypeRetain {
 			loc += v.Len
 		} else if v.OpType == OpTypeInsert {
 			trune = append(trune[:loc], append(utf16.Encode([]rune(v.Text)), trune[loc:]...)...)
 			loc += v.Len
 		} else if v.OpType == OpTypeDelete {
+			if v.Len+loc > len(trune) {
+				return "", errors.New("Operation is inconsistent (delete is out of range)")
+			}
 			trune = append(trune[:loc], trune[v.Len+loc:]...)
 		}
+		if loc > len(trune) {
+			return "", errors.New("Operation is inconsistent (retain is out of range)")
+		}
+	}
+	if loc != len(trune) {
+		return "", errors.New("Operation is inconsistent (total text len is not match)")
+	}
+	return string(utf16.Decode(trune)), nil
+}
+
+// Snapshot stores current text as the snapshot of current revision
+func (ot *OT) Snapshot() {
+	ot.addSnapshot(ot.Revision, ot.Text)
+}
+
+func (ot *OT) addSnapshot(rev int, text string) {
+	ot.Snapshots[rev] = text
+	// Remove the oldest one
+	if len(ot.Snapshots) > maxSnapshots {
+		oldest := rev
+		for i := range ot.Snapshots {
+			if i < oldest {
+				oldest = i
+			}
+		}
+		delete(ot.Snapshots, oldest)
+	}
+}
+
+// TextAt rebuilds the text of the revision from the snapshot and the history
+func (ot *OT) TextAt(rev int) (string, error) {
+	if rev < 0 || rev > ot.Revision {
+		return "", fmt.Errorf("Revision is out of range")
+	}
+	if rev == ot.Revision {
+		return ot.Text, nil
+	}
+	// Find the latest snapshot which history is left until the revision
+	base := -1
+	for i := range ot.Snapshots {
+		if i > rev || i <= base {
+			continue
+		}
+		ok := true
+		for j := i; j < rev; j++ {
+			if _, ok = ot.History[j]; !ok {
+				break
+			}
+		}
+		if ok {
+			base = i
+		}
+	}
+	if base < 0 {
+		return "", ErrorSnapshotNotFound
+	}
+	text := ot.Snapshots[base]
+	for i := base; i < rev; i++ {
+		var err error
+		text, err = applyOps(text, ot.History[i])
+		if err != nil {
+			return "", err
+		}
+	}
+	return text, nil
+}
+
+// PruneHistory removes the history before the revision. The text of the revision is kept as snapshot.
+func (ot *OT) PruneHistory(rev int) {
+	if rev <= 0 || rev > ot.Revision {
+		return
+	}
+	if _, ok := ot.Snapshots[rev]; !ok {
+		text, err := ot.TextAt(rev)
+		if err == nil {
+			ot.addSnapshot(rev, text)
+		}
+	}
+	for i := ot.Revision - len(ot.History); i < rev; i++ {
+		delete(ot.History, i)
+	}
+}
+
+// Rebase converts the operation based on the revision which is not in history into the operation for current text.
+// It rebuilds the base text from the snapshot and merges the operation with the difference between the base text and current text (three-way merge).
+func (ot *OT) Rebase(rev int, ops Ops) (Ops, error) {
+	base, err := ot.TextAt(rev)
+	if err != nil {
+		return Ops{}, err
+	}
+	// Check the operation is for the base text
+	_, err = applyOps(base, ops)
+	if err != nil {
+		return Ops{}, err
+	}
+	merge := &OT{Text: base, History: map[int]Ops{0: Diff(base, ot.Text)}, Revision: 1}
+	return merge.Transform(0, ops)
+}
+
+// push appends the operation. Same type operation is merged.
+func (ops *Ops) push(op Op) {
+	if op.Len == 0 {
+		return
+	}
+	if l := len(ops.Ops); l > 0 && ops.Ops[l-1].OpType == op.OpType {
+		ops.Ops[l-1].Len += op.Len
+		ops.Ops[l-1].Text += op.Text
+		return
+	}
+	ops.Ops = append(ops.Ops, op)
+}
+
+// splitOp splits the operation at n (UTF-16 units)
+func splitOp(op Op, n int) (Op, Op) {
+	first := Op{OpType: op.OpType, Len: n}
+	rest := Op{OpType: op.OpType, Len: op.Len - n}
+	if op.OpType == OpTypeInsert {
+		u := utf16.Encode([]rune(op.Text))
+		first.Text = string(utf16.Decode(u[:n]))
+		rest.Text = string(utf16.Decode(u[n:]))
+	}
+	return first, rest
+}
+
+// Compose combines the operation and next operation which is applied after it into one operation
+func (ops Ops) Compose(next Ops) (Ops, error) {
+	res := Ops{User: ops.User, Ops: []Op{}}
+	a := append([]Op{}, ops.Ops...)
+	b := append([]Op{}, next.Ops...)
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		// Deleted text is not seen from next operation
+		if i < len(a) && a[i].OpType == OpTypeDelete {
+			res.push(a[i])
+			i++
+			continue
+		}
+		// Inserted text by next operation is not seen from the operation
+		if j < len(b) && b[j].OpType == OpTypeInsert {
+			res.push(b[j])
+			j++
+			continue
+		}
+		if i >= len(a) || j >= len(b) {
+			return Ops{}, ErrorNotComposable
+		}
+		n := a[i].Len
+		if b[j].Len < n {
+			n = b[j].Len
+		}
+		var opa, opb Op
+		opa, a[i] = splitOp(a[i], n)
+		opb, b[j] = splitOp(b[j], n)
+		if opb.OpType == OpTypeRetain {
+			// Keep retain or insert
+			res.push(opa)
+		} else if opa.OpType == OpTypeRetain {
+			// Delete retained text (inserted text is just canceled)
+			res.push(opb)
+		}
+		if a[i].Len == 0 {
+			i++
+		}
+		if b[j].Len == 0 {
+			j++
+		}
+	}
+	return res, nil
+}
+
+// Invert generates the operation which reverts the operation. text is the text before the operation is applied.
+func (ops Ops) Invert(text string) (Ops, error) {
+	trune := utf16.Encode([]rune(text))
+	res := Ops{User: ops.User, Ops: []Op{}}
+	loc := 0
+	for _, v := range ops.Ops {
+		if v.OpType == OpTypeRetain {
+			res.push(Op{OpType: OpTypeRetain, Len: v.Len})
+			loc += v.Len
+		} else if v.OpType == OpTypeInsert {
+			res.push(Op{OpType: OpTypeDelete, Len: v.Len})
+		} else if v.OpType == OpTypeDelete {
+			if loc+v.Len > len(trune) {
+				return Ops{}, errors.New("Operation is inconsistent (delete is out of range)")
+			}
+			res.push(Op{OpType: OpTypeInsert, Len: v.Len, Text: string(utf16.Decode(trune[loc : loc+v.Len]))})
+			loc += v.Len
+		}
 	}
 	if loc != len(trune) {
 		return Ops{}, errors.New("Operation is inconsistent (total text len is not match)")
 	}
-	ot.Text = string(utf16.Decode(trune))
-	ot.History[ot.Revision] = opstrans
-	ot.Revision++
-	return opstrans, nil
+	return res, nil
 }
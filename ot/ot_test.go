@@ -0,0 +1,185 @@
+package ot
+
+import (
+	"math/rand"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestOT returns OT which applied the operations and removed the history (snapshots of revision 0 and current are kept)
+func newTestOT(t *testing.T, text string, ops ...Ops) *OT {
+	o := NewOT(text)
+	for _, v := range ops {
+		_, err := o.Operate(o.Revision, v)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+	o.PruneHistory(o.Revision)
+	return o
+}
+
+func TestRebase(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		// Operations by others after revision 0
+		hist []Ops
+		// Stale operation based on revision 0
+		ops  Ops
+		want string
+	}{
+		{
+			name: "InsertBeforeAndAfter",
+			text: "hello world",
+			hist: []Ops{{Ops: []Op{{OpType: OpTypeInsert, Len: 1, Text: "X"}, {OpType: OpTypeRetain, Len: 11}}}},
+			ops:  Ops{Ops: []Op{{OpType: OpTypeRetain, Len: 11}, {OpType: OpTypeInsert, Len: 1, Text: "!"}}},
+			want: "Xhello world!",
+		},
+		{
+			name: "ConcurrentDelete",
+			text: "hello world",
+			hist: []Ops{
+				{Ops: []Op{{OpType: OpTypeRetain, Len: 5}, {OpType: OpTypeDelete, Len: 6}}},
+				{Ops: []Op{{OpType: OpTypeInsert, Len: 3, Text: "oh "}, {OpType: OpTypeRetain, Len: 5}}},
+			},
+			ops:  Ops{Ops: []Op{{OpType: OpTypeRetain, Len: 5}, {OpType: OpTypeInsert, Len: 1, Text: ","}, {OpType: OpTypeRetain, Len: 6}}},
+			want: "oh hello,",
+		},
+		{
+			name: "DeleteEditedText",
+			text: "abcdef",
+			hist: []Ops{{Ops: []Op{{OpType: OpTypeRetain, Len: 2}, {OpType: OpTypeInsert, Len: 1, Text: "X"}, {OpType: OpTypeRetain, Len: 4}}}},
+			ops:  Ops{Ops: []Op{{OpType: OpTypeRetain, Len: 1}, {OpType: OpTypeDelete, Len: 4}, {OpType: OpTypeRetain, Len: 1}}},
+			want: "aXf",
+		},
+		{
+			name: "SurrogatePair",
+			text: "😀a",
+			hist: []Ops{{Ops: []Op{{OpType: OpTypeInsert, Len: 2, Text: "😺"}, {OpType: OpTypeRetain, Len: 3}}}},
+			ops:  Ops{Ops: []Op{{OpType: OpTypeRetain, Len: 2}, {OpType: OpTypeDelete, Len: 1}}},
+			want: "😺😀",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newTestOT(t, tt.text, tt.hist...)
+			_, err := o.Transform(0, tt.ops)
+			assert.Equal(t, ErrorRevisionNotInHistory, err)
+
+			ops, err := o.Rebase(0, tt.ops)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			_, err = o.Operate(o.Revision, ops)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			assert.Equal(t, tt.want, o.Text)
+		})
+	}
+}
+
+func TestRebaseError(t *testing.T) {
+	o := newTestOT(t, "abc", Ops{Ops: []Op{{OpType: OpTypeRetain, Len: 3}, {OpType: OpTypeInsert, Len: 1, Text: "d"}}})
+
+	// Operation which doesn't match the base text
+	_, err := o.Rebase(0, Ops{Ops: []Op{{OpType: OpTypeRetain, Len: 4}}})
+	assert.Error(t, err)
+
+	// Snapshot is removed
+	delete(o.Snapshots, 0)
+	_, err = o.Rebase(0, Ops{Ops: []Op{{OpType: OpTypeRetain, Len: 3}}})
+	assert.Equal(t, ErrorSnapshotNotFound, err)
+}
+
+// randomText returns the text which includes surrogate pairs
+func randomText(r *rand.Rand, n int) string {
+	chars := []rune("ab c\n😀😺あ")
+	res := make([]rune, n)
+	for i := range res {
+		res[i] = chars[r.Intn(len(chars))]
+	}
+	return string(res)
+}
+
+// randomOps returns the operation for the text. Lengths don't split surrogate pairs.
+func randomOps(r *rand.Rand, text string) Ops {
+	ops := Ops{Ops: []Op{}}
+	for _, c := range text {
+		if r.Intn(4) == 0 {
+			ops.add(OpTypeInsert, []rune(randomText(r, 1+r.Intn(3))))
+		}
+		l := len(utf16.Encode([]rune{c}))
+		if r.Intn(3) == 0 {
+			ops.push(Op{OpType: OpTypeDelete, Len: l})
+		} else {
+			ops.push(Op{OpType: OpTypeRetain, Len: l})
+		}
+	}
+	if r.Intn(2) == 0 {
+		ops.add(OpTypeInsert, []rune(randomText(r, 1+r.Intn(3))))
+	}
+	return ops
+}
+
+func TestComposeProperty(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		text := randomText(r, r.Intn(20))
+		a := randomOps(r, text)
+		ta, err := applyOps(text, a)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		b := randomOps(r, ta)
+		tab, err := applyOps(ta, b)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		ab, err := a.Compose(b)
+		if !assert.NoError(t, err, "compose %v and %v", a, b) {
+			t.FailNow()
+		}
+		res, err := applyOps(text, ab)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		if !assert.Equal(t, tab, res, "text %q, a %v, b %v", text, a, b) {
+			t.FailNow()
+		}
+	}
+}
+
+func TestComposeError(t *testing.T) {
+	a := Ops{Ops: []Op{{OpType: OpTypeRetain, Len: 3}}}
+	b := Ops{Ops: []Op{{OpType: OpTypeRetain, Len: 4}}}
+	_, err := a.Compose(b)
+	assert.Equal(t, ErrorNotComposable, err)
+}
+
+func TestInvertProperty(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 500; i++ {
+		text := randomText(r, r.Intn(20))
+		a := randomOps(r, text)
+		ta, err := applyOps(text, a)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		inv, err := a.Invert(text)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		res, err := applyOps(ta, inv)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		if !assert.Equal(t, text, res, "text %q, a %v", text, a) {
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package ot
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wonder-wonder/cakemix-server/db"
+	"github.com/wonder-wonder/cakemix-server/util"
+)
+
+// Number of chat messages sent to the client on join
+const maxChatHistory = 50
+
+// Max length of the chat message (in characters)
+const maxChatLength = 2000
+
+// loadChat loads recent chat messages from DB
+func (sv *Server) loadChat() error {
+	msgs, err := sv.db.GetDocumentChat(sv.docID, 0, maxChatHistory)
+	if err != nil {
+		return err
+	}
+	profiles := map[string]db.Profile{}
+	sv.chat = []ChatData{}
+	// Newest first
+	for i := len(msgs) - 1; i >= 0; i-- {
+		m := msgs[i]
+		p, ok := profiles[m.UserUUID]
+		if !ok {
+			p, err = sv.db.GetProfileByUUID(m.UserUUID)
+			if err != nil && err != db.ErrUserTeamNotFound {
+				return err
+			}
+			profiles[m.UserUUID] = p
+		}
+		sv.chat = append(sv.chat, ChatData{
+			ID:      m.ID,
+			UUID:    m.UserUUID,
+			Name:    p.Name,
+			IconURI: p.IconURI,
+			Message: m.Message,
+			Date:    m.Date,
+		})
+	}
+	return nil
+}
+
+// chatHistory returns a copy of recent chat messages
+func (sv *Server) chatHistory() []ChatData {
+	res := make([]ChatData, len(sv.chat))
+	copy(res, sv.chat)
+	return res
+}
+
+// postChat stores the chat message and broadcasts it to all clients including the sender
+func (sv *Server) postChat(clientID string, dat ChatReqData) {
+	cl, ok := sv.clients[clientID]
+	if !ok {
+		return
+	}
+	msg := strings.TrimSpace(dat.Message)
+	if msg == "" {
+		sv.sendError(cl, ErrorCodeInvalidChat, errors.New("message is empty"))
+		return
+	}
+	if utf8.RuneCountInString(msg) > maxChatLength {
+		sv.sendError(cl, ErrorCodeInvalidChat, errors.New("message is too long"))
+		return
+	}
+	c, err := sv.db.AddDocumentChat(sv.docID, cl.profile.UUID, msg)
+	if err != nil {
+		util.Errorf("Session(%s) chat error: %v", sv.docID, err)
+		sv.sendError(cl, ErrorCodeInvalidChat, errors.New("failed to save message"))
+		return
+	}
+	chat := ChatData{
+		ID:       c.ID,
+		ClientID: clientID,
+		UUID:     cl.profile.UUID,
+		Name:     cl.profile.Name,
+		IconURI:  cl.profile.IconURI,
+		Message:  c.Message,
+		Date:     c.Date,
+	}
+	sv.chat = append(sv.chat, chat)
+	if len(sv.chat) > maxChatHistory {
+		sv.chat = sv.chat[len(sv.chat)-maxChatHistory:]
+	}
+	sv.broadcast("", otWSMessage{
+		Event: WSMsgTypeChat,
+		Data:  chat,
+	})
+}
@@ -0,0 +1,242 @@
+package ot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf16"
+
+	"github.com/wonder-wonder/cakemix-server/db"
+)
+
+// Content errors
+var (
+	ErrorSessionBusy      = errors.New("OT session is starting or stopping")
+	ErrorInvalidOperation = errors.New("Operation is invalid")
+	errorNotRunning       = errors.New("OT session is not running")
+)
+
+// ContentChange builds the operation from current text
+type ContentChange func(text string) (Ops, error)
+
+// ReplaceText returns the change which replaces whole text. Only the difference is applied.
+func ReplaceText(text string) ContentChange {
+	return func(cur string) (Ops, error) {
+		return Diff(cur, text), nil
+	}
+}
+
+// ApplyOperation returns the change which applies the operation in websocket message format
+func ApplyOperation(raw []interface{}) ContentChange {
+	return func(cur string) (Ops, error) {
+		ops := rawToOps("", raw)
+		_, err := applyOps(cur, ops)
+		if err != nil {
+			return Ops{}, fmt.Errorf("%w: %v", ErrorInvalidOperation, err)
+		}
+		return ops, nil
+	}
+}
+
+type otContentQuery struct {
+	docID string
+	// Request forwarded to the server. The error is replied if it can't be forwarded.
+	request *otContentRequest
+	reply   chan error
+}
+
+type otContentRequest struct {
+	uuid        string
+	baseVersion string        // Empty means no check
+	change      ContentChange // nil means read only
+	res         chan otContentResult
+}
+
+type otContentResult struct {
+	text    string
+	version string
+	rev     int
+	err     error
+}
+
+// forwardContent forwards the request to the running server.
+// If no session is running, the slot of the document is held for the update until otServerRequestTypeContentSaved
+// so that a session isn't started with the text before the update.
+func (mgr *Manager) forwardContent(q otContentQuery) {
+	svinfo, ok := mgr.sesslist[q.docID]
+	if !ok {
+		if q.request.change != nil {
+			mgr.sesslist[q.docID] = &otInfo{Status: otStatusWriting}
+		}
+		q.reply <- errorNotRunning
+		return
+	}
+	// Reading from DB doesn't need to wait the update
+	if svinfo.Status == otStatusWriting && q.request.change == nil {
+		q.reply <- errorNotRunning
+		return
+	}
+	if svinfo.Status != otStatusRunning {
+		q.reply <- ErrorSessionBusy
+		return
+	}
+	svinfo.Server.mgr2sv <- otManagerRequest{reqType: otManagerRequestTypeContent, request: q.request}
+	q.reply <- nil
+}
+
+// requestContent sends the request to the running server and waits the result.
+// If no session is running, errorNotRunning is returned.
+func (mgr *Manager) requestContent(docID string, req *otContentRequest) (otContentResult, error) {
+	req.res = make(chan otContentResult, 1)
+	q := otContentQuery{docID: docID, request: req, reply: make(chan error, 1)}
+	select {
+	case mgr.content <- q:
+	case <-mgr.done:
+		return otContentResult{}, ErrorManagerStopped
+	}
+	err := <-q.reply
+	if err != nil {
+		return otContentResult{}, err
+	}
+	return <-req.res, nil
+}
+
+// GetContent returns the latest text and its version.
+// The version is the revision if the text is saved. If the running session has unsaved changes, the text includes them
+// and the version also has the session epoch and OT revision.
+func (mgr *Manager) GetContent(docID string) (string, string, error) {
+	res, err := mgr.requestContent(docID, &otContentRequest{})
+	if err == errorNotRunning {
+		rev, err := mgr.db.GetDocumentRevision(docID, 0)
+		if err != nil {
+			return "", "", err
+		}
+		return rev.Text, strconv.Itoa(rev.Revision), nil
+	} else if err != nil {
+		return "", "", err
+	}
+	return res.text, res.version, res.err
+}
+
+// UpdateContent applies the change by the user and returns new revision.
+// If the session is running, the change is applied as the operation, otherwise it's saved to DB directly.
+// If baseVersion is not empty and it's not the current version returned by GetContent, db.ErrRevisionMismatch is returned.
+func (mgr *Manager) UpdateContent(docID string, uuid string, baseVersion string, change ContentChange) (int, error) {
+	res, err := mgr.requestContent(docID, &otContentRequest{uuid: uuid, baseVersion: baseVersion, change: change})
+	if err == errorNotRunning {
+		rev, err := mgr.saveContent(docID, uuid, baseVersion, change)
+		// Release the slot held by forwardContent
+		mgr.serverReq <- otServerRequest{docID: docID, reqType: otServerRequestTypeContentSaved}
+		return rev, err
+	} else if err != nil {
+		return 0, err
+	}
+	return res.rev, res.err
+}
+
+// saveContent applies the change to the document in DB
+func (mgr *Manager) saveContent(docID string, uuid string, baseVersion string, change ContentChange) (int, error) {
+	cur, err := mgr.db.GetDocumentRevision(docID, 0)
+	if err != nil {
+		return 0, err
+	}
+	if baseVersion != "" && baseVersion != strconv.Itoa(cur.Revision) {
+		return 0, db.ErrRevisionMismatch
+	}
+	ops, err := change(cur.Text)
+	if err != nil {
+		return 0, err
+	}
+	text, err := applyOps(cur.Text, ops)
+	if err != nil {
+		return 0, err
+	}
+	curlen := len(utf16.Encode([]rune(cur.Text)))
+	if l := len(utf16.Encode([]rune(text))); l > mgr.conf.MaxDocumentSize && l > curlen {
+		return 0, ErrorDocumentTooLarge
+	}
+	if text == cur.Text {
+		return cur.Revision, nil
+	}
+
+	// Keep attribution
+	blame, err := mgr.db.GetDocumentBlame(docID, cur.Revision)
+	if err != nil {
+		return 0, err
+	}
+	if blame == nil || Blame(blame).Len() != curlen {
+		dinfo, err := mgr.db.GetDocumentInfo(docID)
+		if err != nil {
+			return 0, err
+		}
+		blame = NewBlame(curlen, dinfo.UpdaterUUID, dinfo.UpdatedAt)
+	}
+	now := time.Now()
+	blame, err = Blame(blame).Apply(ops, uuid, now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	dops := []db.DocumentOp{{UUID: uuid, Time: now.UnixNano() / int64(time.Millisecond), Ops: opsToRaw(ops)}}
+
+	rev, err := mgr.db.SaveDocumentIfRevision(docID, cur.Revision, uuid, text, blame, dops)
+	if err != nil {
+		return 0, err
+	}
+	err = mgr.db.UpdateDocument(docID, uuid)
+	if err != nil {
+		return 0, err
+	}
+	return rev, nil
+}
+
+// contentVersion returns the version of the current text. Unsaved text is identified by the session epoch and OT revision.
+func (sv *Server) contentVersion() string {
+	if sv.needSave {
+		return strconv.Itoa(sv.savedRev) + "-" + sv.epoch + "-" + strconv.Itoa(sv.ot.Revision)
+	}
+	return strconv.Itoa(sv.savedRev)
+}
+
+// handleContent reads or updates the text by the request from REST API
+func (sv *Server) handleContent(req *otContentRequest) {
+	if req.change == nil {
+		req.res <- otContentResult{text: sv.ot.Text, version: sv.contentVersion()}
+		return
+	}
+	if req.baseVersion != "" && req.baseVersion != sv.contentVersion() {
+		req.res <- otContentResult{err: db.ErrRevisionMismatch}
+		return
+	}
+	ops, err := req.change(sv.ot.Text)
+	if err != nil {
+		req.res <- otContentResult{err: err}
+		return
+	}
+	if isNoop(ops) {
+		req.res <- otContentResult{rev: sv.savedRev}
+		return
+	}
+	optrans, err := sv.ot.Operate(sv.ot.Revision, ops)
+	if err != nil {
+		req.res <- otContentResult{err: err}
+		return
+	}
+	sv.broadcast("", otWSMessage{
+		Event: WSMsgTypeOp,
+		Data:  []interface{}{"", opsToRaw(optrans), Ranges{Ranges: []SelData{}}},
+	})
+	sv.updated(req.uuid, optrans)
+	_, err = sv.saveDoc()
+	req.res <- otContentResult{rev: sv.savedRev, err: err}
+}
+
+// isNoop returns true if the operation doesn't change the text
+func isNoop(ops Ops) bool {
+	for _, v := range ops.Ops {
+		if v.OpType != OpTypeRetain && v.Len > 0 {
+			return false
+		}
+	}
+	return true
+}
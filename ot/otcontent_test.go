@@ -0,0 +1,66 @@
+package ot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wonder-wonder/cakemix-server/db"
+)
+
+func TestForwardContent(t *testing.T) {
+	mgr, err := NewManager(nil, Config{})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	query := func(change ContentChange) error {
+		q := otContentQuery{docID: "dtest", request: &otContentRequest{change: change}, reply: make(chan error, 1)}
+		mgr.forwardContent(q)
+		return <-q.reply
+	}
+
+	// Update without session holds the slot
+	assert.Equal(t, errorNotRunning, query(ReplaceText("a")))
+	svinfo, ok := mgr.sesslist["dtest"]
+	if !assert.True(t, ok) {
+		t.FailNow()
+	}
+	assert.Equal(t, otStatusWriting, svinfo.Status)
+
+	// Reading is not blocked, but other update waits
+	assert.Equal(t, errorNotRunning, query(nil))
+	assert.Equal(t, ErrorSessionBusy, query(ReplaceText("b")))
+}
+
+func TestHandleContentRead(t *testing.T) {
+	sv := newTestServer("abc")
+	sv.savedRev = 3
+	cl := sv.addTestClient("ua", 100, nil)
+	err := sv.applyOp(cl.clientID, OpData{Revision: 0, Operation: []interface{}{float64(3), "d"}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	// Reading doesn't save (DB is nil), and unsaved text has the version of the session
+	req := &otContentRequest{res: make(chan otContentResult, 1)}
+	sv.handleContent(req)
+	res := <-req.res
+	assert.NoError(t, res.err)
+	assert.Equal(t, "abcd", res.text)
+	assert.Equal(t, "3-e1-1", res.version)
+	assert.True(t, sv.needSave)
+
+	// The version can be used for update, but the saved revision is stale
+	update := func(base string) otContentResult {
+		req := &otContentRequest{baseVersion: base, change: ReplaceText("abcd"), res: make(chan otContentResult, 1)}
+		sv.handleContent(req)
+		return <-req.res
+	}
+	assert.NoError(t, update("3-e1-1").err)
+	assert.Equal(t, db.ErrRevisionMismatch, update("3").err)
+	assert.Equal(t, db.ErrRevisionMismatch, update("3-e0-1").err)
+
+	sv.needSave = false
+	sv.handleContent(req)
+	res = <-req.res
+	assert.Equal(t, "3", res.version)
+}
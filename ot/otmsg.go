@@ -3,6 +3,7 @@ package ot
 import (
 	"encoding/json"
 	"errors"
+	"unicode/utf16"
 )
 
 // WSMsgType is WebSocket message type
@@ -18,6 +19,34 @@ const (
 	WSMsgTypeQuit
 	WSMsgTypeJoin
 	OTReqResTypePing
+	WSMsgTypeResume
+	WSMsgTypeConflict
+	WSMsgTypePermission
+	WSMsgTypeClose
+	WSMsgTypeError
+	WSMsgTypeUndo
+	WSMsgTypeRedo
+	WSMsgTypeChat
+)
+
+// Close codes sent to the client when the server disconnects it
+const (
+	CloseCodeSessionRevoked   = 4001
+	CloseCodeAccountLocked    = 4002
+	CloseCodePermissionDenied = 4003
+	CloseCodeTooSlow          = 4004
+	CloseCodeRateLimited      = 4005
+	CloseCodeMessageTooLarge  = 4006
+	CloseCodeInvalidOperation = 4007
+	CloseCodeDocumentNotFound = 4008
+	CloseCodeSessionError     = 4009
+)
+
+// Error codes which don't close the connection
+const (
+	ErrorCodeDocumentTooLarge = 4100
+	ErrorCodeUndoUnavailable  = 4101
+	ErrorCodeInvalidChat      = 4102
 )
 
 // OT Errors
@@ -97,6 +126,17 @@ func parseMsg(rawmsg []byte) (WSMsgType, interface{}, error) {
 			return WSMsgTypeUnknown, nil, ErrorInvalidWSMsg
 		}
 		return WSMsgTypeSel, dat, nil
+	} else if msg.Event == "undo" {
+		return WSMsgTypeUndo, nil, nil
+	} else if msg.Event == "redo" {
+		return WSMsgTypeRedo, nil, nil
+	} else if msg.Event == "chat" {
+		dat := ChatReqData{}
+		err = json.Unmarshal(msg.Data, &dat)
+		if err != nil {
+			return WSMsgTypeUnknown, nil, ErrorInvalidWSMsg
+		}
+		return WSMsgTypeChat, dat, nil
 		// } else if msg.Event == "ok" {
 		// 	return WSMsgTypeOK, nil, nil
 		// } else if msg.Event == "doc" {
@@ -129,6 +169,16 @@ func convertToMsg(t WSMsgType, dat interface{}) ([]byte, error) {
 		msg.Event = "quit"
 	} else if t == WSMsgTypeJoin {
 		msg.Event = "join"
+	} else if t == WSMsgTypeResume {
+		msg.Event = "resume"
+	} else if t == WSMsgTypeConflict {
+		msg.Event = "conflict"
+	} else if t == WSMsgTypePermission {
+		msg.Event = "permission"
+	} else if t == WSMsgTypeError {
+		msg.Event = "error"
+	} else if t == WSMsgTypeChat {
+		msg.Event = "chat"
 	}
 	msgraw, err := json.Marshal(msg)
 	if err != nil {
@@ -145,14 +195,17 @@ type ClientData struct {
 	Selection Ranges `json:"selection"`
 }
 
-// DocData is structure for document data
+// DocData is structure for document data. Editable is true if the client can edit the document (same as PermissionData).
 type DocData struct {
+	ID         string                `json:"id"`
 	Clients    map[string]ClientData `json:"clients"`
 	Document   string                `json:"document"`
 	Revision   int                   `json:"revision"`
+	Epoch      string                `json:"epoch"`
 	Owner      string                `json:"owner"`
 	Permission int                   `json:"permission"`
 	Editable   bool                  `json:"editable"`
+	Chat       []ChatData            `json:"chat"`
 }
 
 // ClientJoinData is structure for client information
@@ -162,3 +215,105 @@ type ClientJoinData struct {
 	UUID    string `json:"uuid"`
 	IconURI string `json:"icon_uri"`
 }
+
+// ResumeData is structure for resume request.
+// Operation is the pending (not acknowledged) operation based on Revision. Epoch is the session instance which Revision and ClientID belong to.
+type ResumeData struct {
+	ClientID  string        `json:"id"`
+	Revision  int           `json:"revision"`
+	Epoch     string        `json:"epoch"`
+	Operation []interface{} `json:"operation"`
+	Selection Ranges        `json:"selection"`
+}
+
+// ResumeResData is structure for resume response. Missed operations are sent as op events after it.
+type ResumeResData struct {
+	ID         string                `json:"id"`
+	Clients    map[string]ClientData `json:"clients"`
+	Revision   int                   `json:"revision"`
+	Epoch      string                `json:"epoch"`
+	Owner      string                `json:"owner"`
+	Permission int                   `json:"permission"`
+	Editable   bool                  `json:"editable"`
+	Chat       []ChatData            `json:"chat"`
+}
+
+// ConflictData is structure for the operation rejected by the server.
+// The client should apply it again to the document sent by following doc event.
+type ConflictData struct {
+	Revision  int           `json:"revision"`
+	Operation []interface{} `json:"operation"`
+	Selection Ranges        `json:"selection"`
+	Reason    string        `json:"reason"`
+}
+
+// PermissionData is structure for permission change notification
+type PermissionData struct {
+	Owner      string `json:"owner"`
+	Permission int    `json:"permission"`
+	Editable   bool   `json:"editable"`
+}
+
+// ErrorData is structure for error notification. Code is same as close code if the connection is closed after it.
+type ErrorData struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// ChatReqData is structure for chat message from the client
+type ChatReqData struct {
+	Message string `json:"message"`
+}
+
+// ChatData is structure for chat message. ClientID is empty if the message is loaded from the history.
+type ChatData struct {
+	ID       int64  `json:"id"`
+	ClientID string `json:"client_id"`
+	UUID     string `json:"uuid"`
+	Name     string `json:"name"`
+	IconURI  string `json:"icon_uri"`
+	Message  string `json:"message"`
+	Date     int64  `json:"date"`
+}
+
+// CloseData is structure for disconnection by the server
+type CloseData struct {
+	Code   int
+	Reason string
+}
+
+// rawToOps converts operation array of websocket message into Ops
+func rawToOps(user string, raw []interface{}) Ops {
+	ops := Ops{User: user, Ops: []Op{}}
+	for _, op := range raw {
+		switch opt := op.(type) {
+		case float64:
+			opi := int(opt)
+			if opi < 0 {
+				ops.Ops = append(ops.Ops, Op{OpType: OpTypeDelete, Len: -opi})
+			} else {
+				ops.Ops = append(ops.Ops, Op{OpType: OpTypeRetain, Len: opi})
+			}
+		case string:
+			ops.Ops = append(ops.Ops, Op{OpType: OpTypeInsert, Len: len(utf16.Encode([]rune(opt))), Text: opt})
+		default:
+			continue
+		}
+	}
+	return ops
+}
+
+// opsToRaw converts Ops into operation array of websocket message
+func opsToRaw(ops Ops) []interface{} {
+	opraw := []interface{}{}
+	for _, v := range ops.Ops {
+		if v.OpType == OpTypeRetain {
+			opraw = append(opraw, v.Len)
+		} else if v.OpType == OpTypeInsert {
+			opraw = append(opraw, v.Text)
+		} else if v.OpType == OpTypeDelete {
+			opraw = append(opraw, -v.Len)
+		}
+	}
+	return opraw
+}
@@ -0,0 +1,93 @@
+package ot
+
+import (
+	"sort"
+	"time"
+)
+
+// Max wait for the servers to reply presence
+const presenceTimeout = time.Second * 5
+
+// Presence is structure for the user connected to the session
+type Presence struct {
+	UUID     string
+	Editable bool
+}
+
+// DocumentPresence is structure for the users connected to the document
+type DocumentPresence struct {
+	DocID string
+	Users []Presence
+}
+
+type otPresenceQuery struct {
+	docIDs map[string]bool // nil means all sessions
+	reply  chan otPresenceReply
+}
+
+type otPresenceReply struct {
+	res   chan DocumentPresence
+	count int
+}
+
+// presence returns the connected users. Multiple clients of the same user are merged.
+func (sv *Server) presence() []Presence {
+	users := map[string]bool{}
+	for _, cl := range sv.clients {
+		users[cl.profile.UUID] = users[cl.profile.UUID] || cl.editable
+	}
+	res := []Presence{}
+	for uuid, editable := range users {
+		res = append(res, Presence{UUID: uuid, Editable: editable})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].UUID < res[j].UUID })
+	return res
+}
+
+// queryPresence requests presence to the running servers. The servers reply to the channel in the reply.
+func (mgr *Manager) queryPresence(q otPresenceQuery) {
+	targets := []*Server{}
+	for docID, svinfo := range mgr.sesslist {
+		if q.docIDs != nil && !q.docIDs[docID] {
+			continue
+		}
+		if svinfo.Status != otStatusRunning {
+			continue
+		}
+		targets = append(targets, svinfo.Server)
+	}
+	res := make(chan DocumentPresence, len(targets))
+	for _, sv := range targets {
+		sv.mgr2sv <- otManagerRequest{reqType: otManagerRequestTypePresence, request: res}
+	}
+	q.reply <- otPresenceReply{res: res, count: len(targets)}
+}
+
+// Presence returns the users connected to the documents. If docIDs is nil, all active documents are returned.
+// The documents without users are not included.
+func (mgr *Manager) Presence(docIDs []string) []DocumentPresence {
+	q := otPresenceQuery{reply: make(chan otPresenceReply, 1)}
+	if docIDs != nil {
+		q.docIDs = map[string]bool{}
+		for _, v := range docIDs {
+			q.docIDs[v] = true
+		}
+	}
+	mgr.presence <- q
+	r := <-q.reply
+
+	list := []DocumentPresence{}
+	timeout := time.After(presenceTimeout)
+	for i := 0; i < r.count; i++ {
+		select {
+		case p := <-r.res:
+			if len(p.Users) > 0 {
+				list = append(list, p)
+			}
+		case <-timeout:
+			i = r.count
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].DocID < list[j].DocID })
+	return list
+}
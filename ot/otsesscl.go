@@ -1,10 +1,12 @@
 package ot
 
 import (
-	"log"
+	"io"
+	"io/ioutil"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/wonder-wonder/cakemix-server/util"
 )
 
 // Client is structure for client connection
@@ -17,34 +19,57 @@ type Client struct {
 	selection []SelData
 	// User info
 	profile  ClientProfile
-	readOnly bool
+	readOnly bool // for client loop
+	editable bool // for server loop
+	resume   *ResumeData
+	// Limits
+	pingInterval    time.Duration
+	maxOpsPerSecond int
+	maxMessageSize  int
 	// Server
-	cl2sv chan otC2SMessage
-	sv2cl chan otWSMessage
+	cl2sv   chan otC2SMessage
+	sv2cl   chan otWSMessage
+	evicted chan struct{}
 }
 
 // ClientProfile is structure for client profile
 type ClientProfile struct {
-	UUID    string
-	Name    string
-	IconURI string
+	UUID      string
+	Name      string
+	IconURI   string
+	SessionID string
 }
 
 // NewClient generates OTClient
 func NewClient(conn *websocket.Conn, profile ClientProfile, readOnly bool) (*Client, error) {
 	cl := &Client{
-		conn:      conn,
-		clientID:  "",
-		lastRev:   0,
-		selection: []SelData{},
-		profile:   profile,
-		readOnly:  readOnly,
-		cl2sv:     nil,
-		sv2cl:     make(chan otWSMessage, 1000),
+		conn:            conn,
+		clientID:        "",
+		lastRev:         0,
+		selection:       []SelData{},
+		profile:         profile,
+		readOnly:        readOnly,
+		pingInterval:    time.Second * defaultClientPingInterval,
+		maxOpsPerSecond: defaultMaxOpsPerSecond,
+		maxMessageSize:  defaultMaxMessageSize,
+		cl2sv:           nil,
+		sv2cl:           make(chan otWSMessage, 1000),
+		evicted:         make(chan struct{}),
 	}
 	return cl, nil
 }
 
+// Resume requests to resume the session from the revision instead of receiving whole document.
+// It should be called before ClientConnect.
+func (cl *Client) Resume(dat ResumeData) {
+	cl.resume = &dat
+}
+
+// Close sends close message with the code and the reason. It's used when the client can't join the session.
+func (cl *Client) Close(code int, reason string) error {
+	return cl.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second*10))
+}
+
 func (cl *Client) sendC2S(msgType otC2SMessageType, message interface{}) {
 	cl.cl2sv <- otC2SMessage{
 		clientID: cl.clientID,
@@ -58,11 +83,16 @@ func (cl *Client) Loop() {
 	request := make(chan []byte)
 
 	// Ping timer
-	pingTicker := time.NewTicker(time.Second * otClientPingInterval)
+	pingTicker := time.NewTicker(cl.pingInterval)
 	defer pingTicker.Stop()
 
+	// Rate limit (token bucket which is filled maxOpsPerSecond per second)
+	tokens := float64(cl.maxOpsPerSecond)
+	lastFill := time.Now()
+
 	// Reader routine
 	readstop := make(chan struct{})
+	tooLarge := make(chan struct{})
 	defer func() { close(readstop) }()
 	go func() {
 		defer func() { close(request) }()
@@ -71,52 +101,106 @@ func (cl *Client) Loop() {
 			case <-readstop:
 				return
 			default:
-				_, msg, err := cl.conn.ReadMessage()
-				if err != nil {
-					// Closed
-					if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived, websocket.CloseAbnormalClosure) {
+				_, r, err := cl.conn.NextReader()
+				if err == nil {
+					var msg []byte
+					// Read one more byte to detect too large message
+					msg, err = ioutil.ReadAll(io.LimitReader(r, int64(cl.maxMessageSize)+1))
+					if err == nil && len(msg) > cl.maxMessageSize {
+						close(tooLarge)
 						return
 					}
-					log.Printf("OT client error: read error: %v\n", err)
+					if err == nil {
+						request <- msg
+						continue
+					}
+				}
+				// Closed
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived, websocket.CloseAbnormalClosure) {
 					return
 				}
-				request <- msg
+				util.Errorf("OT client error: read error: %v", err)
+				return
 			}
 		}
 
 	}()
 
 	sendSvResponse := func(s2cmsg otWSMessage) bool {
+		switch s2cmsg.Event {
+		case WSMsgTypeClose:
+			// Disconnected by server. Channel will be closed after it.
+			dat, _ := s2cmsg.Data.(CloseData)
+			err := cl.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(dat.Code, dat.Reason), time.Now().Add(time.Second*10))
+			if err != nil {
+				util.Errorf("OT client error: websocket error: %v", err)
+			}
+			return true
+		case WSMsgTypePermission:
+			if dat, ok := s2cmsg.Data.(PermissionData); ok {
+				cl.readOnly = !dat.Editable
+			}
+		}
 		resraw, err := convertToMsg(s2cmsg.Event, s2cmsg.Data)
 		if err != nil {
-			log.Printf("OT client error: response error: %v\n", err)
+			util.Errorf("OT client error: response error: %v", err)
 			return false
 		}
 		err = cl.conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
 		if err != nil {
-			log.Printf("OT client error: websockest error: %v\n", err)
+			util.Errorf("OT client error: websockest error: %v", err)
 			return false
 		}
 		err = cl.conn.WriteMessage(websocket.TextMessage, resraw)
 		if err != nil {
-			log.Printf("OT client error: websocket error: %v\n", err)
+			util.Errorf("OT client error: websocket error: %v", err)
 			return false
 		}
 		err = cl.conn.SetWriteDeadline(time.Time{})
 		if err != nil {
-			log.Printf("OT client error: websockest error: %v\n", err)
+			util.Errorf("OT client error: websockest error: %v", err)
 			return false
 		}
 		return true
 	}
 
+	// closeWithError sends error event and closes the connection
+	closeWithError := func(code int, reason string) {
+		util.Errorf("OT client error: %s", reason)
+		sendSvResponse(otWSMessage{Event: WSMsgTypeError, Data: ErrorData{Code: code, Message: reason}})
+		err := cl.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second*10))
+		if err != nil {
+			util.Errorf("OT client error: websocket error: %v", err)
+		}
+	}
+
+	// isEvicted sends the close code if the client is evicted by server because the buffer is full. Error event can't be sent.
+	// evicted is closed before sv2cl, so it's always detected after sv2cl is closed.
+	isEvicted := func() bool {
+		select {
+		case <-cl.evicted:
+		default:
+			return false
+		}
+		err := cl.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(CloseCodeTooSlow, "too slow"), time.Now().Add(time.Second*10))
+		if err != nil {
+			util.Errorf("OT client error: websocket error: %v", err)
+		}
+		return true
+	}
+
 main:
 	for {
+		// Eviction is checked before draining the buffered responses
+		if isEvicted() {
+			return
+		}
 		select {
 		case s2cmsg, ok := <-cl.sv2cl:
 			// Server response is high priority so check the first
 			if !ok {
-				// Closed by server and notification is not needed.
+				// Closed by server and notification is not needed unless evicted.
+				isEvicted()
 				return
 			}
 			if !sendSvResponse(s2cmsg) {
@@ -126,25 +210,32 @@ main:
 		}
 		// If no server response, check all response including server response
 		select {
+		case <-cl.evicted:
+			isEvicted()
+			return
+		case <-tooLarge:
+			closeWithError(CloseCodeMessageTooLarge, "message too large")
+			break main
 		case <-pingTicker.C:
 			err := cl.conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
 			if err != nil {
-				log.Printf("OT client error: websockest error: %v\n", err)
+				util.Errorf("OT client error: websockest error: %v", err)
 				break main
 			}
 			err = cl.conn.WriteMessage(websocket.PingMessage, []byte{})
 			if err != nil {
-				log.Printf("OT client error: websocket error: %v\n", err)
+				util.Errorf("OT client error: websocket error: %v", err)
 				break main
 			}
 			err = cl.conn.SetWriteDeadline(time.Time{})
 			if err != nil {
-				log.Printf("OT client error: websockest error: %v\n", err)
+				util.Errorf("OT client error: websockest error: %v", err)
 				break main
 			}
 		case s2cmsg, ok := <-cl.sv2cl:
 			if !ok {
-				// Closed by server and notification is not needed.
+				// Closed by server and notification is not needed unless evicted.
+				isEvicted()
 				return
 			}
 			if !sendSvResponse(s2cmsg) {
@@ -154,29 +245,53 @@ main:
 			if !ok {
 				break main
 			}
-			if cl.readOnly {
-				log.Printf("OT client error: permission denied\n")
-				break main
-			}
 			mtype, dat, err := parseMsg(req)
 			if err != nil {
-				log.Printf("OT client error: %v\n", err)
+				util.Errorf("OT client error: %v", err)
 				break main
 			}
+			// Read-only client can send chat only
+			if cl.readOnly && mtype != WSMsgTypeChat {
+				util.Errorf("OT client error: permission denied")
+				break main
+			}
+			// Undo and redo also generate an operation
+			if mtype == WSMsgTypeOp || mtype == WSMsgTypeChat || mtype == WSMsgTypeUndo || mtype == WSMsgTypeRedo {
+				now := time.Now()
+				tokens += now.Sub(lastFill).Seconds() * float64(cl.maxOpsPerSecond)
+				if tokens > float64(cl.maxOpsPerSecond) {
+					tokens = float64(cl.maxOpsPerSecond)
+				}
+				lastFill = now
+				if tokens < 1 {
+					closeWithError(CloseCodeRateLimited, "too many operations")
+					break main
+				}
+				tokens--
+			}
 			if mtype == WSMsgTypeOp {
 				opdat, ok := dat.(OpData)
 				if !ok {
-					log.Printf("OT client error: invalid request data\n")
+					util.Errorf("OT client error: invalid request data")
 					break main
 				}
 				cl.sendC2S(otC2SMessageTypeWSMsg, otWSMessage{Event: WSMsgTypeOp, Data: opdat})
 			} else if mtype == WSMsgTypeSel {
 				opdat, ok := dat.(Ranges)
 				if !ok {
-					log.Printf("OT client error: invalid request data\n")
+					util.Errorf("OT client error: invalid request data")
 					break main
 				}
 				cl.sendC2S(otC2SMessageTypeWSMsg, otWSMessage{Event: WSMsgTypeSel, Data: opdat})
+			} else if mtype == WSMsgTypeUndo || mtype == WSMsgTypeRedo {
+				cl.sendC2S(otC2SMessageTypeWSMsg, otWSMessage{Event: mtype})
+			} else if mtype == WSMsgTypeChat {
+				chatdat, ok := dat.(ChatReqData)
+				if !ok {
+					util.Errorf("OT client error: invalid request data")
+					break main
+				}
+				cl.sendC2S(otC2SMessageTypeWSMsg, otWSMessage{Event: WSMsgTypeChat, Data: chatdat})
 			}
 		}
 	}
@@ -5,9 +5,42 @@ import (
 	"time"
 
 	"github.com/wonder-wonder/cakemix-server/db"
+	"github.com/wonder-wonder/cakemix-server/util"
 )
 
-const serverStopDelay = 30
+// Default config values
+const (
+	defaultAutoSaveInterval   = 60  //Sec
+	defaultHistGCThreshold    = 200 //Ops
+	defaultClientPingInterval = 30  //Sec
+	defaultServerStopDelay    = 30  //Sec
+	defaultMaxOpsPerSecond    = 50
+	defaultMaxMessageSize     = 1 << 20  //Bytes
+	defaultMaxDocumentSize    = 10 << 20 //UTF-16 units
+)
+
+// Retry settings for starting server
+const (
+	startRetryMax     = 5
+	startBackoffStart = time.Millisecond * 100
+	startBackoffMax   = time.Second * 2
+)
+
+// Manager errors
+var (
+	ErrorManagerStopped = errors.New("OT manager is stopped")
+)
+
+// Config is structure for OT session configuration. Zero values are replaced by default values.
+type Config struct {
+	AutoSaveInterval   time.Duration
+	HistGCThreshold    int
+	ClientPingInterval time.Duration
+	ServerStopDelay    time.Duration
+	MaxOpsPerSecond    int
+	MaxMessageSize     int
+	MaxDocumentSize    int
+}
 
 type otStatus int
 
@@ -15,30 +48,42 @@ const (
 	otStatusStarting otStatus = iota
 	otStatusRunning
 	otStatusStopping
+	otStatusWriting // Content is being written to DB without session
 )
 
 type otServerRequestType int
 
 const (
 	otServerRequestTypeStarted otServerRequestType = iota
+	otServerRequestTypeStartFailed
 	otServerRequestTypeClientClosed
 	otServerRequestTypeStopped
+	otServerRequestTypeContentSaved
 )
 
 type otManagerRequestType int
 
 const (
 	otManagerRequestTypeAddClient otManagerRequestType = iota
+	otManagerRequestTypeCheckPermission
+	otManagerRequestTypeDisconnect
+	otManagerRequestTypePresence
+	otManagerRequestTypeContent
 )
 
 // Manager is structure for ot management
 type Manager struct {
 	db        *db.DB
+	conf      Config
 	sesslist  map[string]*otInfo
 	clientReq chan otClientRequest
 	serverReq chan otServerRequest
 	timeout   chan string
 	stop      chan string
+	notify    chan otNotification
+	presence  chan otPresenceQuery
+	content   chan otContentQuery
+	done      chan struct{} // Closed when the loop exits
 }
 
 type otInfo struct {
@@ -47,9 +92,13 @@ type otInfo struct {
 	Status    otStatus
 	StopTimer *time.Timer
 	StopWhen  time.Time
+	// Clients waiting for the server to start (or restart after stopping)
+	Pending []otClientRequest
+	// Stop is requested while starting
+	StopReq bool
 }
 type otClientRequest struct {
-	ready  chan struct{}
+	ready  chan error
 	docID  string
 	client *Client
 }
@@ -62,59 +111,78 @@ type otManagerRequest struct {
 	reqType otManagerRequestType
 	request interface{}
 }
+type otNotification struct {
+	docID   string // empty for all sessions
+	request otManagerRequest
+}
+type otDisconnectRequest struct {
+	uuid      string
+	sessionID string
+	code      int
+	reason    string
+}
 
 // NewManager creates new manager
-func NewManager(db *db.DB) (*Manager, error) {
+func NewManager(db *db.DB, conf Config) (*Manager, error) {
+	if conf.AutoSaveInterval <= 0 {
+		conf.AutoSaveInterval = time.Second * defaultAutoSaveInterval
+	}
+	if conf.HistGCThreshold <= 0 {
+		conf.HistGCThreshold = defaultHistGCThreshold
+	}
+	if conf.ClientPingInterval <= 0 {
+		conf.ClientPingInterval = time.Second * defaultClientPingInterval
+	}
+	if conf.ServerStopDelay <= 0 {
+		conf.ServerStopDelay = time.Second * defaultServerStopDelay
+	}
+	if conf.MaxOpsPerSecond <= 0 {
+		conf.MaxOpsPerSecond = defaultMaxOpsPerSecond
+	}
+	if conf.MaxMessageSize <= 0 {
+		conf.MaxMessageSize = defaultMaxMessageSize
+	}
+	if conf.MaxDocumentSize <= 0 {
+		conf.MaxDocumentSize = defaultMaxDocumentSize
+	}
 	mgr := &Manager{
 		db:        db,
+		conf:      conf,
 		sesslist:  map[string]*otInfo{},
 		clientReq: make(chan otClientRequest),
 		serverReq: make(chan otServerRequest),
 		timeout:   make(chan string),
 		stop:      make(chan string),
+		notify:    make(chan otNotification),
+		presence:  make(chan otPresenceQuery),
+		content:   make(chan otContentQuery),
+		done:      make(chan struct{}),
 	}
 	return mgr, nil
 }
 
 // Loop is main loop for manager
 func (mgr *Manager) Loop() {
+	defer close(mgr.done)
 	for {
 		select {
 		case clreq, _ := <-mgr.clientReq:
 			svinfo, ok := mgr.sesslist[clreq.docID]
 			if !ok {
-				go func() {
-					err := mgr.StartServer(clreq.docID)
-					if err != nil {
-						panic(err)
-					}
-				}()
-			}
-			if !ok || svinfo.Status != otStatusRunning {
-				// Reenqueue
-				go func() {
-					time.Sleep(time.Millisecond * 10)
-					mgr.clientReq <- clreq
-				}()
+				// Start server and wait
+				mgr.sesslist[clreq.docID] = &otInfo{
+					Status:  otStatusStarting,
+					Pending: []otClientRequest{clreq},
+				}
+				go mgr.StartServer(clreq.docID)
 				continue
 			}
-
-			mgr2sv := svinfo.Server.mgr2sv
-			// If manager request buffer is full,
-			if len(mgr2sv)+1 >= cap(mgr2sv) {
-				// Reenqueue
-				go func() {
-					time.Sleep(time.Millisecond * 10)
-					mgr.clientReq <- clreq
-				}()
+			if svinfo.Status != otStatusRunning {
+				// Wait for starting or restarting after stopped
+				svinfo.Pending = append(svinfo.Pending, clreq)
 				continue
 			}
-			mgr2sv <- otManagerRequest{
-				reqType: otManagerRequestTypeAddClient,
-				request: &clreq,
-			}
-
-			svinfo.ClientNum++
+			mgr.addClient(svinfo, clreq)
 		case svreq, _ := <-mgr.serverReq:
 			switch svreq.reqType {
 			case otServerRequestTypeStarted:
@@ -122,7 +190,26 @@ func (mgr *Manager) Loop() {
 				if !ok {
 					continue
 				}
+				svinfo.Server, _ = svreq.request.(*Server)
 				svinfo.Status = otStatusRunning
+				if svinfo.StopReq {
+					mgr.stopServer(svinfo)
+					continue
+				}
+				for _, v := range svinfo.Pending {
+					mgr.addClient(svinfo, v)
+				}
+				svinfo.Pending = nil
+			case otServerRequestTypeStartFailed:
+				svinfo, ok := mgr.sesslist[svreq.docID]
+				if !ok {
+					continue
+				}
+				err, _ := svreq.request.(error)
+				for _, v := range svinfo.Pending {
+					v.ready <- err
+				}
+				delete(mgr.sesslist, svreq.docID)
 			case otServerRequestTypeClientClosed:
 				svinfo, ok := mgr.sesslist[svreq.docID]
 				if !ok {
@@ -134,17 +221,29 @@ func (mgr *Manager) Loop() {
 						continue
 					}
 					// Set timeout
-					svinfo.StopWhen = time.Now().Add(time.Second * serverStopDelay)
+					svinfo.StopWhen = time.Now().Add(mgr.conf.ServerStopDelay)
 					timer := svinfo.StopTimer
 					if timer != nil {
 						timer.Stop()
 					}
-					svinfo.StopTimer = time.AfterFunc(time.Second*30, func() {
+					svinfo.StopTimer = time.AfterFunc(mgr.conf.ServerStopDelay, func() {
 						mgr.timeout <- svinfo.Server.docID
 					})
 				}
-			case otServerRequestTypeStopped:
+			case otServerRequestTypeStopped, otServerRequestTypeContentSaved:
+				svinfo, ok := mgr.sesslist[svreq.docID]
+				if !ok {
+					continue
+				}
 				delete(mgr.sesslist, svreq.docID)
+				// Start for the clients which came while stopping or writing content
+				if len(svinfo.Pending) > 0 {
+					mgr.sesslist[svreq.docID] = &otInfo{
+						Status:  otStatusStarting,
+						Pending: svinfo.Pending,
+					}
+					go mgr.StartServer(svreq.docID)
+				}
 			}
 		case docID := <-mgr.timeout:
 			svinfo, ok := mgr.sesslist[docID]
@@ -154,28 +253,52 @@ func (mgr *Manager) Loop() {
 			if time.Now().Before(svinfo.StopWhen) {
 				continue
 			}
-			if svinfo.ClientNum > 0 {
+			if svinfo.ClientNum > 0 || svinfo.Status != otStatusRunning {
 				continue
 			}
-			close(svinfo.Server.mgr2sv)
-			svinfo.Status = otStatusStopping
+			mgr.stopServer(svinfo)
+		case n := <-mgr.notify:
+			for docID, svinfo := range mgr.sesslist {
+				if n.docID != "" && n.docID != docID {
+					continue
+				}
+				if svinfo.Status != otStatusRunning {
+					continue
+				}
+				svinfo.Server.mgr2sv <- n.request
+			}
+		case q := <-mgr.presence:
+			mgr.queryPresence(q)
+		case q := <-mgr.content:
+			mgr.forwardContent(q)
 		case docID := <-mgr.stop:
 			if docID != "" {
 				svinfo, ok := mgr.sesslist[docID]
 				if !ok {
 					continue
 				}
-				close(svinfo.Server.mgr2sv)
-				svinfo.Status = otStatusStopping
+				mgr.stopServer(svinfo)
 				continue
 			}
 			for _, v := range mgr.sesslist {
-				close(v.Server.mgr2sv)
-				v.Status = otStatusStopping
+				mgr.stopServer(v)
+				for _, cl := range v.Pending {
+					cl.ready <- ErrorManagerStopped
+				}
+				v.Pending = nil
 			}
 			for len(mgr.sesslist) > 0 {
 				svreq := <-mgr.serverReq
-				if svreq.reqType == otServerRequestTypeStopped {
+				switch svreq.reqType {
+				case otServerRequestTypeStarted:
+					svinfo, ok := mgr.sesslist[svreq.docID]
+					if !ok {
+						continue
+					}
+					svinfo.Server, _ = svreq.request.(*Server)
+					svinfo.Status = otStatusRunning
+					mgr.stopServer(svinfo)
+				case otServerRequestTypeStartFailed, otServerRequestTypeStopped, otServerRequestTypeContentSaved:
 					delete(mgr.sesslist, svreq.docID)
 				}
 			}
@@ -184,34 +307,66 @@ func (mgr *Manager) Loop() {
 	}
 }
 
-// StartServer creates new server and start main loop
-func (mgr *Manager) StartServer(docID string) error {
-	if _, ok := mgr.sesslist[docID]; ok {
-		return errors.New("Server already exist: " + docID)
+// addClient sends the client to the running server
+func (mgr *Manager) addClient(svinfo *otInfo, clreq otClientRequest) {
+	req := clreq
+	svinfo.Server.mgr2sv <- otManagerRequest{
+		reqType: otManagerRequestTypeAddClient,
+		request: &req,
 	}
-	sv, err := NewServer(docID, mgr.serverReq, mgr.db)
-	if err != nil {
-		return err
+	svinfo.ClientNum++
+}
+
+// stopServer requests the server to stop. If the server is starting, it's stopped after started.
+func (mgr *Manager) stopServer(svinfo *otInfo) {
+	switch svinfo.Status {
+	case otStatusStarting:
+		svinfo.StopReq = true
+	case otStatusRunning:
+		close(svinfo.Server.mgr2sv)
+		svinfo.Status = otStatusStopping
 	}
-	mgr.sesslist[docID] = &otInfo{
-		Server:    sv,
-		ClientNum: 0,
-		Status:    otStatusStarting,
-		StopTimer: nil,
+}
+
+// StartServer creates new server and start main loop. Transient errors are retried with backoff.
+// The result is notified to manager loop.
+func (mgr *Manager) StartServer(docID string) {
+	backoff := startBackoffStart
+	var sv *Server
+	var err error
+	for i := 0; ; i++ {
+		sv, err = NewServer(docID, mgr.serverReq, mgr.db, mgr.conf)
+		if err == nil || errors.Is(err, db.ErrDocumentNotFound) || i >= startRetryMax {
+			break
+		}
+		util.Warnf("OT session start error: %v (retry after %v)", err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > startBackoffMax {
+			backoff = startBackoffMax
+		}
+	}
+	if err != nil {
+		util.Errorf("OT session start error: %v", err)
+		mgr.serverReq <- otServerRequest{docID: docID, reqType: otServerRequestTypeStartFailed, request: err}
+		return
 	}
 	go sv.Loop()
-	return nil
 }
 
-// ClientConnect connects client to server
-func (mgr *Manager) ClientConnect(cl *Client, docid string) {
-	ready := make(chan struct{})
-	mgr.clientReq <- otClientRequest{
+// ClientConnect connects client to server. It returns error if the server can't be started or the manager is stopped.
+func (mgr *Manager) ClientConnect(cl *Client, docid string) error {
+	ready := make(chan error, 1)
+	select {
+	case mgr.clientReq <- otClientRequest{
 		ready:  ready,
 		docID:  docid,
 		client: cl,
+	}:
+	case <-mgr.done:
+		return ErrorManagerStopped
 	}
-	<-ready
+	return <-ready
 }
 
 // StopOTManager stops manager
@@ -223,3 +378,26 @@ func (mgr *Manager) StopOTManager() {
 func (mgr *Manager) StopOTSession(docID string) {
 	go func() { mgr.stop <- docID }()
 }
+
+// CheckPermission requests to check permission of connected clients again (e.g. after document permission or team member is changed).
+// Empty docID means all sessions and empty uuid means all users.
+func (mgr *Manager) CheckPermission(docID string, uuid string) {
+	go func() {
+		mgr.notify <- otNotification{
+			docID:   docID,
+			request: otManagerRequest{reqType: otManagerRequestTypeCheckPermission, request: uuid},
+		}
+	}()
+}
+
+// Disconnect disconnects the user's clients with the close code. Empty sessionID means all sessions of the user.
+func (mgr *Manager) Disconnect(uuid string, sessionID string, code int, reason string) {
+	go func() {
+		mgr.notify <- otNotification{
+			request: otManagerRequest{
+				reqType: otManagerRequestTypeDisconnect,
+				request: otDisconnectRequest{uuid: uuid, sessionID: sessionID, code: code, reason: reason},
+			},
+		}
+	}()
+}
@@ -0,0 +1,26 @@
+package ot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientConnectAfterStop(t *testing.T) {
+	mgr, err := NewManager(nil, Config{})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	go mgr.Loop()
+	mgr.StopOTManager()
+
+	res := make(chan error, 1)
+	go func() { res <- mgr.ClientConnect(nil, "dtest") }()
+	select {
+	case err := <-res:
+		assert.Equal(t, ErrorManagerStopped, err)
+	case <-time.After(time.Second):
+		t.Fatal("ClientConnect is blocked after the manager is stopped")
+	}
+}
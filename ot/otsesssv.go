@@ -1,35 +1,41 @@
 package ot
 
 import (
-	"log"
+	"errors"
 	"strconv"
 	"time"
 	"unicode/utf16"
 
 	"github.com/wonder-wonder/cakemix-server/db"
-)
-
-const (
-	autoSaveInterval     = 60  //Sec
-	otHistGCThreshold    = 200 //Ops
-	otClientPingInterval = 30  //Sec
+	"github.com/wonder-wonder/cakemix-server/util"
 )
 
 // Server is structure for server
 type Server struct {
 	// DB conn
 	db *db.DB
+	// Config
+	conf Config
 	// DocInfo
 	docID   string
 	docInfo db.Document
+	// Epoch identifies the session instance. Revisions and client IDs restart from 0 in every instance.
+	epoch string
 	// OT
 	ot              *OT
 	lastUpdater     string
+	blame           Blame
+	pendingOps      []db.DocumentOp // Operations after last save
+	savedRev        int             // Revision in DB
 	countFromLastGC int
 	needSave        bool
 
 	// Clients
 	clients map[string]*Client
+	// Undo stacks for each user
+	undo map[string]*undoStack
+	// Recent chat messages
+	chat []ChatData
 
 	// Management info
 	accumulationClients int // for serial number
@@ -58,13 +64,16 @@ type otWSMessage struct {
 }
 
 // NewServer creates new server
-func NewServer(docID string, sv2mgr chan otServerRequest, db *db.DB) (*Server, error) {
+func NewServer(docID string, sv2mgr chan otServerRequest, db *db.DB, conf Config) (*Server, error) {
 	sv := &Server{
 		db:                  db,
+		conf:                conf,
 		docID:               docID,
+		epoch:               strconv.FormatInt(time.Now().UnixNano(), 36),
 		countFromLastGC:     0,
 		needSave:            false,
 		clients:             map[string]*Client{},
+		undo:                map[string]*undoStack{},
 		accumulationClients: 0,
 		sv2mgr:              sv2mgr,
 		mgr2sv:              make(chan otManagerRequest, 10),
@@ -76,11 +85,28 @@ func NewServer(docID string, sv2mgr chan otServerRequest, db *db.DB) (*Server, e
 		return nil, err
 	}
 	sv.docInfo = docInfo
+	sv.savedRev = docInfo.Revision
 	text, err := db.GetLatestDocument(docID)
 	if err != nil {
 		return nil, err
 	}
 	sv.ot = NewOT(text)
+	sv.ot.MaxLength = conf.MaxDocumentSize
+
+	// Load attribution. If it's not stored (or broken), whole text is attributed to the last updater.
+	blame, err := db.GetDocumentBlame(docID, docInfo.Revision)
+	if err != nil {
+		return nil, err
+	}
+	sv.blame = Blame(blame)
+	if blame == nil || sv.blame.Len() != len(utf16.Encode([]rune(text))) {
+		sv.blame = NewBlame(len(utf16.Encode([]rune(text))), docInfo.UpdaterUUID, docInfo.UpdatedAt)
+	}
+
+	err = sv.loadChat()
+	if err != nil {
+		return nil, err
+	}
 
 	return sv, nil
 }
@@ -97,9 +123,9 @@ func (sv *Server) sendS2M(reqType otServerRequestType, request interface{}) {
 
 // Loop is main loop for server
 func (sv *Server) Loop() {
-	autoSaveTicker := time.NewTicker(time.Second * autoSaveInterval)
+	autoSaveTicker := time.NewTicker(sv.conf.AutoSaveInterval)
 	defer autoSaveTicker.Stop()
-	sv.sendS2M(otServerRequestTypeStarted, nil)
+	sv.sendS2M(otServerRequestTypeStarted, sv)
 main:
 	for {
 		select {
@@ -110,59 +136,25 @@ main:
 			}
 			switch mgrreq.reqType {
 			case otManagerRequestTypeAddClient:
-				// Add to client list
 				clreq, _ := mgrreq.request.(*otClientRequest)
-				clientID := strconv.Itoa(sv.accumulationClients)
-				sv.accumulationClients++
-				sv.clients[clientID] = clreq.client
-
-				// Setup client
-				clreq.client.clientID = clientID
-				clreq.client.cl2sv = sv.cl2sv
-				clreq.client.lastRev = sv.ot.Revision
-
-				// Broadcast new client info
-				sv.broadcast(clientID, otWSMessage{
-					Event: WSMsgTypeJoin,
-					Data: ClientJoinData{
-						ID:      clreq.client.clientID,
-						Name:    clreq.client.profile.Name,
-						UUID:    clreq.client.profile.UUID,
-						IconURI: clreq.client.profile.IconURI,
-					},
-				})
-
-				// Finish init and ready
-				go func() { clreq.ready <- struct{}{} }()
-
-				// Send doc event to client
-				res := DocData{
-					Clients:    map[string]ClientData{},
-					Document:   sv.ot.Text,
-					Revision:   clreq.client.lastRev,
-					Owner:      sv.docInfo.OwnerUUID,
-					Permission: int(sv.docInfo.Permission),
-					Editable:   clreq.client.readOnly,
-				}
-				for tclientID, cl := range sv.clients {
-					if tclientID == clientID {
+				sv.addClient(clreq)
+			case otManagerRequestTypeCheckPermission:
+				uuid, _ := mgrreq.request.(string)
+				sv.checkPermission(uuid)
+			case otManagerRequestTypeDisconnect:
+				dreq, _ := mgrreq.request.(otDisconnectRequest)
+				for id, cl := range sv.clients {
+					if cl.profile.UUID != dreq.uuid || (dreq.sessionID != "" && cl.profile.SessionID != dreq.sessionID) {
 						continue
 					}
-					rescl := ClientData{
-						Name:    cl.profile.Name,
-						UUID:    cl.profile.UUID,
-						IconURI: cl.profile.IconURI,
-					}
-					rescl.Selection.Ranges = []SelData{}
-					for _, sel := range cl.selection {
-						rescl.Selection.Ranges = append(rescl.Selection.Ranges, sel)
-					}
-					res.Clients[tclientID] = rescl
-				}
-				clreq.client.sv2cl <- otWSMessage{
-					Event: WSMsgTypeDoc,
-					Data:  res,
+					sv.disconnectClient(id, dreq.code, dreq.reason)
 				}
+			case otManagerRequestTypePresence:
+				res, _ := mgrreq.request.(chan DocumentPresence)
+				res <- DocumentPresence{DocID: sv.docID, Users: sv.presence()}
+			case otManagerRequestTypeContent:
+				creq, _ := mgrreq.request.(*otContentRequest)
+				sv.handleContent(creq)
 			}
 		case clreq, _ := <-sv.cl2sv:
 			switch clreq.msgType {
@@ -171,13 +163,17 @@ main:
 				sv.closeClient(clreq.clientID)
 				saved, err := sv.saveDoc()
 				if err != nil {
-					log.Printf("OT session error: save error: %v\n", err)
+					util.Errorf("OT session error: save error: %v", err)
 					break main
 				}
 				if saved {
-					log.Printf("Session(%s) auto saved (total %d ops)", sv.docID, sv.ot.Revision)
+					util.Debugf("Session(%s) auto saved (total %d ops)", sv.docID, sv.ot.Revision)
 				}
 			case otC2SMessageTypeWSMsg:
+				// Ignore the message from closed (e.g. evicted) client
+				if _, ok := sv.clients[clreq.clientID]; !ok {
+					continue
+				}
 				wsmsg := clreq.message.(otWSMessage)
 				switch wsmsg.Event {
 				case WSMsgTypeOp:
@@ -185,71 +181,20 @@ main:
 					if !ok {
 						continue
 					}
-					ops := Ops{User: clreq.clientID, Ops: []Op{}}
-					for _, op := range opdat.Operation {
-						switch opt := op.(type) {
-						case float64:
-							opi := int(opt)
-							if opi < 0 {
-								ops.Ops = append(ops.Ops, Op{OpType: OpTypeDelete, Len: -opi})
-							} else {
-								ops.Ops = append(ops.Ops, Op{OpType: OpTypeRetain, Len: opi})
-							}
-						case string:
-							ops.Ops = append(ops.Ops, Op{OpType: OpTypeInsert, Len: len(utf16.Encode([]rune(opt))), Text: opt})
-						default:
-							continue
-						}
-					}
-					optrans, err := sv.ot.Operate(opdat.Revision, ops)
+					err := sv.applyOp(clreq.clientID, opdat)
 					if err != nil {
-						log.Printf("OT session error: operate error: %v\n", err)
-						sv.closeClient(clreq.clientID)
+						util.Errorf("OT session error: operate error: %v", err)
+						sv.disconnectClient(clreq.clientID, CloseCodeInvalidOperation, err.Error())
 						continue
 					}
-					opraw := []interface{}{}
-					for _, v := range optrans.Ops {
-						if v.OpType == OpTypeRetain {
-							opraw = append(opraw, v.Len)
-						} else if v.OpType == OpTypeInsert {
-							opraw = append(opraw, v.Text)
-						} else if v.OpType == OpTypeDelete {
-							opraw = append(opraw, -v.Len)
-						}
-					}
-					opdat.Operation = opraw
-					cl := sv.clients[clreq.clientID]
-
-					cl.selection = opdat.Selection.Ranges
-					cl.lastRev = sv.ot.Revision
-
-					opres := []interface{}{clreq.clientID, opdat.Operation, opdat.Selection}
-					sv.broadcast(clreq.clientID, otWSMessage{
-						Event: WSMsgTypeOp,
-						Data:  opres,
-					})
-					cl.sv2cl <- otWSMessage{
-						Event: WSMsgTypeOK,
-						Data:  nil,
-					}
-
-					sv.lastUpdater = cl.profile.UUID
-					sv.countFromLastGC++
-					sv.needSave = true
-
-					if sv.countFromLastGC >= otHistGCThreshold {
-						sv.countFromLastGC = 0
-						min := sv.ot.Revision
-						for _, c := range sv.clients {
-							if c.lastRev < min {
-								min = c.lastRev
-							}
-						}
-						for i := sv.ot.Revision - len(sv.ot.History); i < min-1; i++ {
-							delete(sv.ot.History, i)
-						}
-						log.Printf("Session(%s) OT GC: rev is %d, hist len is %d", sv.docID, sv.ot.Revision, len(sv.ot.History))
+				case WSMsgTypeUndo, WSMsgTypeRedo:
+					sv.undoRedo(clreq.clientID, wsmsg.Event == WSMsgTypeRedo)
+				case WSMsgTypeChat:
+					chatdat, ok := wsmsg.Data.(ChatReqData)
+					if !ok {
+						continue
 					}
+					sv.postChat(clreq.clientID, chatdat)
 				case WSMsgTypeSel:
 					seldat, ok := wsmsg.Data.(Ranges)
 					if !ok {
@@ -266,11 +211,11 @@ main:
 		case <-autoSaveTicker.C:
 			saved, err := sv.saveDoc()
 			if err != nil {
-				log.Printf("OT session error: save error: %v\n", err)
+				util.Errorf("OT session error: save error: %v", err)
 				break main
 			}
 			if saved {
-				log.Printf("Session(%s) auto saved (total %d ops)", sv.docID, sv.ot.Revision)
+				util.Debugf("Session(%s) auto saved (total %d ops)", sv.docID, sv.ot.Revision)
 			}
 		}
 	}
@@ -279,30 +224,366 @@ main:
 	}
 	_, err := sv.saveDoc()
 	if err != nil {
-		log.Printf("OT session close error: %v\n", err)
+		util.Errorf("OT session close error: %v", err)
 	}
-	log.Printf("Session(%s) closed (total %d ops)\n", sv.docID, sv.ot.Revision)
+	util.Infof("Session(%s) closed (total %d ops)", sv.docID, sv.ot.Revision)
 	sv.sendS2M(otServerRequestTypeStopped, nil)
 }
 
+func (sv *Server) addClient(clreq *otClientRequest) {
+	// Add to client list
+	clientID := strconv.Itoa(sv.accumulationClients)
+	sv.accumulationClients++
+	sv.clients[clientID] = clreq.client
+
+	// Setup client
+	clreq.client.clientID = clientID
+	clreq.client.cl2sv = sv.cl2sv
+	clreq.client.lastRev = sv.ot.Revision
+	clreq.client.pingInterval = sv.conf.ClientPingInterval
+	clreq.client.maxOpsPerSecond = sv.conf.MaxOpsPerSecond
+	clreq.client.maxMessageSize = sv.conf.MaxMessageSize
+	clreq.client.editable = !clreq.client.readOnly
+
+	// Broadcast new client info
+	sv.broadcast(clientID, otWSMessage{
+		Event: WSMsgTypeJoin,
+		Data: ClientJoinData{
+			ID:      clreq.client.clientID,
+			Name:    clreq.client.profile.Name,
+			UUID:    clreq.client.profile.UUID,
+			IconURI: clreq.client.profile.IconURI,
+		},
+	})
+
+	// Finish init and ready
+	clreq.ready <- nil
+
+	// Send missed operations if the client is reconnected, otherwise send doc event
+	if sv.resumeClient(clreq.client) {
+		return
+	}
+	sv.sendDoc(clreq.client)
+}
+
+// sendDoc sends whole document to the client
+func (sv *Server) sendDoc(cl *Client) {
+	sv.send(cl, otWSMessage{
+		Event: WSMsgTypeDoc,
+		Data: DocData{
+			ID:         cl.clientID,
+			Clients:    sv.clientDataList(cl.clientID),
+			Document:   sv.ot.Text,
+			Revision:   sv.ot.Revision,
+			Epoch:      sv.epoch,
+			Owner:      sv.docInfo.OwnerUUID,
+			Permission: int(sv.docInfo.Permission),
+			Editable:   cl.editable,
+			Chat:       sv.chatHistory(),
+		},
+	})
+}
+
+// sendConflict sends the rejected operation and whole document to the client not to lose the operation
+func (sv *Server) sendConflict(cl *Client, opdat OpData, reason error) {
+	sv.send(cl, otWSMessage{
+		Event: WSMsgTypeConflict,
+		Data: ConflictData{
+			Revision:  opdat.Revision,
+			Operation: opdat.Operation,
+			Selection: opdat.Selection,
+			Reason:    reason.Error(),
+		},
+	})
+	cl.lastRev = sv.ot.Revision
+	sv.sendDoc(cl)
+}
+
+// resumeClient sends the operations after the revision the client knows and applies the pending operation.
+// It returns false if resume is not requested or not available.
+func (sv *Server) resumeClient(cl *Client) bool {
+	rs := cl.resume
+	cl.resume = nil
+	if rs == nil {
+		return false
+	}
+	// The revision of other session instance (e.g. before restart) is unrelated to current history.
+	// The pending operation is returned as conflict to be applied to the whole document again.
+	if rs.Epoch != sv.epoch || rs.Revision < 0 || rs.Revision > sv.ot.Revision {
+		if len(rs.Operation) == 0 || !cl.editable {
+			return false
+		}
+		sv.sendConflict(cl, OpData{Revision: rs.Revision, Operation: rs.Operation, Selection: rs.Selection}, ErrorSessionRestarted)
+		return true
+	}
+	for i := rs.Revision; i < sv.ot.Revision; i++ {
+		h, ok := sv.ot.History[i]
+		// History is already removed by GC.
+		// The pending operation is returned as conflict because it may be already applied.
+		if !ok {
+			if len(rs.Operation) == 0 {
+				return false
+			}
+			sv.sendConflict(cl, OpData{Revision: rs.Revision, Operation: rs.Operation, Selection: rs.Selection}, ErrorRevisionNotInHistory)
+			return true
+		}
+		// The pending operation may be applied but the client didn't receive OK.
+		// It can't be distinguished from the pending operation so send whole document.
+		if rs.ClientID != "" && h.User == rs.ClientID {
+			return false
+		}
+	}
+
+	sv.send(cl, otWSMessage{
+		Event: WSMsgTypeResume,
+		Data: ResumeResData{
+			ID:         cl.clientID,
+			Clients:    sv.clientDataList(cl.clientID),
+			Revision:   rs.Revision,
+			Epoch:      sv.epoch,
+			Owner:      sv.docInfo.OwnerUUID,
+			Permission: int(sv.docInfo.Permission),
+			Editable:   cl.editable,
+			Chat:       sv.chatHistory(),
+		},
+	})
+	for i := rs.Revision; i < sv.ot.Revision; i++ {
+		h := sv.ot.History[i]
+		sv.send(cl, otWSMessage{
+			Event: WSMsgTypeOp,
+			Data:  []interface{}{h.User, opsToRaw(h), Ranges{Ranges: []SelData{}}},
+		})
+	}
+	if !cl.editable || len(rs.Operation) == 0 {
+		return true
+	}
+	err := sv.applyOp(cl.clientID, OpData{Revision: rs.Revision, Operation: rs.Operation, Selection: rs.Selection})
+	if err != nil {
+		util.Errorf("OT session error: resume error: %v", err)
+		sv.disconnectClient(cl.clientID, CloseCodeInvalidOperation, err.Error())
+	}
+	return true
+}
+
+// clientDataList returns the client list except specified client
+func (sv *Server) clientDataList(except string) map[string]ClientData {
+	res := map[string]ClientData{}
+	for tclientID, cl := range sv.clients {
+		if tclientID == except {
+			continue
+		}
+		rescl := ClientData{
+			Name:    cl.profile.Name,
+			UUID:    cl.profile.UUID,
+			IconURI: cl.profile.IconURI,
+		}
+		rescl.Selection.Ranges = []SelData{}
+		for _, sel := range cl.selection {
+			rescl.Selection.Ranges = append(rescl.Selection.Ranges, sel)
+		}
+		res[tclientID] = rescl
+	}
+	return res
+}
+
+// applyOp applies the operation from the client and broadcasts it
+func (sv *Server) applyOp(clientID string, opdat OpData) error {
+	cl, ok := sv.clients[clientID]
+	if !ok {
+		return nil
+	}
+	// Permission may be changed after the client sent it
+	if !cl.editable {
+		sv.sendConflict(cl, opdat, errors.New("permission denied"))
+		return nil
+	}
+	ops := rawToOps(clientID, opdat.Operation)
+	before := sv.ot.Text
+	optrans, err := sv.ot.Operate(opdat.Revision, ops)
+	if errors.Is(err, ErrorRevisionNotInHistory) {
+		// The base revision is removed by GC, so merge it into current text
+		optrans, err = sv.ot.Rebase(opdat.Revision, ops)
+		if err == nil {
+			optrans, err = sv.ot.Operate(sv.ot.Revision, optrans)
+		}
+		if err != nil && !errors.Is(err, ErrorDocumentTooLarge) {
+			util.Warnf("Session(%s) operation from %s is rejected: %v", sv.docID, clientID, err)
+			sv.sendConflict(cl, opdat, err)
+			return nil
+		}
+	}
+	if errors.Is(err, ErrorDocumentTooLarge) {
+		sv.sendError(cl, ErrorCodeDocumentTooLarge, err)
+		sv.sendConflict(cl, opdat, err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	opdat.Operation = opsToRaw(optrans)
+
+	cl.selection = opdat.Selection.Ranges
+	cl.lastRev = sv.ot.Revision
+
+	opres := []interface{}{clientID, opdat.Operation, opdat.Selection}
+	sv.broadcast(clientID, otWSMessage{
+		Event: WSMsgTypeOp,
+		Data:  opres,
+	})
+	sv.send(cl, otWSMessage{
+		Event: WSMsgTypeOK,
+		Data:  nil,
+	})
+	sv.recordUndo(cl.profile.UUID, before, optrans)
+	sv.updated(cl.profile.UUID, optrans)
+	return nil
+}
+
+// updated updates the session status after the user's operation is applied
+func (sv *Server) updated(uuid string, ops Ops) {
+	blame, err := sv.blame.Apply(ops, uuid, time.Now().Unix())
+	if err != nil {
+		util.Errorf("Session(%s) blame error: %v", sv.docID, err)
+		blame = NewBlame(len(utf16.Encode([]rune(sv.ot.Text))), uuid, time.Now().Unix())
+	}
+	sv.blame = blame
+	sv.pendingOps = append(sv.pendingOps, db.DocumentOp{
+		UUID: uuid,
+		Time: time.Now().UnixNano() / int64(time.Millisecond),
+		Ops:  opsToRaw(ops),
+	})
+	sv.lastUpdater = uuid
+	sv.countFromLastGC++
+	sv.needSave = true
+
+	if sv.countFromLastGC >= sv.conf.HistGCThreshold {
+		sv.countFromLastGC = 0
+		min := sv.ot.Revision
+		for _, c := range sv.clients {
+			if c.lastRev < min {
+				min = c.lastRev
+			}
+		}
+		sv.ot.PruneHistory(min - 1)
+		util.Debugf("Session(%s) OT GC: rev is %d, hist len is %d", sv.docID, sv.ot.Revision, len(sv.ot.History))
+	}
+}
+
+// checkPermission reloads document info and checks permission of the user's clients again.
+// If uuid is empty, all clients are checked.
+func (sv *Server) checkPermission(uuid string) {
+	docInfo, err := sv.db.GetDocumentInfo(sv.docID)
+	if err != nil {
+		util.Errorf("OT session error: permission check error: %v", err)
+		return
+	}
+	docChanged := docInfo.OwnerUUID != sv.docInfo.OwnerUUID || docInfo.Permission != sv.docInfo.Permission
+	sv.docInfo = docInfo
+
+	teams := map[string][]string{}
+	for id, cl := range sv.clients {
+		if uuid != "" && cl.profile.UUID != uuid {
+			continue
+		}
+		t, ok := teams[cl.profile.UUID]
+		if !ok {
+			t, err = sv.db.GetTeamsByUser(cl.profile.UUID)
+			if err != nil {
+				util.Errorf("OT session error: permission check error: %v", err)
+				continue
+			}
+			teams[cl.profile.UUID] = t
+		}
+		related := docInfo.OwnerUUID == cl.profile.UUID
+		for _, v := range t {
+			if v == docInfo.OwnerUUID {
+				related = true
+			}
+		}
+
+		if !related && docInfo.Permission == db.FilePermPrivate {
+			sv.disconnectClient(id, CloseCodePermissionDenied, "permission denied")
+			continue
+		}
+		editable := related || docInfo.Permission == db.FilePermReadWrite
+		if editable == cl.editable && !docChanged {
+			continue
+		}
+		cl.editable = editable
+		sv.send(cl, otWSMessage{
+			Event: WSMsgTypePermission,
+			Data: PermissionData{
+				Owner:      docInfo.OwnerUUID,
+				Permission: int(docInfo.Permission),
+				Editable:   editable,
+			},
+		})
+	}
+}
+
+// disconnectClient closes the client connection with the reason
+func (sv *Server) disconnectClient(clientID string, code int, reason string) {
+	cl, ok := sv.clients[clientID]
+	if !ok {
+		return
+	}
+	util.Infof("Session(%s) client %s is disconnected: %s", sv.docID, clientID, reason)
+	sv.sendError(cl, code, errors.New(reason))
+	sv.send(cl, otWSMessage{
+		Event: WSMsgTypeClose,
+		Data:  CloseData{Code: code, Reason: reason},
+	})
+	sv.closeClient(clientID)
+}
+
 func (sv *Server) broadcast(from string, message otWSMessage) {
 	for i, v := range sv.clients {
 		if i == from {
 			continue
 		}
-		v.sv2cl <- message
+		sv.send(v, message)
+	}
+}
+
+// send sends the message to the client without blocking. If the client buffer is full, the client is evicted.
+func (sv *Server) send(cl *Client, message otWSMessage) bool {
+	// Already closed
+	if _, ok := sv.clients[cl.clientID]; !ok {
+		return false
 	}
+	select {
+	case cl.sv2cl <- message:
+		return true
+	default:
+		// closeClient removes the client before other sends, so evicted is closed only once
+		util.Warnf("Session(%s) client %s is evicted: too slow", sv.docID, cl.clientID)
+		close(cl.evicted)
+		sv.closeClient(cl.clientID)
+		return false
+	}
+}
+
+// sendError sends the error to the client
+func (sv *Server) sendError(cl *Client, code int, err error) {
+	sv.send(cl, otWSMessage{
+		Event: WSMsgTypeError,
+		Data:  ErrorData{Code: code, Message: err.Error()},
+	})
 }
 
+// closeClient removes the client and notifies others. The client is removed first because broadcast may evict and close other clients recursively.
 func (sv *Server) closeClient(clientID string) {
+	cl, ok := sv.clients[clientID]
+	// Already closed
+	if !ok {
+		return
+	}
+	delete(sv.clients, clientID)
+	close(cl.sv2cl)
+	sv.sendS2M(otServerRequestTypeClientClosed, nil)
 	sv.broadcast(clientID, otWSMessage{
 		Event: WSMsgTypeQuit,
 		Data:  clientID,
 	})
-	cl := sv.clients[clientID]
-	close(cl.sv2cl)
-	delete(sv.clients, clientID)
-	sv.sendS2M(otServerRequestTypeClientClosed, nil)
 }
 
 func (sv *Server) saveDoc() (bool, error) {
@@ -310,12 +591,15 @@ func (sv *Server) saveDoc() (bool, error) {
 		return false, nil
 	}
 	sv.needSave = false
+	sv.ot.Snapshot()
 	if len(sv.ot.History) > 0 {
 		updateruuid := sv.lastUpdater
-		err := sv.db.SaveDocument(sv.docID, updateruuid, sv.ot.Text)
+		rev, err := sv.db.SaveDocument(sv.docID, updateruuid, sv.ot.Text, sv.blame, sv.pendingOps)
 		if err != nil {
 			return false, err
 		}
+		sv.savedRev = rev
+		sv.pendingOps = nil
 		err = sv.db.UpdateDocument(sv.docID, updateruuid)
 		if err != nil {
 			return false, err
@@ -0,0 +1,195 @@
+package ot
+
+import (
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestServer creates the server without DB
+func newTestServer(text string) *Server {
+	return &Server{
+		docID:   "dtest",
+		epoch:   "e1",
+		conf:    Config{HistGCThreshold: 1000},
+		ot:      NewOT(text),
+		blame:   NewBlame(len(utf16.Encode([]rune(text))), "", 0),
+		clients: map[string]*Client{},
+		undo:    map[string]*undoStack{},
+		sv2mgr:  make(chan otServerRequest, 100),
+		mgr2sv:  make(chan otManagerRequest, 10),
+		cl2sv:   make(chan otC2SMessage, 100),
+	}
+}
+
+// addTestClient adds the client which has the buffer of the size
+func (sv *Server) addTestClient(uuid string, size int, resume *ResumeData) *Client {
+	cl := &Client{
+		profile:  ClientProfile{UUID: uuid},
+		sv2cl:    make(chan otWSMessage, size),
+		evicted:  make(chan struct{}),
+		resume:   resume,
+		readOnly: false,
+	}
+	sv.addClient(&otClientRequest{ready: make(chan error, 1), client: cl})
+	return cl
+}
+
+// received returns the messages sent to the client
+func received(cl *Client) []otWSMessage {
+	res := []otWSMessage{}
+	for {
+		select {
+		case msg, ok := <-cl.sv2cl:
+			if !ok {
+				return res
+			}
+			res = append(res, msg)
+		default:
+			return res
+		}
+	}
+}
+
+func TestServerEvict(t *testing.T) {
+	sv := newTestServer("abc")
+	cla := sv.addTestClient("ua", 10, nil)
+	clb := sv.addTestClient("ub", 10, nil)
+	clc := sv.addTestClient("uc", 100, nil)
+	// Fill the buffers of slow clients
+	for _, cl := range []*Client{cla, clb} {
+		for len(cl.sv2cl) < cap(cl.sv2cl) {
+			cl.sv2cl <- otWSMessage{Event: WSMsgTypeSel}
+		}
+	}
+
+	// Evicting a client broadcasts quit to the other full client, and it must not be evicted twice
+	assert.NotPanics(t, func() {
+		sv.broadcast(clc.clientID, otWSMessage{Event: WSMsgTypeSel})
+	})
+	assert.Len(t, sv.clients, 1)
+	for _, cl := range []*Client{cla, clb} {
+		select {
+		case <-cl.evicted:
+		default:
+			t.Errorf("client %s should be evicted", cl.clientID)
+		}
+		_, ok := sv.clients[cl.clientID]
+		assert.False(t, ok)
+	}
+}
+
+// docEvent returns the last doc event
+func docEvent(msgs []otWSMessage) (DocData, bool) {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if dat, ok := msgs[i].Data.(DocData); ok && msgs[i].Event == WSMsgTypeDoc {
+			return dat, true
+		}
+	}
+	return DocData{}, false
+}
+
+func TestServerResume(t *testing.T) {
+	sv := newTestServer("abc")
+	cla := sv.addTestClient("ua", 100, nil)
+	doc, ok := docEvent(received(cla))
+	if !assert.True(t, ok) {
+		t.FailNow()
+	}
+	assert.Equal(t, "e1", doc.Epoch)
+
+	// Missed operation by other client
+	clb := sv.addTestClient("ub", 100, nil)
+	err := sv.applyOp(clb.clientID, OpData{Revision: 0, Operation: []interface{}{float64(3), "d"}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	// Reconnect with the pending operation based on revision 0
+	sv.closeClient(cla.clientID)
+	rs := &ResumeData{ClientID: doc.ID, Revision: 0, Epoch: doc.Epoch, Operation: []interface{}{"x", float64(3)}}
+	clc := sv.addTestClient("ua", 100, rs)
+	msgs := received(clc)
+	if !assert.NotEmpty(t, msgs) {
+		t.FailNow()
+	}
+	assert.Equal(t, WSMsgTypeResume, msgs[0].Event)
+	assert.Equal(t, "xabcd", sv.ot.Text)
+	assert.Equal(t, 2, sv.ot.Revision)
+}
+
+func TestServerResumeAfterRestart(t *testing.T) {
+	sv := newTestServer("abc")
+	cla := sv.addTestClient("ua", 100, nil)
+	doc, ok := docEvent(received(cla))
+	if !assert.True(t, ok) {
+		t.FailNow()
+	}
+	for i := 0; i < 2; i++ {
+		err := sv.applyOp(cla.clientID, OpData{Revision: sv.ot.Revision, Operation: []interface{}{float64(3 + i), "d"}})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+	received(cla)
+
+	// Session is restarted with the saved text, and other client edits it with the same client ID
+	sv = newTestServer("abcdd")
+	sv.epoch = "e2"
+	clb := sv.addTestClient("ub", 100, nil)
+	err := sv.applyOp(clb.clientID, OpData{Revision: 0, Operation: []interface{}{"y", float64(5)}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, doc.ID, clb.clientID)
+
+	// The pending operation is based on revision 1 of old session, so it must not be applied to the new history
+	rs := &ResumeData{ClientID: doc.ID, Revision: 1, Epoch: doc.Epoch, Operation: []interface{}{float64(4), "x"}}
+	clc := sv.addTestClient("ua", 100, rs)
+	msgs := received(clc)
+	if !assert.Len(t, msgs, 2) {
+		t.FailNow()
+	}
+	assert.Equal(t, WSMsgTypeConflict, msgs[0].Event)
+	newdoc, ok := docEvent(msgs)
+	if !assert.True(t, ok) {
+		t.FailNow()
+	}
+	assert.Equal(t, "yabcdd", newdoc.Document)
+	assert.Equal(t, "e2", newdoc.Epoch)
+	assert.Equal(t, "yabcdd", sv.ot.Text)
+	assert.Equal(t, 1, sv.ot.Revision)
+
+	// Without pending operation, whole document is sent
+	rs = &ResumeData{ClientID: doc.ID, Revision: 0, Epoch: doc.Epoch}
+	cld := sv.addTestClient("ua", 100, rs)
+	msgs = received(cld)
+	if !assert.Len(t, msgs, 1) {
+		t.FailNow()
+	}
+	assert.Equal(t, WSMsgTypeDoc, msgs[0].Event)
+}
+
+func TestServerEditable(t *testing.T) {
+	sv := newTestServer("abc")
+	cla := sv.addTestClient("ua", 100, nil)
+	doc, ok := docEvent(received(cla))
+	if !assert.True(t, ok) {
+		t.FailNow()
+	}
+	assert.True(t, doc.Editable)
+
+	clb := &Client{
+		profile:  ClientProfile{UUID: "ub"},
+		sv2cl:    make(chan otWSMessage, 100),
+		evicted:  make(chan struct{}),
+		readOnly: true,
+	}
+	sv.addClient(&otClientRequest{ready: make(chan error, 1), client: clb})
+	doc, ok = docEvent(received(clb))
+	if !assert.True(t, ok) {
+		t.FailNow()
+	}
+	assert.False(t, doc.Editable)
+}
@@ -0,0 +1,113 @@
+package ot
+
+import (
+	"errors"
+	"time"
+
+	"github.com/wonder-wonder/cakemix-server/util"
+)
+
+// Max number of undo steps for each user
+const maxUndoSteps = 100
+
+// Continuous operations within this interval are merged into one undo step
+const undoMergeInterval = time.Second
+
+type undoEntry struct {
+	rev  int // Revision which ops is based on
+	ops  Ops // Inverse operation
+	time time.Time
+}
+
+type undoStack struct {
+	undo []undoEntry
+	redo []undoEntry
+}
+
+// recordUndo pushes the inverse of the user's operation into the undo stack. before is the text before the operation.
+func (sv *Server) recordUndo(uuid string, before string, ops Ops) {
+	inv, err := ops.Invert(before)
+	if err != nil {
+		util.Errorf("Session(%s) undo error: %v", sv.docID, err)
+		return
+	}
+	st, ok := sv.undo[uuid]
+	if !ok {
+		st = &undoStack{}
+		sv.undo[uuid] = st
+	}
+	// New operation discards redo
+	st.redo = nil
+
+	now := time.Now()
+	// Merge into the last step if no one edited after it (e.g. typing)
+	if l := len(st.undo); l > 0 && st.undo[l-1].rev == sv.ot.Revision-1 && now.Sub(st.undo[l-1].time) < undoMergeInterval {
+		merged, err := inv.Compose(st.undo[l-1].ops)
+		if err == nil {
+			st.undo[l-1] = undoEntry{rev: sv.ot.Revision, ops: merged, time: now}
+			return
+		}
+	}
+	st.undo = pushUndo(st.undo, undoEntry{rev: sv.ot.Revision, ops: inv, time: now})
+}
+
+func pushUndo(stack []undoEntry, e undoEntry) []undoEntry {
+	stack = append(stack, e)
+	if len(stack) > maxUndoSteps {
+		stack = stack[len(stack)-maxUndoSteps:]
+	}
+	return stack
+}
+
+// undoRedo reverts the last operation of the user. The inverse operation is transformed against the later operations by others.
+func (sv *Server) undoRedo(clientID string, redo bool) {
+	cl, ok := sv.clients[clientID]
+	if !ok {
+		return
+	}
+	if !cl.editable {
+		sv.sendError(cl, ErrorCodeUndoUnavailable, errors.New("permission denied"))
+		return
+	}
+	st, ok := sv.undo[cl.profile.UUID]
+	if !ok {
+		st = &undoStack{}
+		sv.undo[cl.profile.UUID] = st
+	}
+	src, dst := &st.undo, &st.redo
+	if redo {
+		src, dst = &st.redo, &st.undo
+	}
+	if len(*src) == 0 {
+		sv.sendError(cl, ErrorCodeUndoUnavailable, errors.New("nothing to undo"))
+		return
+	}
+	e := (*src)[len(*src)-1]
+	*src = (*src)[:len(*src)-1]
+
+	ops, err := sv.ot.Transform(e.rev, e.ops)
+	if err != nil {
+		// Older steps can't be transformed either
+		*src = nil
+		sv.sendError(cl, ErrorCodeUndoUnavailable, err)
+		return
+	}
+	ops.User = clientID
+	before := sv.ot.Text
+	optrans, err := sv.ot.Operate(sv.ot.Revision, ops)
+	if err != nil {
+		sv.sendError(cl, ErrorCodeUndoUnavailable, err)
+		return
+	}
+	inv, err := optrans.Invert(before)
+	if err == nil {
+		*dst = pushUndo(*dst, undoEntry{rev: sv.ot.Revision, ops: inv, time: time.Now()})
+	}
+
+	// The client doesn't know the operation, so send it to the client too
+	sv.broadcast("", otWSMessage{
+		Event: WSMsgTypeOp,
+		Data:  []interface{}{clientID, opsToRaw(optrans), Ranges{Ranges: []SelData{}}},
+	})
+	sv.updated(cl.profile.UUID, optrans)
+}
@@ -0,0 +1,69 @@
+package ot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUndoAfterTransform(t *testing.T) {
+	sv := newTestServer("abc")
+	cla := sv.addTestClient("ua", 100, nil)
+	clb := sv.addTestClient("ub", 100, nil)
+
+	// A inserts at the head, then B appends based on the old revision
+	err := sv.applyOp(cla.clientID, OpData{Revision: 0, Operation: []interface{}{"x", float64(3)}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	err = sv.applyOp(clb.clientID, OpData{Revision: 0, Operation: []interface{}{float64(3), "y"}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "xabcy", sv.ot.Text)
+
+	// Undo by A removes only A's text at the transformed position
+	sv.undoRedo(cla.clientID, false)
+	assert.Equal(t, "abcy", sv.ot.Text)
+
+	// B edits again, then redo by A restores A's text
+	err = sv.applyOp(clb.clientID, OpData{Revision: sv.ot.Revision, Operation: []interface{}{float64(4), "z"}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	sv.undoRedo(cla.clientID, true)
+	assert.Equal(t, "xabcyz", sv.ot.Text)
+
+	// B's undo stack is independent of A
+	sv.undoRedo(clb.clientID, false)
+	assert.Equal(t, "xabcy", sv.ot.Text)
+}
+
+func TestUndoMerge(t *testing.T) {
+	sv := newTestServer("")
+	cl := sv.addTestClient("ua", 100, nil)
+	for i, c := range []string{"a", "b", "c"} {
+		err := sv.applyOp(cl.clientID, OpData{Revision: sv.ot.Revision, Operation: []interface{}{float64(i), c}})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+	// Continuous typing is one undo step
+	sv.undoRedo(cl.clientID, false)
+	assert.Equal(t, "", sv.ot.Text)
+
+	// Older steps than the merge interval are separated
+	err := sv.applyOp(cl.clientID, OpData{Revision: sv.ot.Revision, Operation: []interface{}{"a"}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	st := sv.undo["ua"]
+	st.undo[len(st.undo)-1].time = time.Now().Add(-undoMergeInterval)
+	err = sv.applyOp(cl.clientID, OpData{Revision: sv.ot.Revision, Operation: []interface{}{float64(1), "b"}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	sv.undoRedo(cl.clientID, false)
+	assert.Equal(t, "a", sv.ot.Text)
+}
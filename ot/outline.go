@@ -0,0 +1,216 @@
+package ot
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/wonder-wonder/cakemix-server/db"
+)
+
+// Heading is structure for a markdown heading. Offsets are in UTF-16 units as same as the operations.
+type Heading struct {
+	Level  int
+	Text   string
+	Anchor string
+	// Line number of the heading (1-based)
+	Line int
+	// Offset is the start of the heading line and End is the end of the section
+	Offset int
+	End    int
+	// Start of the section body
+	body int
+}
+
+var (
+	atxHeadingPattern    = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	setextHeadingPattern = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	fencePattern         = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+)
+
+// Outline parses the markdown headings of the text. Headings in front matter and fenced code blocks are ignored.
+func Outline(text string) []Heading {
+	res := []Heading{}
+	lines := strings.SplitAfter(text, "\n")
+	offset := 0
+	start := 0
+	fm, _ := db.SplitFrontMatter(text)
+	if fm != "" {
+		// Skip the front matter including both delimiters
+		for i, l := range lines {
+			offset += utf16Len(l)
+			if i > 0 && (strings.TrimRight(l, "\r\n") == "---" || strings.TrimRight(l, "\r\n") == "...") {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	fence := ""
+	// Paragraph which may become setext heading
+	prevOffset := 0
+	prevLine := 0
+	prevText := ""
+	for i := start; i < len(lines); i++ {
+		l := strings.TrimRight(lines[i], "\r\n")
+		lineOffset := offset
+		offset += utf16Len(lines[i])
+
+		if fence != "" {
+			if strings.HasPrefix(strings.TrimLeft(l, " "), fence) {
+				fence = ""
+			}
+			continue
+		}
+		if m := fencePattern.FindStringSubmatch(l); m != nil {
+			fence = m[1]
+			prevText = ""
+			continue
+		}
+		if m := atxHeadingPattern.FindStringSubmatch(l); m != nil {
+			res = append(res, Heading{Level: len(m[1]), Text: strings.TrimSpace(m[2]), Line: i + 1, Offset: lineOffset, body: offset})
+			prevText = ""
+			continue
+		}
+		if m := setextHeadingPattern.FindStringSubmatch(l); m != nil && prevText != "" {
+			level := 1
+			if m[1][0] == '-' {
+				level = 2
+			}
+			res = append(res, Heading{Level: level, Text: prevText, Line: prevLine, Offset: prevOffset, body: offset})
+			prevText = ""
+			continue
+		}
+		if strings.TrimSpace(l) == "" {
+			prevText = ""
+		} else if prevText == "" {
+			prevText = strings.TrimSpace(l)
+			prevOffset = lineOffset
+			prevLine = i + 1
+		} else {
+			prevText += " " + strings.TrimSpace(l)
+		}
+	}
+
+	// Section ends at the next heading of the same or higher level
+	used := map[string]int{}
+	for i := range res {
+		res[i].End = offset
+		for j := i + 1; j < len(res); j++ {
+			if res[j].Level <= res[i].Level {
+				res[i].End = res[j].Offset
+				break
+			}
+		}
+		res[i].Anchor = uniqueAnchor(Slugify(res[i].Text), used)
+	}
+	return res
+}
+
+// Slugify converts the heading text into the anchor (lower case, spaces to hyphens, punctuations removed)
+func Slugify(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(text)) {
+		switch {
+		case r == ' ' || r == '-':
+			b.WriteRune('-')
+		case r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r):
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// uniqueAnchor adds the number suffix to the duplicated anchor like "section-1"
+func uniqueAnchor(anchor string, used map[string]int) string {
+	res := anchor
+	for {
+		n, ok := used[res]
+		if !ok {
+			break
+		}
+		used[res] = n + 1
+		res = anchor + "-" + strconv.Itoa(n+1)
+	}
+	used[res] = 0
+	return res
+}
+
+// FindHeading returns the heading of the anchor
+func FindHeading(outline []Heading, anchor string) (Heading, bool) {
+	for _, v := range outline {
+		if v.Anchor == anchor {
+			return v, true
+		}
+	}
+	return Heading{}, false
+}
+
+// SectionAt returns the innermost heading whose section contains the offset
+func SectionAt(outline []Heading, offset int) (Heading, bool) {
+	res := Heading{}
+	found := false
+	for _, v := range outline {
+		if v.Offset <= offset && offset < v.End || v.Offset == offset {
+			res = v
+			found = true
+		}
+	}
+	return res, found
+}
+
+// TransformPosition returns the position after the operation is applied. The position in deleted text moves to the start of deletion.
+func TransformPosition(pos int, ops Ops) int {
+	cur := 0
+	res := pos
+	for _, v := range ops.Ops {
+		if cur > pos {
+			break
+		}
+		switch v.OpType {
+		case OpTypeRetain:
+			cur += v.Len
+		case OpTypeInsert:
+			res += v.Len
+		case OpTypeDelete:
+			if pos < cur+v.Len {
+				res -= pos - cur
+				return res
+			}
+			res -= v.Len
+			cur += v.Len
+		}
+	}
+	return res
+}
+
+// ResolveAnchor finds the heading of the anchor in the text. If the anchor is not found,
+// the latest revision having the anchor is searched from revs (older first) and its position is mapped to the current text.
+// The revision where the anchor is found is also returned.
+func ResolveAnchor(text string, rev int, revs []db.DocumentRevision, anchor string) (Heading, int, bool) {
+	outline := Outline(text)
+	if h, ok := FindHeading(outline, anchor); ok {
+		return h, rev, true
+	}
+	for i := len(revs) - 1; i >= 0; i-- {
+		old, ok := FindHeading(Outline(revs[i].Text), anchor)
+		if !ok {
+			continue
+		}
+		// The body is more stable than the heading which may be renamed
+		pos := old.Offset
+		if old.body < old.End {
+			pos = old.body
+		}
+		pos = TransformPosition(pos, Diff(revs[i].Text, text))
+		h, ok := SectionAt(outline, pos)
+		return h, revs[i].Revision, ok
+	}
+	return Heading{}, 0, false
+}
+
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
@@ -0,0 +1,53 @@
+package ot
+
+import "github.com/wonder-wonder/cakemix-server/db"
+
+// TimelineChange is a change of the document in the timeline
+type TimelineChange struct {
+	Revision int
+	Time     int64 // Unix time in milliseconds
+	UUID     string
+	Ops      []interface{}
+}
+
+// BuildTimeline builds the changes between the revisions.
+// The stored operations are used if they are consistent with the revisions, otherwise the difference of the revisions is used.
+func BuildTimeline(revs []db.DocumentRevision, ops map[int][]db.DocumentOp) []TimelineChange {
+	res := []TimelineChange{}
+	for i := 1; i < len(revs); i++ {
+		prev, cur := revs[i-1], revs[i]
+		if changes, ok := replayOps(prev.Text, cur, ops[cur.Revision]); ok {
+			res = append(res, changes...)
+			continue
+		}
+		if prev.Text == cur.Text {
+			continue
+		}
+		res = append(res, TimelineChange{
+			Revision: cur.Revision,
+			Time:     cur.UpdatedAt * 1000,
+			Ops:      opsToRaw(Diff(prev.Text, cur.Text)),
+		})
+	}
+	return res
+}
+
+// replayOps applies the operations to the text and checks the result is same as the revision
+func replayOps(text string, rev db.DocumentRevision, ops []db.DocumentOp) ([]TimelineChange, bool) {
+	if len(ops) == 0 {
+		return nil, false
+	}
+	res := []TimelineChange{}
+	for _, v := range ops {
+		var err error
+		text, err = applyOps(text, rawToOps(v.UUID, v.Ops))
+		if err != nil {
+			return nil, false
+		}
+		res = append(res, TimelineChange{Revision: rev.Revision, Time: v.Time, UUID: v.UUID, Ops: v.Ops})
+	}
+	if text != rev.Text {
+		return nil, false
+	}
+	return res, true
+}
@@ -1,209 +1,447 @@
 package util
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
-)
+	"time"
 
-// Default
-var (
-	dbHost                    = ""
-	dbPort                    = ""
-	dbUser                    = ""
-	dbPass                    = ""
-	dbName                    = ""
-	apiHost                   = "localhost"
-	apiPort                   = "8081"
-	apiCORS                   = ""
-	apiPermitUserToCreateTeam = false
-	frontDir                  = "/usr/share/cakemix/www"
-	dataDir                   = "/var/lib/cakemix"
-	signPubKey                = "/etc/cakemix/keys/signkey.pub"
-	signPrvKey                = "/etc/cakemix/keys/signkey"
-	logFile                   = ""
-	sendgridAPIKey            = ""
-	fromAddr                  = "cakemix@localhost"
-	fromName                  = "Cakemix"
-	tmplResetPW               = "/usr/share/cakemix/mail/resetpw.tmpl"
-	tmplRegist                = "/usr/share/cakemix/mail/regist.tmpl"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
 )
 
+// Config is structure for whole configuration.
+// Each item has conf tag (key name in legacy config file, case insensitive)
+// and env tag (environment variable names, comma separated) in addition to yaml and toml tags.
+// The item which has reload tag can be changed without restart.
+type Config struct {
+	DB   DBConf   `yaml:"db" toml:"db"`
+	API  APIConf  `yaml:"api" toml:"api"`
+	File FileConf `yaml:"file" toml:"file"`
+	Mail MailConf `yaml:"mail" toml:"mail"`
+	OT   OTConf   `yaml:"ot" toml:"ot"`
+}
+
 // DBConf is structure for database configuration
 type DBConf struct {
-	Host string
-	Port string
-	User string
-	Pass string
-	Name string
+	Host            string        `yaml:"host" toml:"host" conf:"DBHost" env:"DBHOST"`
+	Port            string        `yaml:"port" toml:"port" conf:"DBPort" env:"DBPORT"`
+	User            string        `yaml:"user" toml:"user" conf:"DBUser" env:"DBUSER"`
+	Pass            string        `yaml:"pass" toml:"pass" conf:"DBPass" env:"DBPASS" secret:"true"`
+	Name            string        `yaml:"name" toml:"name" conf:"DBName" env:"DBNAME"`
+	URL             string        `yaml:"url" toml:"url" conf:"DBURL" env:"DBURL,DATABASE_URL" secret:"true"`
+	SSLMode         string        `yaml:"sslmode" toml:"sslmode" conf:"DBSSLMode" env:"DBSSLMODE"`
+	SSLRootCert     string        `yaml:"sslrootcert" toml:"sslrootcert" conf:"DBSSLRootCert" env:"DBSSLROOTCERT"`
+	SSLCert         string        `yaml:"sslcert" toml:"sslcert" conf:"DBSSLCert" env:"DBSSLCERT"`
+	SSLKey          string        `yaml:"sslkey" toml:"sslkey" conf:"DBSSLKey" env:"DBSSLKEY"`
+	MaxOpenConns    int           `yaml:"maxopenconns" toml:"maxopenconns" conf:"DBMaxOpenConns" env:"DBMAXOPENCONNS"`
+	MaxIdleConns    int           `yaml:"maxidleconns" toml:"maxidleconns" conf:"DBMaxIdleConns" env:"DBMAXIDLECONNS"`
+	ConnMaxLifetime time.Duration `yaml:"connmaxlifetime" toml:"connmaxlifetime" conf:"DBConnMaxLifetime" env:"DBCONNMAXLIFETIME"`
+	ConnectRetry    int           `yaml:"connectretry" toml:"connectretry" conf:"DBConnectRetry" env:"DBCONNECTRETRY"`
 }
 
 // APIConf is structure for API configuration
 type APIConf struct {
-	Host                   string
-	Port                   string
-	CORS                   string
-	PermitUserToCreateTeam bool
+	Host                   string   `yaml:"host" toml:"host" conf:"APIHost" env:"APIHOST"`
+	Port                   string   `yaml:"port" toml:"port" conf:"APIPort" env:"APIPORT"`
+	CORS                   []string `yaml:"cors" toml:"cors" conf:"APICORS" env:"APICORS" reload:"true"`
+	PermitUserToCreateTeam bool     `yaml:"permitusertocreateteam" toml:"permitusertocreateteam" conf:"PermitUserToCreateTeam" env:"PERMITUSERTOCREATETEAM" reload:"true"`
+	Socket                 string   `yaml:"socket" toml:"socket" conf:"APISocket" env:"APISOCKET"`
+	TLSCert                string   `yaml:"tlscert" toml:"tlscert" conf:"APITLSCert" env:"APITLSCERT"`
+	TLSKey                 string   `yaml:"tlskey" toml:"tlskey" conf:"APITLSKey" env:"APITLSKEY"`
+	TLSMinVersion          string   `yaml:"tlsminversion" toml:"tlsminversion" conf:"APITLSMinVersion" env:"APITLSMINVERSION"`
+	HTTPRedirectPort       string   `yaml:"httpredirectport" toml:"httpredirectport" conf:"APIHTTPRedirectPort" env:"APIHTTPREDIRECTPORT"`
 }
 
 // FileConf is structure for file configuration
 type FileConf struct {
-	FrontDir   string
-	DataDir    string
-	SignPubKey string
-	SignPrvKey string
-	LogFile    string
+	FrontDir   string `yaml:"frontdir" toml:"frontdir" conf:"FrontDir" env:"FRONTDIR"`
+	DataDir    string `yaml:"datadir" toml:"datadir" conf:"DataDir" env:"DATADIR"`
+	SignPubKey string `yaml:"signpubkey" toml:"signpubkey" conf:"SignPubKey" env:"SIGNPUBKEY"`
+	SignPrvKey string `yaml:"signprvkey" toml:"signprvkey" conf:"SignPrvKey" env:"SIGNPRVKEY"`
+	LogFile    string `yaml:"logfile" toml:"logfile" conf:"LogFile" env:"LOGFILE" reload:"true"`
+	LogLevel   string `yaml:"loglevel" toml:"loglevel" conf:"LogLevel" env:"LOGLEVEL" reload:"true"`
 }
 
 // MailConf is structure for mail configuration
 type MailConf struct {
-	SendGridAPIKey string
-	FromAddr       string
-	FromName       string
-	TmplResetPW    string
-	TmplRegist     string
+	SendGridAPIKey string `yaml:"sgapikey" toml:"sgapikey" conf:"MailSGAPIKey" env:"MAILSGAPIKEY,SENDGRID_API_KEY" secret:"true" reload:"true"`
+	FromAddr       string `yaml:"fromaddr" toml:"fromaddr" conf:"MailFromAddr" env:"MAILFROMADDR" reload:"true"`
+	FromName       string `yaml:"fromname" toml:"fromname" conf:"MailFromName" env:"MAILFROMNAME" reload:"true"`
+	TmplResetPW    string `yaml:"resetpwtmpl" toml:"resetpwtmpl" conf:"MailResetPWTmpl" env:"MAILRESETPWTMPL" reload:"true"`
+	TmplRegist     string `yaml:"registtmpl" toml:"registtmpl" conf:"MailRegistTmpl" env:"MAILREGISTTMPL" reload:"true"`
 }
 
-// LoadConfigEnv reads config from environment variable
-func LoadConfigEnv() {
-	// DB config
-	if os.Getenv("DBHOST") != "" {
-		dbHost = os.Getenv("DBHOST")
-	}
-	if os.Getenv("DBPORT") != "" {
-		dbPort = os.Getenv("DBPORT")
-	}
-	if os.Getenv("DBUSER") != "" {
-		dbUser = os.Getenv("DBUSER")
-	}
-	if os.Getenv("DBPASS") != "" {
-		dbPass = os.Getenv("DBPASS")
-	}
-	if os.Getenv("DBNAME") != "" {
-		dbName = os.Getenv("DBNAME")
-	}
+// OTConf is structure for OT session configuration
+type OTConf struct {
+	AutoSaveInterval   time.Duration `yaml:"autosaveinterval" toml:"autosaveinterval" conf:"OTAutoSaveInterval" env:"OTAUTOSAVEINTERVAL"`
+	HistGCThreshold    int           `yaml:"histgcthreshold" toml:"histgcthreshold" conf:"OTHistGCThreshold" env:"OTHISTGCTHRESHOLD"`
+	ClientPingInterval time.Duration `yaml:"clientpinginterval" toml:"clientpinginterval" conf:"OTClientPingInterval" env:"OTCLIENTPINGINTERVAL"`
+	ServerStopDelay    time.Duration `yaml:"serverstopdelay" toml:"serverstopdelay" conf:"OTServerStopDelay" env:"OTSERVERSTOPDELAY"`
+	MaxOpsPerSecond    int           `yaml:"maxopspersecond" toml:"maxopspersecond" conf:"OTMaxOpsPerSecond" env:"OTMAXOPSPERSECOND"`
+	MaxMessageSize     int           `yaml:"maxmessagesize" toml:"maxmessagesize" conf:"OTMaxMessageSize" env:"OTMAXMESSAGESIZE"`
+	MaxDocumentSize    int           `yaml:"maxdocumentsize" toml:"maxdocumentsize" conf:"OTMaxDocumentSize" env:"OTMAXDOCUMENTSIZE"`
+}
+
+// ValidationError is error of config validation. It holds all invalid items.
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e, "\n  - ")
+}
 
-	// Mail config
-	if os.Getenv("SENDGRID_API_KEY") != "" {
-		sendgridAPIKey = os.Getenv("SENDGRID_API_KEY")
+// DefaultConfig returns config filled by default values
+func DefaultConfig() Config {
+	return Config{
+		DB: DBConf{
+			SSLMode:      "disable",
+			ConnectRetry: 10,
+		},
+		API: APIConf{
+			Host:          "localhost",
+			Port:          "8081",
+			TLSMinVersion: "1.2",
+		},
+		File: FileConf{
+			FrontDir:   "/usr/share/cakemix/www",
+			DataDir:    "/var/lib/cakemix",
+			SignPubKey: "/etc/cakemix/keys/signkey.pub",
+			SignPrvKey: "/etc/cakemix/keys/signkey",
+			LogLevel:   "info",
+		},
+		Mail: MailConf{
+			FromAddr:    "cakemix@localhost",
+			FromName:    "Cakemix",
+			TmplResetPW: "/usr/share/cakemix/mail/resetpw.tmpl",
+			TmplRegist:  "/usr/share/cakemix/mail/regist.tmpl",
+		},
+		OT: OTConf{
+			AutoSaveInterval:   time.Minute,
+			HistGCThreshold:    200,
+			ClientPingInterval: 30 * time.Second,
+			ServerStopDelay:    30 * time.Second,
+			MaxOpsPerSecond:    50,
+			MaxMessageSize:     1 << 20,
+			MaxDocumentSize:    10 << 20,
+		},
 	}
 }
 
-// LoadConfigFile reads config from file
-func LoadConfigFile(path string) error {
+// LoadConfigFile reads config from file and overwrites conf.
+// The format is detected by the extension (.yml/.yaml, .toml or legacy key-value format).
+func LoadConfigFile(path string, conf *Config) error {
 	// #nosec G304
 	raw, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
 	}
-	lines := strings.Split(string(raw), "\n")
-	for _, v := range lines {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		err = yaml.UnmarshalStrict(raw, conf)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.NewDecoder(bytes.NewReader(raw)).Decode(conf)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if undec := md.Undecoded(); len(undec) > 0 {
+			return fmt.Errorf("%s: unknown option: %v", path, undec[0])
+		}
+	default:
+		err = loadLegacyConfig(string(raw), conf)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func loadLegacyConfig(raw string, conf *Config) error {
+	lines := strings.Split(raw, "\n")
+	for i, v := range lines {
 		// Remove comment
 		line := strings.SplitN(v, "#", 2)[0]
 		// Remove leading and trailing extra spaces
-		line = strings.Trim(line, " ")
+		line = strings.TrimSpace(line)
 		// Ignore empty line
 		if len(line) == 0 {
 			continue
 		}
-		confs := strings.SplitN(v, " ", 2)
+		confs := strings.SplitN(line, " ", 2)
 		if len(confs) != 2 {
 			confs = append(confs, "")
 		}
-		confkey := strings.Trim(confs[0], " ")
-		confvalue := strings.Trim(confs[1], " ")
-		switch strings.ToLower(confkey) {
-		case "dbhost":
-			dbHost = confvalue
-		case "dbport":
-			dbPort = confvalue
-		case "dbuser":
-			dbUser = confvalue
-		case "dbpass":
-			dbPass = confvalue
-		case "dbname":
-			dbName = confvalue
-		case "apihost":
-			apiHost = confvalue
-		case "apiport":
-			apiPort = confvalue
-		case "apicors":
-			apiCORS = confvalue
-		case "permitusertocreateteam":
-			confstrlower := strings.ToLower(confvalue)
-			if confstrlower == "no" || confstrlower == "false" || confstrlower == "disable" {
-				apiPermitUserToCreateTeam = false
+		confkey := strings.TrimSpace(confs[0])
+		confvalue := strings.TrimSpace(confs[1])
+		found := false
+		err := walkConfig(conf, func(f reflect.StructField, fv reflect.Value) error {
+			if !strings.EqualFold(f.Tag.Get("conf"), confkey) {
+				return nil
 			}
-			if confstrlower == "yes" || confstrlower == "true" || confstrlower == "enable" {
-				apiPermitUserToCreateTeam = true
+			found = true
+			return setConfigValue(fv, confvalue)
+		})
+		if err != nil {
+			return fmt.Errorf("line %d: invalid value for %s: %w", i+1, confkey, err)
+		}
+		if !found {
+			return fmt.Errorf("line %d: unknown option: %v", i+1, confkey)
+		}
+	}
+	return nil
+}
+
+// LoadConfigEnv reads config from environment variable and overwrites conf
+func LoadConfigEnv(conf *Config) error {
+	return walkConfig(conf, func(f reflect.StructField, fv reflect.Value) error {
+		for _, env := range strings.Split(f.Tag.Get("env"), ",") {
+			val := os.Getenv(env)
+			if env == "" || val == "" {
+				continue
 			}
-		case "frontdir":
-			frontDir = confvalue
-		case "datadir":
-			dataDir = confvalue
-		case "signpubkey":
-			signPubKey = confvalue
-		case "signprvkey":
-			signPrvKey = confvalue
-		case "logfile":
-			logFile = confvalue
-		case "mailsgapikey":
-			sendgridAPIKey = confvalue
-		case "mailfromaddr":
-			fromAddr = confvalue
-		case "mailfromname":
-			fromName = confvalue
-		case "mailresetpwtmpl":
-			tmplResetPW = confvalue
-		case "mailregisttmpl":
-			tmplRegist = confvalue
-		default:
-			return fmt.Errorf("unknown option: %v", confkey)
+			err := setConfigValue(fv, val)
+			if err != nil {
+				return fmt.Errorf("invalid value for %s: %w", env, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Validate checks config values
+func (c Config) Validate() error {
+	errs := ValidationError{}
+
+	// DB
+	if c.DB.URL == "" {
+		if c.DB.Host == "" {
+			errs = append(errs, "db.host: required unless db.url is specified")
+		}
+		if c.DB.Name == "" {
+			errs = append(errs, "db.name: required unless db.url is specified")
 		}
+		if c.DB.Port != "" && !isValidPort(c.DB.Port) {
+			errs = append(errs, "db.port: should be number between 1 and 65535")
+		}
+	}
+	switch c.DB.SSLMode {
+	case "", "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		errs = append(errs, "db.sslmode: should be one of disable, allow, prefer, require, verify-ca or verify-full")
+	}
+	for _, v := range [][2]string{{"db.sslrootcert", c.DB.SSLRootCert}, {"db.sslcert", c.DB.SSLCert}, {"db.sslkey", c.DB.SSLKey}} {
+		if v[1] == "" {
+			continue
+		}
+		if _, err := os.Stat(v[1]); err != nil {
+			errs = append(errs, v[0]+": "+err.Error())
+		}
+	}
+	if c.DB.MaxOpenConns < 0 {
+		errs = append(errs, "db.maxopenconns: should not be negative")
+	}
+	if c.DB.MaxIdleConns < 0 {
+		errs = append(errs, "db.maxidleconns: should not be negative")
+	}
+	if c.DB.ConnMaxLifetime < 0 {
+		errs = append(errs, "db.connmaxlifetime: should not be negative")
+	}
+	if c.DB.ConnectRetry < 0 {
+		errs = append(errs, "db.connectretry: should not be negative")
+	}
+
+	// API
+	if c.API.Socket == "" && !isValidPort(c.API.Port) {
+		errs = append(errs, "api.port: should be number between 1 and 65535")
+	}
+	if (c.API.TLSCert == "") != (c.API.TLSKey == "") {
+		errs = append(errs, "api.tlscert, api.tlskey: both should be specified to enable TLS")
+	}
+	for _, v := range [][2]string{{"api.tlscert", c.API.TLSCert}, {"api.tlskey", c.API.TLSKey}} {
+		if v[1] == "" {
+			continue
+		}
+		if _, err := os.Stat(v[1]); err != nil {
+			errs = append(errs, v[0]+": "+err.Error())
+		}
+	}
+	if _, err := ParseTLSVersion(c.API.TLSMinVersion); err != nil {
+		errs = append(errs, "api.tlsminversion: should be one of 1.0, 1.1, 1.2 or 1.3")
+	}
+	if c.API.HTTPRedirectPort != "" {
+		if c.API.TLSCert == "" {
+			errs = append(errs, "api.httpredirectport: TLS should be enabled")
+		}
+		if !isValidPort(c.API.HTTPRedirectPort) {
+			errs = append(errs, "api.httpredirectport: should be number between 1 and 65535")
+		}
+	}
+
+	// File
+	if c.File.DataDir == "" {
+		errs = append(errs, "file.datadir: required")
+	}
+	if c.File.SignPubKey == "" {
+		errs = append(errs, "file.signpubkey: required")
+	}
+	if c.File.SignPrvKey == "" {
+		errs = append(errs, "file.signprvkey: required")
+	}
+	if _, err := ParseLogLevel(c.File.LogLevel); err != nil {
+		errs = append(errs, "file.loglevel: should be one of debug, info, warn or error")
+	}
+
+	// Mail (template files are used only if sending mail is configured)
+	for _, v := range [][2]string{{"mail.resetpwtmpl", c.Mail.TmplResetPW}, {"mail.registtmpl", c.Mail.TmplRegist}} {
+		if v[1] == "" {
+			errs = append(errs, v[0]+": required")
+			continue
+		}
+		if c.Mail.SendGridAPIKey == "" {
+			continue
+		}
+		if _, err := os.Stat(v[1]); err != nil {
+			errs = append(errs, v[0]+": "+err.Error())
+		}
+	}
+
+	// OT
+	if c.OT.AutoSaveInterval <= 0 {
+		errs = append(errs, "ot.autosaveinterval: should be positive")
+	}
+	if c.OT.HistGCThreshold <= 0 {
+		errs = append(errs, "ot.histgcthreshold: should be positive")
+	}
+	if c.OT.ClientPingInterval <= 0 {
+		errs = append(errs, "ot.clientpinginterval: should be positive")
+	}
+	if c.OT.ServerStopDelay < 0 {
+		errs = append(errs, "ot.serverstopdelay: should not be negative")
+	}
+	if c.OT.MaxOpsPerSecond <= 0 {
+		errs = append(errs, "ot.maxopspersecond: should be positive")
+	}
+	if c.OT.MaxMessageSize <= 0 {
+		errs = append(errs, "ot.maxmessagesize: should be positive")
+	}
+	if c.OT.MaxDocumentSize <= 0 {
+		errs = append(errs, "ot.maxdocumentsize: should be positive")
+	}
+
+	if len(errs) > 0 {
+		return errs
 	}
 	return nil
 }
 
-// GetDBConf returns database config
-func GetDBConf() DBConf {
-	return DBConf{
-		Host: dbHost,
-		Port: dbPort,
-		User: dbUser,
-		Pass: dbPass,
-		Name: dbName,
+// ApplyReloadable overwrites the reloadable items by newconf.
+// It returns the names of changed items and the names of items which need restart to apply.
+func (c *Config) ApplyReloadable(newconf Config) ([]string, []string) {
+	changed := []string{}
+	needRestart := []string{}
+	nv := reflect.ValueOf(newconf)
+	cv := reflect.ValueOf(c).Elem()
+	ct := cv.Type()
+	for i := 0; i < cv.NumField(); i++ {
+		sv := cv.Field(i)
+		st := sv.Type()
+		for j := 0; j < sv.NumField(); j++ {
+			f := st.Field(j)
+			fv := sv.Field(j)
+			newfv := nv.Field(i).Field(j)
+			if reflect.DeepEqual(fv.Interface(), newfv.Interface()) {
+				continue
+			}
+			name := ct.Field(i).Tag.Get("yaml") + "." + f.Tag.Get("yaml")
+			if f.Tag.Get("reload") != "true" {
+				needRestart = append(needRestart, name)
+				continue
+			}
+			fv.Set(newfv)
+			changed = append(changed, name)
+		}
 	}
+	return changed, needRestart
+}
+
+// Masked returns copy of config whose secret values are masked
+func (c Config) Masked() Config {
+	_ = walkConfig(&c, func(f reflect.StructField, fv reflect.Value) error {
+		if f.Tag.Get("secret") == "true" && fv.String() != "" {
+			fv.SetString("********")
+		}
+		return nil
+	})
+	return c
 }
 
-// GetAPIConf returns API config
-func GetAPIConf() APIConf {
-	return APIConf{
-		Host:                   apiHost,
-		Port:                   apiPort,
-		CORS:                   apiCORS,
-		PermitUserToCreateTeam: apiPermitUserToCreateTeam,
+// YAML returns config in YAML format
+func (c Config) YAML() (string, error) {
+	raw, err := yaml.Marshal(c)
+	if err != nil {
+		return "", err
 	}
+	return string(raw), nil
+}
+
+func isValidPort(port string) bool {
+	p, err := strconv.Atoi(port)
+	return err == nil && p > 0 && p <= 65535
 }
 
-// GetFileConf returns file config
-func GetFileConf() FileConf {
-	return FileConf{
-		FrontDir:   frontDir,
-		DataDir:    dataDir,
-		SignPubKey: signPubKey,
-		SignPrvKey: signPrvKey,
-		LogFile:    logFile,
+// walkConfig calls fn for each config item
+func walkConfig(conf *Config, fn func(f reflect.StructField, fv reflect.Value) error) error {
+	cv := reflect.ValueOf(conf).Elem()
+	for i := 0; i < cv.NumField(); i++ {
+		sv := cv.Field(i)
+		st := sv.Type()
+		for j := 0; j < sv.NumField(); j++ {
+			err := fn(st.Field(j), sv.Field(j))
+			if err != nil {
+				return err
+			}
+		}
 	}
+	return nil
 }
 
-// GetMailConf returns mail config
-func GetMailConf() MailConf {
-	return MailConf{
-		SendGridAPIKey: sendgridAPIKey,
-		FromAddr:       fromAddr,
-		FromName:       fromName,
-		TmplResetPW:    tmplResetPW,
-		TmplRegist:     tmplRegist,
+func setConfigValue(fv reflect.Value, val string) error {
+	switch fv.Interface().(type) {
+	case string:
+		fv.SetString(val)
+	case int:
+		i, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(i))
+	case bool:
+		switch strings.ToLower(val) {
+		case "no", "false", "disable":
+			fv.SetBool(false)
+		case "yes", "true", "enable":
+			fv.SetBool(true)
+		default:
+			return errors.New("should be yes/no, true/false or enable/disable")
+		}
+	case time.Duration:
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+	case []string:
+		fv.Set(reflect.ValueOf(strings.Fields(strings.ReplaceAll(val, ",", " "))))
+	default:
+		return fmt.Errorf("unsupported type: %v", fv.Type())
 	}
+	return nil
 }
@@ -0,0 +1,120 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// LogLevel is enum of log level
+type LogLevel int32
+
+// LogLevel list
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+// ParseLogLevel converts string into LogLevel
+func ParseLogLevel(level string) (LogLevel, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return LogLevelDebug, nil
+	case "", "info":
+		return LogLevelInfo, nil
+	case "warn", "warning":
+		return LogLevelWarn, nil
+	case "error":
+		return LogLevelError, nil
+	}
+	return LogLevelInfo, errors.New("unknown log level: " + level)
+}
+
+var logLevel = int32(LogLevelInfo)
+
+// SetLogLevel changes log level. It can be called while running.
+func SetLogLevel(level LogLevel) {
+	atomic.StoreInt32(&logLevel, int32(level))
+}
+
+func logEnabled(level LogLevel) bool {
+	return level >= LogLevel(atomic.LoadInt32(&logLevel))
+}
+
+func logf(level LogLevel, format string, v ...interface{}) {
+	if !logEnabled(level) {
+		return
+	}
+	_ = log.Output(3, fmt.Sprintf(format, v...))
+}
+
+// Debugf writes log if the level is debug
+func Debugf(format string, v ...interface{}) { logf(LogLevelDebug, format, v...) }
+
+// Infof writes log if the level is info or lower
+func Infof(format string, v ...interface{}) { logf(LogLevelInfo, format, v...) }
+
+// Warnf writes log if the level is warn or lower
+func Warnf(format string, v ...interface{}) { logf(LogLevelWarn, format, v...) }
+
+// Errorf writes log in any level
+func Errorf(format string, v ...interface{}) { logf(LogLevelError, format, v...) }
+
+// LogWriter is writer for access log.
+// The output file can be reopened (e.g. after logrotate). Access log is written only if the log level is info or lower.
+type LogWriter struct {
+	mu   sync.Mutex
+	file *os.File
+	out  io.Writer
+}
+
+// NewLogWriter generates LogWriter which writes into stdout
+func NewLogWriter() *LogWriter {
+	return &LogWriter{out: os.Stdout}
+}
+
+// Open opens log file and switches the output into it. If logfile is empty, the output is stdout.
+func (w *LogWriter) Open(logfile string) error {
+	var out io.Writer = os.Stdout
+	var f *os.File
+	if logfile != "" {
+		// Make log directory
+		err := os.MkdirAll(path.Dir(logfile), 0700)
+		if err != nil {
+			return err
+		}
+		// #nosec G304
+		f, err = os.OpenFile(logfile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	old := w.file
+	w.file = f
+	w.out = out
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+func (w *LogWriter) Write(p []byte) (int, error) {
+	if !logEnabled(LogLevelInfo) {
+		return len(p), nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.Write(p)
+}
@@ -10,21 +10,19 @@ import (
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
 )
 
-var (
-	mailFromAddr = ""
-	mailFromName = ""
-	sgAPIKey     = ""
-)
+// Mailer is structure for sending mail
+type Mailer struct {
+	conf MailConf
+}
 
-// InitMail setup mail configuration
-func InitMail(sendgridAPIKey, fromAddr, fromName string) {
-	sgAPIKey = sendgridAPIKey
-	mailFromAddr = fromAddr
-	mailFromName = fromName
+// NewMailer generates new Mailer instance
+func NewMailer(conf MailConf) *Mailer {
+	return &Mailer{conf: conf}
 }
 
 // SendMail sends email. The mail is sent as plain text if textHTML is empty.
-func SendMail(ToAddr, ToName, subject, text, textHTML string) error {
+func (m *Mailer) SendMail(ToAddr, ToName, subject, text, textHTML string) error {
+	sgAPIKey := m.conf.SendGridAPIKey
 	if sgAPIKey == "" {
 		return nil
 	} else if sgAPIKey == "DEBUG" {
@@ -34,7 +32,7 @@ func SendMail(ToAddr, ToName, subject, text, textHTML string) error {
 			"Contents:\n%s\n", ToAddr, ToName, subject, text)
 		return nil
 	}
-	from := mail.NewEmail(mailFromName, mailFromAddr)
+	from := mail.NewEmail(m.conf.FromName, m.conf.FromAddr)
 	to := mail.NewEmail(ToName, ToAddr)
 	message := mail.NewSingleEmail(from, subject, to, text, textHTML)
 	client := sendgrid.NewSendClient(sgAPIKey)
@@ -46,7 +44,7 @@ func SendMail(ToAddr, ToName, subject, text, textHTML string) error {
 }
 
 // SendMailWithTemplate sends email using template file. The mail is sent as plain text if textHTML is empty.
-func SendMailWithTemplate(ToAddr, ToName, subject, tmplfile string, dat map[string]string) error {
+func (m *Mailer) SendMailWithTemplate(ToAddr, ToName, subject, tmplfile string, dat map[string]string) error {
 	// #nosec G304
 	raw, err := ioutil.ReadFile(tmplfile)
 	if err != nil {
@@ -56,5 +54,5 @@ func SendMailWithTemplate(ToAddr, ToName, subject, tmplfile string, dat map[stri
 	for k, v := range dat {
 		mailtext = strings.ReplaceAll(mailtext, "{{"+k+"}}", v)
 	}
-	return SendMail(ToAddr, ToName, subject, mailtext, "")
+	return m.SendMail(ToAddr, ToName, subject, mailtext, "")
 }
@@ -0,0 +1,107 @@
+package util
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// Interval to check the certificate files are changed
+const certCheckInterval = 10 * time.Second
+
+// ParseTLSVersion converts version string (e.g. "1.2") into tls.VersionTLSxx
+func ParseTLSVersion(ver string) (uint16, error) {
+	switch ver {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, errors.New("unknown TLS version: " + ver)
+}
+
+// CertReloader holds the certificate and reloads it automatically when the files are changed
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewCertReloader loads the certificate and generates CertReloader
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	cr := &CertReloader{certFile: certFile, keyFile: keyFile}
+	err := cr.reload()
+	if err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *CertReloader) latestModTime() (time.Time, error) {
+	certstat, err := os.Stat(cr.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keystat, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if keystat.ModTime().After(certstat.ModTime()) {
+		return keystat.ModTime(), nil
+	}
+	return certstat.ModTime(), nil
+}
+
+func (cr *CertReloader) reload() error {
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.cert = &cert
+	cr.modTime = modTime
+	return nil
+}
+
+// GetCertificate returns the certificate. It can be used for tls.Config.GetCertificate.
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if time.Since(cr.lastCheck) < certCheckInterval {
+		return cr.cert, nil
+	}
+	cr.lastCheck = time.Now()
+	modTime, err := cr.latestModTime()
+	if err != nil || !modTime.After(cr.modTime) {
+		return cr.cert, nil
+	}
+	// Keep using current certificate if the new one is broken (e.g. during update)
+	err = cr.reload()
+	if err != nil {
+		Errorf("TLS certificate reload error: %v", err)
+		return cr.cert, nil
+	}
+	Infof("TLS certificate reloaded")
+	return cr.cert, nil
+}
+
+// TLSConfig generates tls.Config using the certificate
+func (cr *CertReloader) TLSConfig(minVersion uint16) *tls.Config {
+	return &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: cr.GetCertificate,
+	}
+}